@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"openrtb-insights/internal/backup"
+	"openrtb-insights/internal/config"
+	"openrtb-insights/internal/database"
+)
+
+const (
+	backupUsage  = "usage: server backup [--dir dir] [--config file]"
+	restoreUsage = "usage: server restore [--config file] <archive>"
+)
+
+// runBackupCommand implements `server backup`, which writes a backup of
+// DB_PATH while the server is stopped. A running server holds the database
+// open; back it up with POST /api/admin/backups or BACKUP_INTERVAL instead.
+func runBackupCommand(configPath string, args []string) int {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags.StringVar(&configPath, "config", configPath, "YAML or TOML config file (default $CONFIG_FILE)")
+	dir := flags.String("dir", "", "directory to write the backup to (default $BACKUP_DIR)")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, backupUsage)
+		return 2
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+	if *dir == "" {
+		*dir = cfg.BackupDir
+	}
+
+	db, err := database.Connect(cfg.DBPath, cfg.DatabaseOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open %s: %v\nIf the server is running, use POST /api/admin/backups instead.\n", cfg.DBPath, err)
+		return 1
+	}
+	defer database.Close(db)
+
+	manager := backup.NewManager(db, backup.Config{Dir: *dir, Keep: cfg.BackupKeep, MaxAge: cfg.BackupMaxAge})
+	info, err := manager.Create(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Wrote %s/%s (%d bytes, schema version %d)\n", *dir, info.Name, info.Size, info.Manifest.SchemaVersion)
+	return 0
+}
+
+// runRestoreCommand implements `server restore`, which replaces DB_PATH
+// with a backup archive. The server must be stopped; the replaced database
+// is kept next to DB_PATH.
+func runRestoreCommand(configPath string, args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.StringVar(&configPath, "config", configPath, "YAML or TOML config file (default $CONFIG_FILE)")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, restoreUsage)
+		return 2
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+
+	result, err := backup.Restore(context.Background(), flags.Arg(0), cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Restore failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Restored %s from %s (backup of %s, schema version %d, now %d)\n",
+		cfg.DBPath, flags.Arg(0), result.Manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"),
+		result.Manifest.SchemaVersion, result.SchemaVersion)
+	if result.Previous != "" {
+		fmt.Printf("The replaced database was moved to %s\n", result.Previous)
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"openrtb-insights/internal/config"
+)
+
+const configUsage = "usage: server config print [--redacted] [--config file]"
+
+// runConfigCommand implements `server config print`, which shows the
+// effective configuration after defaults, config file and environment are
+// merged, followed by any validation problems.
+func runConfigCommand(configPath string, args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	redact := flags.Bool("redacted", false, "mask secrets so the output is safe to share")
+	flags.StringVar(&configPath, "config", configPath, "YAML or TOML config file (default $CONFIG_FILE)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, loadErr := config.Load(configPath)
+	if err := cfg.Write(os.Stdout, *redact); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+		return 1
+	}
+	if loadErr != nil {
+		fmt.Fprintf(os.Stderr, "\nInvalid configuration:\n%v\n", loadErr)
+		return 1
+	}
+	return 0
+}
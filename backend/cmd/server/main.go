@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,82 +13,300 @@ import (
 	"time"
 
 	"openrtb-insights/internal/auth"
+	"openrtb-insights/internal/backup"
+	"openrtb-insights/internal/catalog"
 	"openrtb-insights/internal/config"
 	"openrtb-insights/internal/database"
+	"openrtb-insights/internal/health"
+	"openrtb-insights/internal/ingest"
+	"openrtb-insights/internal/logging"
+	"openrtb-insights/internal/metrics"
+	"openrtb-insights/internal/middleware"
+	"openrtb-insights/internal/ratelimit"
 	"openrtb-insights/internal/reports"
+	"openrtb-insights/internal/retention"
+	"openrtb-insights/internal/rollup"
+	"openrtb-insights/internal/tracing"
+	"openrtb-insights/internal/version"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	configPath := flag.String("config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "config":
+		os.Exit(runConfigCommand(*configPath, flag.Args()[1:]))
+	case "backup":
+		os.Exit(runBackupCommand(*configPath, flag.Args()[1:]))
+	case "restore":
+		os.Exit(runRestoreCommand(*configPath, flag.Args()[1:]))
+	}
+
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		fatal("Invalid logging configuration", err)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Enabled:     cfg.TracingEnabled,
+		Endpoint:    cfg.TracingEndpoint,
+		ServiceName: cfg.TracingServiceName,
+		Environment: cfg.Environment,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
 
 	// Set Gin mode based on environment
-	if cfg.LogLevel == "production" {
+	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	// Connect to database
-	db, err := database.Connect(cfg.DBPath)
+	pools, err := database.Open(cfg.DBPath, cfg.DatabaseOptions())
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", err)
 	}
-	defer database.Close(db)
+	defer pools.Close()
+	db := pools.Primary
+	readOnly := cfg.DBAccessMode == database.AccessReadOnly
 
-	// Run database migrations
-	if err := database.RunMigrations(db); err != nil {
-		log.Fatalf("Failed to run database migrations: %v", err)
+	// Run database migrations; a read-only instance can only check that
+	// another one has applied them
+	if readOnly {
+		schemaVersion, err := database.SchemaVersion(context.Background(), db)
+		if err != nil {
+			fatal("Failed to read the schema version", err)
+		}
+		if schemaVersion != database.LatestSchemaVersion() {
+			fatal("Database schema is out of date",
+				fmt.Errorf("schema version %d, this build needs %d; start a read-write instance to migrate", schemaVersion, database.LatestSchemaVersion()))
+		}
+	} else if err := database.RunMigrations(db); err != nil {
+		fatal("Failed to run database migrations", err)
+	}
+
+	// Build signing key rings; access and refresh tokens never share a key
+	accessKeys, err := newAccessKeyRing(cfg)
+	if err != nil {
+		fatal("Invalid access token keys", err)
+	}
+	refreshKeys, err := auth.NewHMACKeyRing(cfg.JWTRefreshKeyID, cfg.JWTRefreshSecret, cfg.JWTRefreshPreviousKeys)
+	if err != nil {
+		fatal("Invalid refresh token keys", err)
 	}
 
 	// Initialize services
-	authHandler := auth.NewHandler(db, cfg.JWTSecret, cfg.JWTExpiry, cfg.RefreshTokenExpiry)
-	authMiddleware := auth.NewAuthMiddleware(db, cfg.JWTSecret, cfg.RateLimit)
-	reportsService := reports.NewService(db)
+	tokenService := auth.NewTokenService(accessKeys, refreshKeys, cfg.JWTIssuer, cfg.JWTAudience, cfg.JWTExpiry, cfg.RefreshTokenExpiry)
+	sessionStore := auth.NewSessionStore(db)
+	apiKeyStore := auth.NewAPIKeyStore(db)
+	proxies, err := auth.ParseProxies(cfg.TrustedProxies)
+	if err != nil {
+		fatal("Invalid trusted proxies", err)
+	}
+	loginGuard := auth.NewLoginGuard(db, auth.LoginGuardConfig{
+		MaxFailures:     cfg.LoginMaxFailures,
+		IPMaxFailures:   cfg.LoginIPMaxFailures,
+		FailureWindow:   cfg.LoginFailureWindow,
+		LockoutDuration: cfg.LoginLockoutDuration,
+		BaseDelay:       cfg.LoginDelayBase,
+		MaxDelay:        cfg.LoginDelayMax,
+		Proxies:         proxies,
+	})
+	cookieSameSite, err := auth.ParseSameSite(cfg.CookieSameSite)
+	if err != nil {
+		fatal("Invalid cookie configuration", err)
+	}
+	cookieConfig := auth.CookieConfig{
+		Secure:   cfg.CookieSecure,
+		SameSite: cookieSameSite,
+		Domain:   cfg.CookieDomain,
+	}
+
+	authHandler := auth.NewHandler(db, sessionStore, tokenService, apiKeyStore, loginGuard, cookieConfig, cfg.LocalLoginEnabled)
+	authMiddleware := auth.NewAuthMiddleware(db, sessionStore, tokenService, apiKeyStore)
+	queryTimeouts, err := reports.ParseTimeouts(cfg.ReportQueryTimeout, cfg.ReportQueryTimeouts)
+	if err != nil {
+		fatal("Invalid REPORT_QUERY_TIMEOUTS", err)
+	}
+	retentionPolicies, err := retention.ParsePolicies(cfg.Retention)
+	if err != nil {
+		fatal("Invalid RETENTION", err)
+	}
+	reportsCache := reports.NewCache(cfg.ReportCacheTTL, cfg.ReportCacheMaxEntries)
+	reportsService := reports.NewService(reports.NewDuckDBStore(pools.Reports), queryTimeouts, reportsCache,
+		retention.HourlyRetention(retentionPolicies))
 	reportsHandler := reports.NewHandler(reportsService)
+	// Agent flushes feed the presence reports, so storing one makes cached
+	// reports stale
+	ingestHandler := ingest.NewHandler(ingest.NewIngester(pools.Writes, reportsService.InvalidateCache))
+	catalogHandler := catalog.NewHandler(catalog.NewStore(pools.Reports))
+	healthChecker := health.NewChecker(db, health.Config{
+		DBPath:      cfg.DBPath,
+		Timeout:     cfg.HealthCheckTimeout,
+		MaxDataAge:  cfg.HealthMaxDataAge,
+		MinFreeDisk: uint64(cfg.HealthMinFreeDiskMB) << 20,
+	})
+
+	// Background jobs stop when the server shuts down
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Keep weekly and monthly rollups current; rewritten buckets make
+	// cached reports stale
+	rollupRefresher := rollup.NewRefresher(pools.Writes, reportsService.InvalidateCache)
+	if !readOnly {
+		go rollupRefresher.Run(backgroundCtx, cfg.RollupRefreshInterval)
+	}
+
+	// Delete rows past their retention, rolling daily rows up first
+	retentionJob := retention.NewJob(pools.Writes, retentionPolicies, rollupRefresher, reportsService.InvalidateCache)
+	retentionHandler := retention.NewHandler(retentionJob)
+	if cfg.RetentionInterval > 0 && !readOnly {
+		go retentionJob.Loop(backgroundCtx, cfg.RetentionInterval)
+	}
+
+	// Online backups; scheduled when BACKUP_INTERVAL is set
+	backupManager := backup.NewManager(db, backup.Config{
+		Dir:    cfg.BackupDir,
+		Keep:   cfg.BackupKeep,
+		MaxAge: cfg.BackupMaxAge,
+	})
+	backupHandler := backup.NewHandler(backupManager)
+	if cfg.BackupInterval > 0 {
+		go backupManager.Loop(backgroundCtx, cfg.BackupInterval)
+	}
+
+	rateLimitStore := ratelimit.NewMemoryStore(cfg.RateLimitIdleTTL)
+	defer rateLimitStore.Close()
 
 	// Setup router
 	router := gin.New()
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		fatal("Invalid trusted proxies", err)
+	}
+	router.Use(logging.RequestID(logger))
+	router.Use(tracing.Middleware(cfg.TracingServiceName)...)
+	router.Use(logging.AccessLog())
+	router.Use(metrics.Middleware())
+	router.Use(logging.Recovery())
+
+	router.Use(middleware.SecurityHeaders(middleware.SecurityHeadersConfig{
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+		HSTSMaxAge:            cfg.HSTSMaxAge,
+	}))
 
 	// CORS middleware
-	router.Use(func(c *gin.Context) {
-		origin := c.GetHeader("Origin")
-		c.Header("Access-Control-Allow-Origin", origin)
-		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
+	corsMiddleware, err := middleware.CORS(middleware.CORSConfig{
+		AllowedOrigins:   cfg.CORSOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "Accept", "Origin", "Cache-Control", "X-Requested-With", logging.RequestIDHeader, "traceparent", "tracestate"},
+		ExposedHeaders:   []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", logging.RequestIDHeader},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
 	})
+	if err != nil {
+		fatal("Invalid CORS configuration", err)
+	}
+	router.Use(corsMiddleware)
 
-	// Apply rate limiting
-	router.Use(authMiddleware.RateLimit())
+	// Cookie-authenticated writes must echo the CSRF cookie in a header
+	router.Use(middleware.CSRF(middleware.CSRFConfig{
+		CookieName:     auth.CSRFCookie,
+		HeaderName:     auth.CSRFHeader,
+		SessionCookies: []string{auth.AccessTokenCookie, auth.RefreshTokenCookie},
+		ExemptPaths:    []string{"/api/auth/login"},
+	}))
 
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
+	// The general budget covers routes without a budget of their own. Route
+	// groups with one are counted only against theirs, so a shared IP
+	// bucket never throttles the per-client report and ingest budgets.
+	generalLimit := ratelimit.Limit{Requests: cfg.RateLimit, Period: time.Minute}
+	public := router.Group("/")
+	public.Use(ratelimit.Middleware(rateLimitStore, "global", generalLimit, ratelimit.ByIP))
+
+	// Liveness and readiness probes
+	public.GET("/livez", healthChecker.Livez)
+	public.GET("/readyz", healthChecker.Readyz)
+
+	// Kept for existing monitors; prefer /livez and /readyz
+	public.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "healthy",
-			"version": "1.0.0",
+			"version": version.Version,
 			"time":    time.Now().UTC().Format(time.RFC3339),
 		})
 	})
 
+	// Prometheus metrics, protected by METRICS_TOKEN when set
+	public.GET("/metrics", metrics.Handler(cfg.MetricsToken))
+
+	// Public access token keys for services verifying our tokens
+	public.GET("/.well-known/jwks.json", authHandler.JWKS)
+
 	// API routes
 	api := router.Group("/api")
 	{
 		// Authentication routes
 		authRoutes := api.Group("/auth")
+		authRoutes.Use(ratelimit.Middleware(rateLimitStore, "auth",
+			ratelimit.Limit{Requests: cfg.RateLimitAuth, Period: time.Minute}, ratelimit.ByIP))
 		{
 			authRoutes.POST("/login", authHandler.Login)
 			authRoutes.POST("/refresh", authHandler.Refresh)
 			authRoutes.POST("/logout", authHandler.Logout)
 			authRoutes.GET("/me", authMiddleware.RequireAuth(), authHandler.Me)
+			authRoutes.PUT("/me/timezone", authMiddleware.RequireAuth(), authHandler.UpdateTimezone)
+			authRoutes.GET("/sessions", authMiddleware.RequireAuth(), authHandler.ListSessions)
+			authRoutes.DELETE("/sessions", authMiddleware.RequireAuth(), authHandler.RevokeOtherSessions)
+			authRoutes.DELETE("/sessions/:id", authMiddleware.RequireAuth(), authHandler.RevokeSession)
+
+			// API key management
+			apiKeyRoutes := authRoutes.Group("/api-keys")
+			apiKeyRoutes.Use(authMiddleware.RequireAuth(), authMiddleware.RequireRole("Admin"))
+			{
+				apiKeyRoutes.POST("", authHandler.CreateAPIKey)
+				apiKeyRoutes.GET("", authHandler.ListAPIKeys)
+				apiKeyRoutes.DELETE("/:id", authHandler.RevokeAPIKey)
+			}
+
+			// Login lockout administration
+			lockoutRoutes := authRoutes.Group("/lockouts")
+			lockoutRoutes.Use(authMiddleware.RequireAuth(), authMiddleware.RequireRole("Admin"))
+			{
+				lockoutRoutes.GET("", authHandler.ListLockouts)
+				lockoutRoutes.DELETE("/:username", authHandler.UnlockAccount)
+			}
+
+			// Single sign-on, enabled when an issuer is configured
+			if cfg.OIDCIssuerURL != "" {
+				oidcHandler := auth.NewOIDCHandler(authHandler, auth.OIDCConfig{
+					IssuerURL:         cfg.OIDCIssuerURL,
+					ClientID:          cfg.OIDCClientID,
+					ClientSecret:      cfg.OIDCClientSecret,
+					RedirectURL:       cfg.OIDCRedirectURL,
+					Scopes:            cfg.OIDCScopes,
+					GroupsClaim:       cfg.OIDCGroupsClaim,
+					AdminGroups:       cfg.OIDCAdminGroups,
+					AnalystGroups:     cfg.OIDCAnalystGroups,
+					ViewerGroups:      cfg.OIDCViewerGroups,
+					DefaultRole:       cfg.OIDCDefaultRole,
+					PostLoginRedirect: cfg.OIDCPostLoginRedirect,
+				})
+				authRoutes.GET("/oidc/login", oidcHandler.Login)
+				authRoutes.GET("/oidc/callback", oidcHandler.Callback)
+			}
 		}
 
 		// Protected routes
@@ -94,19 +315,66 @@ func main() {
 		{
 			// Reports routes
 			reportsRoutes := protected.Group("/reports")
+			reportsRoutes.Use(
+				ratelimit.Middleware(rateLimitStore, "reports",
+					ratelimit.Limit{Requests: cfg.RateLimitReports, Period: time.Minute}, ratelimit.ByClient),
+				authMiddleware.RequireScope(auth.ScopeReportsRead),
+			)
 			{
 				reportsRoutes.GET("/dashboard", reportsHandler.GetDashboard)
 				reportsRoutes.GET("/platform", reportsHandler.GetPlatformStats)
 				reportsRoutes.GET("/content", reportsHandler.GetContentHealth)
 				reportsRoutes.GET("/video", reportsHandler.GetVideoHealth)
+				reportsRoutes.GET("/presence", reportsHandler.GetParameterPresence)
+				reportsRoutes.DELETE("/cache", authMiddleware.RequireRole("Admin"), reportsHandler.InvalidateCache)
+			}
+
+			// Parameter catalog, read like the reports and sharing their
+			// budget
+			parameterRoutes := protected.Group("/parameters")
+			parameterRoutes.Use(
+				ratelimit.Middleware(rateLimitStore, "reports",
+					ratelimit.Limit{Requests: cfg.RateLimitReports, Period: time.Minute}, ratelimit.ByClient),
+				authMiddleware.RequireScope(auth.ScopeReportsRead),
+			)
+			{
+				parameterRoutes.GET("", catalogHandler.ListParameters)
+			}
+
+			// Edge agent ingestion
+			ingestRoutes := protected.Group("/ingest")
+			ingestRoutes.Use(
+				ratelimit.Middleware(rateLimitStore, "ingest",
+					ratelimit.Limit{Requests: cfg.RateLimitIngest, Period: time.Minute}, ratelimit.ByClient),
+				authMiddleware.RequireScope(auth.ScopeIngestWrite),
+			)
+			{
+				ingestRoutes.POST("/agent", ingestHandler.IngestAgentFlush)
+			}
+
+			// Data administration
+			adminRoutes := protected.Group("/admin")
+			adminRoutes.Use(
+				ratelimit.Middleware(rateLimitStore, "global", generalLimit, ratelimit.ByClient),
+				authMiddleware.RequireRole("Admin"),
+			)
+			{
+				adminRoutes.GET("/retention", retentionHandler.ListRuns)
+				adminRoutes.POST("/retention/run", retentionHandler.Run)
+				adminRoutes.GET("/backups", backupHandler.ListBackups)
+				adminRoutes.POST("/backups", backupHandler.CreateBackup)
 			}
 		}
 	}
 
-	// Start server
+	// Start server. Request contexts derive from requestsCtx so requests
+	// still running when shutdown gives up can be cancelled.
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
 		Handler:      router,
+		BaseContext:  func(net.Listener) context.Context { return requestsCtx },
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -115,26 +383,57 @@ func main() {
 	// Graceful shutdown
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			fatal("Failed to start server", err)
 		}
 	}()
 
-	log.Printf("Server started on port %s", cfg.Port)
+	slog.Info("Server started", "port", cfg.Port, "environment", cfg.Environment,
+		"version", version.Version, "commit", version.Commit)
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
+	stopBackground()
 
 	// Give the server 30 seconds to finish handling existing connections
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		// Interrupt requests still running, such as long DuckDB scans,
+		// before the database is closed
+		slog.Error("Server forced to shutdown, cancelling in-flight requests", "error", err)
+		cancelRequests()
+	}
+
+	// Flush spans still buffered for the collector
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+
+	slog.Info("Server gracefully stopped")
+}
+
+// newAccessKeyRing builds the access token key ring for the configured
+// signing algorithm.
+func newAccessKeyRing(cfg *config.Config) (*auth.KeyRing, error) {
+	switch cfg.JWTAlgorithm {
+	case "HS256":
+		return auth.NewHMACKeyRing(cfg.JWTKeyID, cfg.JWTSecret, cfg.JWTPreviousKeys)
+	case "RS256", "EdDSA":
+		return auth.NewPEMKeyRing(cfg.JWTAlgorithm, cfg.JWTKeyID, cfg.JWTPrivateKeyFile, cfg.JWTPreviousPublicKeys, cfg.JWTPreviousKeys)
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALGORITHM %q (use HS256, RS256 or EdDSA)", cfg.JWTAlgorithm)
 	}
+}
 
-	log.Println("Server gracefully stopped")
-}
\ No newline at end of file
+// fatal logs an error that prevents the server from starting and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeReportsRead = "reports:read"
+	ScopeIngestWrite = "ingest:write"
+
+	apiKeyPrefix = "ori"
+
+	// last_used_at is only written when it is older than this, so a busy
+	// client does not turn every request into a write
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrUnknownScope  = errors.New("unknown scope")
+)
+
+// ValidScopes lists every scope an API key can be granted.
+var ValidScopes = []string{ScopeReportsRead, ScopeIngestWrite}
+
+// roleScopes maps interactive user roles onto the same scopes API keys use,
+// so routes can be guarded by scope regardless of how the caller signed in.
+var roleScopes = map[string][]string{
+	"Viewer":  {ScopeReportsRead},
+	"Analyst": {ScopeReportsRead},
+	"Admin":   {ScopeReportsRead, ScopeIngestWrite},
+}
+
+type APIKeyStore struct {
+	db *sql.DB
+}
+
+func NewAPIKeyStore(db *sql.DB) *APIKeyStore {
+	return &APIKeyStore{db: db}
+}
+
+// Create generates a new key and returns its plaintext alongside the stored
+// record. Keys look like "ori_<id>_<secret>"; the "ori_<id>" part is the
+// prefix stored in clear for identification.
+func (s *APIKeyStore) Create(name string, scopes []string, createdBy int, expiresAt *time.Time) (string, *APIKey, error) {
+	for _, scope := range scopes {
+		if !isValidScope(scope) {
+			return "", nil, fmt.Errorf("%w: %s", ErrUnknownScope, scope)
+		}
+	}
+
+	id, err := newRandomID(4)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := newRandomID(24)
+	if err != nil {
+		return "", nil, err
+	}
+
+	prefix := apiKeyPrefix + "_" + id
+	plaintext := prefix + "_" + secret
+	key := &APIKey{
+		ID:        id,
+		Name:      name,
+		Prefix:    prefix,
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	var expires interface{}
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO api_keys (id, name, key_prefix, key_hash, scopes, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, key.ID, key.Name, key.Prefix, hashToken(plaintext), strings.Join(scopes, ","),
+		key.CreatedBy, key.CreatedAt, expires)
+	if err != nil {
+		return "", nil, err
+	}
+	return plaintext, key, nil
+}
+
+// Authenticate resolves a plaintext key to its record, rejecting unknown,
+// revoked and expired keys.
+func (s *APIKeyStore) Authenticate(plaintext string) (*APIKey, error) {
+	parts := strings.Split(plaintext, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var key APIKey
+	var keyHash, scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT id, name, key_prefix, key_hash, scopes, created_by, created_at,
+		       expires_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE id = ?
+	`, parts[1]).Scan(&key.ID, &key.Name, &key.Prefix, &keyHash, &scopes, &key.CreatedBy,
+		&key.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(keyHash), []byte(hashToken(plaintext))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if revokedAt.Valid || (expiresAt.Valid && !expiresAt.Time.After(time.Now())) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key.Scopes = splitScopes(scopes)
+	key.ExpiresAt = nullTimePtr(expiresAt)
+	key.LastUsedAt = nullTimePtr(lastUsedAt)
+
+	if !lastUsedAt.Valid || time.Since(lastUsedAt.Time) > apiKeyTouchInterval {
+		now := time.Now().UTC()
+		if _, err := s.db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, key.ID); err == nil {
+			key.LastUsedAt = &now
+		}
+	}
+	return &key, nil
+}
+
+// List returns every key, including revoked ones, newest first.
+func (s *APIKeyStore) List() ([]APIKey, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, key_prefix, scopes, created_by, created_at,
+		       expires_at, last_used_at, revoked_at
+		FROM api_keys
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var scopes string
+		var expiresAt, lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &key.CreatedBy,
+			&key.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		key.Scopes = splitScopes(scopes)
+		key.ExpiresAt = nullTimePtr(expiresAt)
+		key.LastUsedAt = nullTimePtr(lastUsedAt)
+		key.RevokedAt = nullTimePtr(revokedAt)
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *APIKeyStore) Revoke(id string) error {
+	result, err := s.db.Exec(
+		"UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidAPIKey
+	}
+	return nil
+}
+
+func isValidScope(scope string) bool {
+	for _, valid := range ValidScopes {
+		if scope == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
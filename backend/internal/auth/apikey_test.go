@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func createAPIKey(t *testing.T, store *APIKeyStore, scopes []string, expiresAt *time.Time) (string, *APIKey) {
+	t.Helper()
+	plaintext, key, err := store.Create("test", scopes, 1, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plaintext, key
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	store := NewAPIKeyStore(newTestDB(t))
+
+	plaintext, key := createAPIKey(t, store, []string{ScopeReportsRead}, nil)
+	if !strings.HasPrefix(plaintext, key.Prefix+"_") || key.Prefix != "ori_"+key.ID {
+		t.Fatalf("key %s does not start with its prefix %s", plaintext, key.Prefix)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, _ := createAPIKey(t, store, []string{ScopeReportsRead}, &past)
+	future := time.Now().Add(time.Hour)
+	expiring, _ := createAPIKey(t, store, []string{ScopeReportsRead}, &future)
+	revoked, revokedKey := createAPIKey(t, store, []string{ScopeReportsRead}, nil)
+	if err := store.Revoke(revokedKey.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := plaintext[strings.LastIndex(plaintext, "_")+1:]
+	tests := []struct {
+		name  string
+		key   string
+		valid bool
+	}{
+		{"valid", plaintext, true},
+		{"not yet expired", expiring, true},
+		{"wrong secret", key.Prefix + "_" + strings.Repeat("0", len(secret)), false},
+		{"unknown id", "ori_00000000_" + secret, false},
+		{"wrong prefix", "xyz" + strings.TrimPrefix(plaintext, "ori"), false},
+		{"missing secret", key.Prefix, false},
+		{"extra part", plaintext + "_extra", false},
+		{"expired", expired, false},
+		{"revoked", revoked, false},
+		{"empty", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticated, err := store.Authenticate(test.key)
+			if !test.valid {
+				if !errors.Is(err, ErrInvalidAPIKey) {
+					t.Errorf("got %v, want ErrInvalidAPIKey", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if len(authenticated.Scopes) != 1 || authenticated.Scopes[0] != ScopeReportsRead {
+				t.Errorf("got scopes %v", authenticated.Scopes)
+			}
+			if authenticated.LastUsedAt == nil {
+				t.Errorf("last_used_at not recorded")
+			}
+		})
+	}
+}
+
+func TestAPIKeyCreateAndRevoke(t *testing.T) {
+	store := NewAPIKeyStore(newTestDB(t))
+
+	if _, _, err := store.Create("test", []string{"reports:write"}, 1, nil); !errors.Is(err, ErrUnknownScope) {
+		t.Errorf("got %v for an unknown scope, want ErrUnknownScope", err)
+	}
+
+	_, key := createAPIKey(t, store, []string{ScopeReportsRead, ScopeIngestWrite}, nil)
+	if err := store.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(key.ID); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("got %v revoking twice, want ErrInvalidAPIKey", err)
+	}
+
+	keys, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].RevokedAt == nil || len(keys[0].Scopes) != 2 {
+		t.Errorf("got %+v, want the revoked key with both scopes", keys)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	db := newTestDB(t)
+	store := NewAPIKeyStore(db)
+	middleware := NewAuthMiddleware(db, NewSessionStore(db), nil, store)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/ingest", middleware.RequireAuth(), middleware.RequireScope(ScopeIngestWrite), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	reportsKey, _ := createAPIKey(t, store, []string{ScopeReportsRead}, nil)
+	ingestKey, _ := createAPIKey(t, store, []string{ScopeIngestWrite}, nil)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"scope granted", "X-API-Key", ingestKey, http.StatusNoContent},
+		{"ApiKey scheme", "Authorization", "ApiKey " + ingestKey, http.StatusNoContent},
+		{"scope missing", "X-API-Key", reportsKey, http.StatusForbidden},
+		{"invalid key", "X-API-Key", ingestKey + "0", http.StatusUnauthorized},
+		{"no key", "", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+			if test.header != "" {
+				request.Header.Set(test.header, test.value)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("got %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+
+	// CSRFCookie is readable by the frontend, which echoes it back in the
+	// CSRFHeader on every state-changing request.
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CookieConfig controls the attributes of the auth cookies. Secure should be
+// on whenever the API is served over HTTPS.
+type CookieConfig struct {
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
+}
+
+// ParseSameSite accepts lax, strict or none.
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid SameSite mode %q, expected lax, strict or none", value)
+	}
+}
+
+func (cfg CookieConfig) set(c *gin.Context, name, value string, maxAge int, path string, httpOnly bool) {
+	c.SetSameSite(cfg.SameSite)
+	c.SetCookie(name, value, maxAge, path, cfg.Domain, cfg.Secure, httpOnly)
+}
+
+func (h *Handler) setAuthCookies(c *gin.Context, accessToken, refreshToken, csrfToken string) {
+	refreshMaxAge := int(h.tokens.RefreshExpiry().Seconds())
+	h.cookies.set(c, AccessTokenCookie, accessToken, int(h.tokens.AccessExpiry().Seconds()), "/", true)
+	h.cookies.set(c, RefreshTokenCookie, refreshToken, refreshMaxAge, "/", true)
+	h.cookies.set(c, CSRFCookie, csrfToken, refreshMaxAge, "/", false)
+}
+
+func (h *Handler) clearAuthCookies(c *gin.Context) {
+	h.cookies.set(c, AccessTokenCookie, "", -1, "/", true)
+	h.cookies.set(c, RefreshTokenCookie, "", -1, "/", true)
+	h.cookies.set(c, CSRFCookie, "", -1, "/", false)
+}
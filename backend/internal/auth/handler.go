@@ -2,31 +2,48 @@ package auth
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
+	"openrtb-insights/internal/logging"
+	"openrtb-insights/internal/metrics"
+
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Handler struct {
-	db                 *sql.DB
-	jwtSecret          string
-	jwtExpiry          time.Duration
-	refreshTokenExpiry time.Duration
+	db                *sql.DB
+	sessions          *SessionStore
+	tokens            *TokenService
+	apiKeys           *APIKeyStore
+	loginGuard        *LoginGuard
+	cookies           CookieConfig
+	localLoginEnabled bool
 }
 
-func NewHandler(db *sql.DB, jwtSecret string, jwtExpiry, refreshTokenExpiry time.Duration) *Handler {
+func NewHandler(db *sql.DB, sessions *SessionStore, tokens *TokenService, apiKeys *APIKeyStore, loginGuard *LoginGuard, cookies CookieConfig, localLoginEnabled bool) *Handler {
 	return &Handler{
-		db:                 db,
-		jwtSecret:          jwtSecret,
-		jwtExpiry:          jwtExpiry,
-		refreshTokenExpiry: refreshTokenExpiry,
+		db:                db,
+		sessions:          sessions,
+		tokens:            tokens,
+		apiKeys:           apiKeys,
+		loginGuard:        loginGuard,
+		cookies:           cookies,
+		localLoginEnabled: localLoginEnabled,
 	}
 }
 
 func (h *Handler) Login(c *gin.Context) {
+	if !h.localLoginEnabled {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Password login is disabled, use single sign-on",
+		})
+		return
+	}
+
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -35,56 +52,70 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	// Get user from database
-	user, err := h.getUserByUsername(req.Username)
+	ip := c.ClientIP()
+
+	// Refuse attempts against locked accounts and throttled IPs
+	retryAfter, delay, err := h.loginGuard.Check(req.Username, ip)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid username or password",
+		logging.FromContext(c.Request.Context()).Error("Failed to check login lockout", "username", req.Username, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to process login",
 		})
 		return
 	}
-
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid username or password",
+	if retryAfter > 0 {
+		if err := h.loginGuard.RecordBlocked(req.Username, ip); err != nil {
+			logging.FromContext(c.Request.Context()).Error("Failed to record blocked login", "username", req.Username, "error", err)
+		}
+		metrics.LoginAttempt("password", "blocked")
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many failed login attempts, try again later",
 		})
 		return
 	}
+	h.loginGuard.Wait(c.Request.Context(), delay)
 
-	// Generate tokens
-	accessToken, err := h.generateAccessToken(*user)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to generate access token",
-		})
-		return
+	// Get user from database and verify password
+	_, span := tracer.Start(c.Request.Context(), "auth.user_lookup")
+	user, err := h.getUserByUsername(req.Username)
+	span.End()
+	reason := "unknown_user"
+	if err == nil {
+		reason = "bad_password"
+		_, span := tracer.Start(c.Request.Context(), "auth.verify_password")
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
+		span.End()
 	}
-
-	refreshToken, err := h.generateRefreshToken(user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to generate refresh token",
+		delay, recordErr := h.loginGuard.RecordFailure(req.Username, ip, reason)
+		if recordErr != nil {
+			logging.FromContext(c.Request.Context()).Error("Failed to record failed login", "username", req.Username, "error", recordErr)
+		}
+		logging.FromContext(c.Request.Context()).Warn("Failed login", "username", req.Username, "client_ip", ip, "reason", reason)
+		metrics.LoginAttempt("password", "failure")
+		h.loginGuard.Wait(c.Request.Context(), delay)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid username or password",
 		})
 		return
 	}
 
-	// Set cookies
-	c.SetCookie("access_token", accessToken, int(h.jwtExpiry.Seconds()), "/", "", false, true)
-	c.SetCookie("refresh_token", refreshToken, int(h.refreshTokenExpiry.Seconds()), "/", "", false, true)
+	if err := h.loginGuard.RecordSuccess(req.Username, ip); err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to record login", "username", req.Username, "error", err)
+	}
+	metrics.LoginAttempt("password", "success")
 
-	// Remove password from response
-	user.Password = ""
+	response, ok := h.startSession(c, user)
+	if !ok {
+		return
+	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		User:         *user,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-	})
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *Handler) Refresh(c *gin.Context) {
-	refreshToken, err := c.Cookie("refresh_token")
+	refreshToken, err := c.Cookie(RefreshTokenCookie)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Refresh token required",
@@ -92,36 +123,48 @@ func (h *Handler) Refresh(c *gin.Context) {
 		return
 	}
 
-	token, err := jwt.ParseWithClaims(refreshToken, &RefreshTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(h.jwtSecret), nil
-	})
-
-	if err != nil || !token.Valid {
+	claims, err := h.tokens.ParseRefreshToken(refreshToken)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Invalid or expired refresh token",
 		})
 		return
 	}
 
-	claims, ok := token.Claims.(*RefreshTokenClaims)
-	if !ok {
+	// Get user from database
+	user, err := h.getUserByID(claims.UserID)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid refresh token claims",
+			"error": "User not found",
 		})
 		return
 	}
 
-	// Get user from database
-	user, err := h.getUserByID(claims.UserID)
+	// Rotate the refresh token; the presented one becomes unusable
+	newRefreshToken, err := h.tokens.IssueRefreshToken(user.ID, claims.SessionID)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate refresh token",
+		})
+		return
+	}
+
+	err = h.sessions.Rotate(claims.SessionID, refreshToken, newRefreshToken, time.Now().Add(h.tokens.RefreshExpiry()))
+	if err != nil {
+		if errors.Is(err, ErrTokenReused) {
+			logging.FromContext(c.Request.Context()).Warn("Refresh token reuse detected, session revoked", "user_id", user.ID, "session_id", claims.SessionID)
+		} else if !errors.Is(err, ErrSessionNotFound) && !errors.Is(err, ErrSessionRevoked) {
+			logging.FromContext(c.Request.Context()).Error("Failed to rotate session", "session_id", claims.SessionID, "error", err)
+		}
+		h.clearAuthCookies(c)
 		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "User not found",
+			"error": "Invalid or expired refresh token",
 		})
 		return
 	}
 
 	// Generate new access token
-	accessToken, err := h.generateAccessToken(*user)
+	accessToken, err := h.tokens.IssueAccessToken(*user, claims.SessionID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to generate access token",
@@ -129,18 +172,38 @@ func (h *Handler) Refresh(c *gin.Context) {
 		return
 	}
 
-	// Set new cookie
-	c.SetCookie("access_token", accessToken, int(h.jwtExpiry.Seconds()), "/", "", false, true)
+	// Keep the CSRF token stable across refreshes so requests already in
+	// flight with the old value are not rejected
+	csrfToken, err := c.Cookie(CSRFCookie)
+	if err != nil || csrfToken == "" {
+		if csrfToken, err = newRandomID(32); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to generate CSRF token",
+			})
+			return
+		}
+	}
+
+	// Set new cookies
+	h.setAuthCookies(c, accessToken, newRefreshToken, csrfToken)
 
 	c.JSON(http.StatusOK, gin.H{
 		"access_token": accessToken,
+		"csrf_token":   csrfToken,
 	})
 }
 
 func (h *Handler) Logout(c *gin.Context) {
-	// Clear cookies
-	c.SetCookie("access_token", "", -1, "/", "", false, true)
-	c.SetCookie("refresh_token", "", -1, "/", "", false, true)
+	// Revoke the session behind the refresh token, if there is one
+	if refreshToken, err := c.Cookie(RefreshTokenCookie); err == nil && refreshToken != "" {
+		if claims, err := h.tokens.ParseRefreshToken(refreshToken); err == nil {
+			if err := h.sessions.Revoke(claims.SessionID, "logout"); err != nil {
+				logging.FromContext(c.Request.Context()).Error("Failed to revoke session", "session_id", claims.SessionID, "error", err)
+			}
+		}
+	}
+
+	h.clearAuthCookies(c)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Logged out successfully",
@@ -170,34 +233,316 @@ func (h *Handler) Me(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-func (h *Handler) getUserByUsername(username string) (*User, error) {
-	var user User
-	query := "SELECT id, username, password_hash, role FROM users WHERE username = ?"
-	err := h.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Password, &user.Role)
+// UpdateTimezone sets the caller's default report time zone. Access tokens
+// carry it, so it applies to tokens issued from the next refresh on.
+func (h *Handler) UpdateTimezone(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User information not found",
+		})
+		return
+	}
+
+	var req UpdateTimezoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format",
+		})
+		return
+	}
+
+	// Local would mean the server's zone, which differs between hosts
+	if _, err := time.LoadLocation(req.Timezone); err != nil || req.Timezone == "Local" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "timezone must be an IANA time zone name, e.g. America/New_York",
+		})
+		return
+	}
+
+	if _, err := h.db.Exec("UPDATE users SET timezone = ? WHERE id = ?", req.Timezone, userID); err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to update time zone", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update time zone",
+		})
+		return
+	}
+
+	user, err := h.getUserByID(userID.(int))
 	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+	user.Password = ""
+
+	c.JSON(http.StatusOK, user)
+}
+
+// userColumns are read by scanUser. Users created before time zones
+// existed default to UTC.
+const userColumns = "id, username, password_hash, role, COALESCE(timezone, 'UTC')"
+
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+	if err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.Timezone); err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+func (h *Handler) getUserByUsername(username string) (*User, error) {
+	return scanUser(h.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
 func (h *Handler) getUserByID(id int) (*User, error) {
-	var user User
-	query := "SELECT id, username, password_hash, role FROM users WHERE id = ?"
-	err := h.db.QueryRow(query, id).Scan(&user.ID, &user.Username, &user.Password, &user.Role)
+	return scanUser(h.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (h *Handler) ListSessions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	currentID := c.GetString("session_id")
+
+	sessions, err := h.sessions.ListActive(userID)
 	if err != nil {
-		return nil, err
+		logging.FromContext(c.Request.Context()).Error("Failed to list sessions", "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve sessions",
+		})
+		return
 	}
-	return &user, nil
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  sessions,
+		"count": len(sessions),
+	})
 }
 
-func (h *Handler) generateAccessToken(user User) (string, error) {
-	claims := NewClaims(user, h.jwtExpiry)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(h.jwtSecret))
+func (h *Handler) RevokeSession(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionID := c.Param("id")
+
+	if err := h.sessions.RevokeForUser(userID, sessionID, "user_revoked"); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Session not found",
+			})
+			return
+		}
+		logging.FromContext(c.Request.Context()).Error("Failed to revoke session", "session_id", sessionID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Session revoked",
+	})
+}
+
+func (h *Handler) RevokeOtherSessions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	currentID := c.GetString("session_id")
+
+	revoked, err := h.sessions.RevokeOthers(userID, currentID, "user_revoked")
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to revoke sessions", "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Other sessions revoked",
+		"revoked": revoked,
+	})
 }
 
-func (h *Handler) generateRefreshToken(userID int) (string, error) {
-	claims := NewRefreshTokenClaims(userID, h.refreshTokenExpiry)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(h.jwtSecret))
-}
\ No newline at end of file
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format",
+		})
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "At least one scope is required",
+		})
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "expires_at must be in the future",
+		})
+		return
+	}
+
+	plaintext, key, err := h.apiKeys.Create(req.Name, req.Scopes, c.GetInt("user_id"), req.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, ErrUnknownScope) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		logging.FromContext(c.Request.Context()).Error("Failed to create API key", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create API key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{
+		APIKey: *key,
+		Key:    plaintext,
+	})
+}
+
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeys.List()
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to list API keys", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve API keys",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  keys,
+		"count": len(keys),
+	})
+}
+
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	if err := h.apiKeys.Revoke(c.Param("id")); err != nil {
+		if errors.Is(err, ErrInvalidAPIKey) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "API key not found",
+			})
+			return
+		}
+		logging.FromContext(c.Request.Context()).Error("Failed to revoke API key", "api_key_id", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke API key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API key revoked",
+	})
+}
+
+func (h *Handler) ListLockouts(c *gin.Context) {
+	lockouts, err := h.loginGuard.ActiveLockouts()
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to list lockouts", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve lockouts",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  lockouts,
+		"count": len(lockouts),
+	})
+}
+
+func (h *Handler) UnlockAccount(c *gin.Context) {
+	username := c.Param("username")
+
+	if err := h.loginGuard.Unlock(username); err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to unlock account", "username", username, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to unlock account",
+		})
+		return
+	}
+
+	logging.FromContext(c.Request.Context()).Info("Account unlocked", "username", username, "unlocked_by", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Account unlocked",
+	})
+}
+
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.tokens.JWKS())
+}
+
+// startSession creates a server-side session for user, issues its token
+// pair and sets the auth cookies. On failure it writes the error response
+// and returns ok=false.
+func (h *Handler) startSession(c *gin.Context, user *User) (*LoginResponse, bool) {
+	sessionID, err := newRandomID(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create session",
+		})
+		return nil, false
+	}
+
+	refreshToken, err := h.tokens.IssueRefreshToken(user.ID, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate refresh token",
+		})
+		return nil, false
+	}
+
+	session := &Session{
+		ID:        sessionID,
+		UserID:    user.ID,
+		UserAgent: c.Request.UserAgent(),
+		IPAddress: c.ClientIP(),
+		ExpiresAt: time.Now().Add(h.tokens.RefreshExpiry()),
+	}
+	if err := h.sessions.Create(session, refreshToken); err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to create session", "user_id", user.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create session",
+		})
+		return nil, false
+	}
+
+	accessToken, err := h.tokens.IssueAccessToken(*user, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate access token",
+		})
+		return nil, false
+	}
+
+	csrfToken, err := newRandomID(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate CSRF token",
+		})
+		return nil, false
+	}
+
+	h.setAuthCookies(c, accessToken, refreshToken, csrfToken)
+
+	// Remove password from response
+	response := &LoginResponse{
+		User:         *user,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		CSRFToken:    csrfToken,
+	}
+	response.User.Password = ""
+	return response, true
+}
@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is a single JWT key identified by the kid header. Keys without
+// a signing half are kept only to verify tokens issued before a rotation.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+// KeyRing signs tokens with its active key and verifies tokens against any
+// key it holds, selected by kid. Rotating a secret means promoting a new key
+// to active and keeping the old one in the ring until its tokens expire.
+type KeyRing struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+func NewKeyRing(active *SigningKey, previous ...*SigningKey) (*KeyRing, error) {
+	if active == nil || active.SignKey == nil {
+		return nil, errors.New("active key must be able to sign")
+	}
+
+	ring := &KeyRing{
+		active: active,
+		keys:   make(map[string]*SigningKey),
+	}
+	for _, key := range append([]*SigningKey{active}, previous...) {
+		if key.ID == "" {
+			return nil, errors.New("key id must not be empty")
+		}
+		if _, exists := ring.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ring.keys[key.ID] = key
+	}
+	return ring, nil
+}
+
+func NewHMACKey(id, secret string) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		SignKey:   []byte(secret),
+		VerifyKey: []byte(secret),
+	}
+}
+
+// NewHMACKeyRing builds an HS256 key ring from the active secret and a
+// comma-separated list of previous "kid:secret" pairs.
+func NewHMACKeyRing(activeID, activeSecret, previous string) (*KeyRing, error) {
+	if activeSecret == "" {
+		return nil, errors.New("signing secret must not be empty")
+	}
+
+	previousKeys, err := ParseHMACKeys(previous)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyRing(NewHMACKey(activeID, activeSecret), previousKeys...)
+}
+
+// NewPEMKeyRing builds a key ring whose active key is an RS256 or EdDSA
+// private key read from a PEM file. Previous keys may be given both as
+// "kid:/path/to/public.pem" entries and as "kid:secret" HMAC entries, so
+// tokens issued before a switch from HS256 stay valid until they expire.
+func NewPEMKeyRing(algorithm, activeID, privateKeyFile, previousPublicKeys, previousSecrets string) (*KeyRing, error) {
+	if privateKeyFile == "" {
+		return nil, fmt.Errorf("a private key file is required for %s", algorithm)
+	}
+
+	privateKey, err := LoadPrivateKeyFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	active, err := NewPrivateKey(activeID, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if active.Method.Alg() != algorithm {
+		return nil, fmt.Errorf("private key is a %s key, but %s is configured", active.Method.Alg(), algorithm)
+	}
+
+	var previousKeys []*SigningKey
+	for _, entry := range splitKeyList(previousPublicKeys) {
+		id, path, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("invalid previous public key %q, expected kid:path", entry)
+		}
+		publicKey, err := LoadPublicKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := NewPublicKey(id, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		previousKeys = append(previousKeys, key)
+	}
+
+	hmacKeys, err := ParseHMACKeys(previousSecrets)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyRing(active, append(previousKeys, hmacKeys...)...)
+}
+
+// ParseHMACKeys parses a comma-separated list of "kid:secret" pairs.
+func ParseHMACKeys(spec string) ([]*SigningKey, error) {
+	var keys []*SigningKey
+	for _, entry := range splitKeyList(spec) {
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, errors.New("invalid previous key entry, expected kid:secret")
+		}
+		keys = append(keys, NewHMACKey(id, secret))
+	}
+	return keys, nil
+}
+
+// NewPrivateKey wraps an RSA or Ed25519 private key as a signing key.
+func NewPrivateKey(id string, privateKey crypto.Signer) (*SigningKey, error) {
+	key, err := NewPublicKey(id, privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.SignKey = privateKey
+	return key, nil
+}
+
+// NewPublicKey wraps an RSA or Ed25519 public key as a verify-only key.
+func NewPublicKey(id string, publicKey crypto.PublicKey) (*SigningKey, error) {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, VerifyKey: publicKey}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, VerifyKey: publicKey}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T for key %q", publicKey, id)
+	}
+}
+
+// LoadPrivateKeyFile reads a PKCS#8 or PKCS#1 PEM encoded private key.
+func LoadPrivateKeyFile(path string) (crypto.Signer, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key in %s", path)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("failed to parse private key in %s", path)
+}
+
+// LoadPublicKeyFile reads a PKIX PEM encoded public key.
+func LoadPublicKeyFile(path string) (crypto.PublicKey, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key in %s: %w", path, err)
+	}
+	return key, nil
+}
+
+func readPEMFile(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+func splitKeyList(spec string) []string {
+	var entries []string
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Sign signs claims with the active key and stamps its kid in the header.
+func (kr *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(kr.active.Method, claims)
+	token.Header["kid"] = kr.active.ID
+	return token.SignedString(kr.active.SignKey)
+}
+
+// Keyfunc resolves the verification key for a token by kid and rejects
+// tokens whose alg does not match the algorithm the key was issued for.
+func (kr *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+
+	key, exists := kr.keys[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return key.VerifyKey, nil
+}
+
+// Algorithms lists the signing algorithms of every key in the ring.
+func (kr *KeyRing) Algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range kr.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// JWK is the public half of an asymmetric key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes every asymmetric key in the ring. HMAC keys are secret and
+// are never included.
+func (kr *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range kr.keys {
+		switch publicKey := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+	return set
+}
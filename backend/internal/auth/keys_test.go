@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writePEM writes a PKCS#8 private key, or a PKIX public key, to a file.
+func writePEM(t *testing.T, key interface{}) string {
+	t.Helper()
+	var block *pem.Block
+	switch key := key.(type) {
+	case crypto.Signer:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	}
+	file, err := os.CreateTemp(t.TempDir(), "*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := pem.Encode(file, block); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func newPEMRing(t *testing.T, algorithm, id string, key crypto.Signer, previousPublicKeys, previousSecrets string) *KeyRing {
+	t.Helper()
+	ring, err := NewPEMKeyRing(algorithm, id, writePEM(t, key), previousPublicKeys, previousSecrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func TestAsymmetricKeyRotation(t *testing.T) {
+	oldRSA, newRSA := newRSAKey(t), newRSAKey(t)
+	oldEd, newEd := newEd25519Key(t), newEd25519Key(t)
+
+	tests := []struct {
+		name      string
+		algorithm string
+		oldKey    crypto.Signer
+		newKey    crypto.Signer
+	}{
+		{"RS256", "RS256", oldRSA, newRSA},
+		{"EdDSA", "EdDSA", oldEd, newEd},
+		{"RS256 to EdDSA", "EdDSA", oldRSA, newEd},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := newPEMRing(t, algorithmOf(t, test.oldKey), "access-v1", test.oldKey, "", "")
+			after := newPEMRing(t, test.algorithm, "access-v2", test.newKey,
+				"access-v1:"+writePEM(t, test.oldKey.Public()), "")
+			service := newTestTokenService(after, newHMACRing(t, "refresh-v1", "refresh-secret", ""))
+
+			if _, err := service.ParseAccessToken(signedAccessToken(t, after, nil)); err != nil {
+				t.Errorf("token of the active key rejected: %v", err)
+			}
+			if _, err := service.ParseAccessToken(signedAccessToken(t, before, nil)); err != nil {
+				t.Errorf("token of the rotated-out key rejected: %v", err)
+			}
+
+			// Once the old public key is dropped its tokens stop verifying
+			dropped := newTestTokenService(newPEMRing(t, test.algorithm, "access-v2", test.newKey, "", ""), nil)
+			if _, err := dropped.ParseAccessToken(signedAccessToken(t, before, nil)); err == nil {
+				t.Errorf("token of a dropped key accepted")
+			}
+		})
+	}
+}
+
+// algorithmOf returns the JWT algorithm of a private key.
+func algorithmOf(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	signing, err := NewPrivateKey("probe", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signing.Method.Alg()
+}
+
+func TestHMACTokensSurviveSwitchToRS256(t *testing.T) {
+	hmac := newHMACRing(t, "access-v1", "old-access-secret", "")
+	rsaRing := newPEMRing(t, "RS256", "access-v2", newRSAKey(t), "", "access-v1:old-access-secret")
+	service := newTestTokenService(rsaRing, nil)
+
+	if _, err := service.ParseAccessToken(signedAccessToken(t, hmac, nil)); err != nil {
+		t.Errorf("HS256 token issued before the switch rejected: %v", err)
+	}
+}
+
+func TestKeyfuncRejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ring := newPEMRing(t, "RS256", "access-v1", rsaKey, "", "")
+	service := newTestTokenService(ring, nil)
+	publicPEM, err := os.ReadFile(writePEM(t, rsaKey.Public()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{
+		"typ": TokenTypeAccess, "iss": testIssuer, "aud": testAudience,
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	}
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		// The classic confusion attack: HMAC keyed with the public key
+		{"HS256 with the RSA public key", sign(jwt.SigningMethodHS256, "access-v1", publicPEM)},
+		{"EdDSA under an RSA kid", sign(jwt.SigningMethodEdDSA, "access-v1", newEd25519Key(t))},
+		{"RS256 with another key", sign(jwt.SigningMethodRS256, "access-v1", newRSAKey(t))},
+		{"unknown kid", sign(jwt.SigningMethodRS256, "access-v9", rsaKey)},
+		{"no kid", sign(jwt.SigningMethodRS256, "", rsaKey)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := service.ParseAccessToken(test.token); err == nil {
+				t.Errorf("accepted")
+			}
+		})
+	}
+}
+
+func TestNewPEMKeyRingChecksAlgorithm(t *testing.T) {
+	if _, err := NewPEMKeyRing("RS256", "access-v1", writePEM(t, newEd25519Key(t)), "", ""); err == nil {
+		t.Errorf("Ed25519 key accepted for RS256")
+	}
+	if _, err := NewPEMKeyRing("EdDSA", "access-v1", writePEM(t, newRSAKey(t)), "", ""); err == nil {
+		t.Errorf("RSA key accepted for EdDSA")
+	}
+	if _, err := NewPEMKeyRing("RS256", "access-v1", filepath.Join(t.TempDir(), "missing.pem"), "", ""); err == nil {
+		t.Errorf("missing key file accepted")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	edKey := newEd25519Key(t)
+	ring := newPEMRing(t, "RS256", "b-rsa", rsaKey, "a-ed:"+writePEM(t, edKey.Public()), "c-hmac:secret")
+
+	set := ring.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("got %d keys, want the RSA and Ed25519 keys without the HMAC one: %+v", len(set.Keys), set.Keys)
+	}
+
+	ed := set.Keys[0]
+	if ed.KeyID != "a-ed" || ed.KeyType != "OKP" || ed.Algorithm != "EdDSA" || ed.Curve != "Ed25519" || ed.Use != "sig" {
+		t.Errorf("got Ed25519 key %+v", ed)
+	}
+	if x, err := base64.RawURLEncoding.DecodeString(ed.X); err != nil || !edKey.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+		t.Errorf("Ed25519 x does not decode to the public key")
+	}
+
+	rs := set.Keys[1]
+	if rs.KeyID != "b-rsa" || rs.KeyType != "RSA" || rs.Algorithm != "RS256" || rs.Use != "sig" {
+		t.Errorf("got RSA key %+v", rs)
+	}
+	n, errN := base64.RawURLEncoding.DecodeString(rs.N)
+	e, errE := base64.RawURLEncoding.DecodeString(rs.E)
+	if errN != nil || errE != nil {
+		t.Fatalf("RSA key is not base64url: %v %v", errN, errE)
+	}
+	published := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if !rsaKey.PublicKey.Equal(published) {
+		t.Errorf("RSA n and e do not decode to the public key")
+	}
+
+	if keys := newHMACRing(t, "access-v1", "secret", "").JWKS().Keys; len(keys) != 0 {
+		t.Errorf("HMAC ring published %d keys", len(keys))
+	}
+}
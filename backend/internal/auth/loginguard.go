@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+type LoginGuardConfig struct {
+	MaxFailures     int
+	IPMaxFailures   int
+	FailureWindow   time.Duration
+	LockoutDuration time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	// Proxies are addresses many clients log in through. An IP throttle
+	// on them slows logins down instead of refusing them.
+	Proxies []netip.Prefix
+}
+
+// ParseProxies parses comma-separated IPs and CIDRs, as accepted by
+// TRUSTED_PROXIES.
+func ParseProxies(entries []string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy %q: %w", entry, err)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", entry, err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Lockout is an account temporarily barred from password login.
+type Lockout struct {
+	Username       string    `json:"username"`
+	LockedAt       time.Time `json:"locked_at"`
+	LockedUntil    time.Time `json:"locked_until"`
+	FailedAttempts int       `json:"failed_attempts"`
+}
+
+// LoginGuard throttles password logins. Every attempt is recorded in
+// login_attempts as an audit trail; repeated failures for a username slow
+// down responses and eventually lock the account, and too many failures
+// from one IP block that IP across all usernames. Failures from a known
+// proxy only slow its logins down, so one attacker behind it cannot lock
+// everybody else out.
+type LoginGuard struct {
+	db  *sql.DB
+	cfg LoginGuardConfig
+}
+
+func NewLoginGuard(db *sql.DB, cfg LoginGuardConfig) *LoginGuard {
+	return &LoginGuard{db: db, cfg: cfg}
+}
+
+// Check returns how long the username or IP is still blocked for, or zero
+// if a login attempt may proceed after waiting for delay.
+func (g *LoginGuard) Check(username, ip string) (blocked, delay time.Duration, err error) {
+	now := time.Now().UTC()
+
+	var lockedUntil time.Time
+	err = g.db.QueryRow(
+		"SELECT locked_until FROM account_lockouts WHERE username = ?", username,
+	).Scan(&lockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, err
+	}
+	if err == nil && lockedUntil.After(now) {
+		return lockedUntil.Sub(now), 0, nil
+	}
+
+	if g.cfg.IPMaxFailures <= 0 {
+		return 0, 0, nil
+	}
+
+	var failures int
+	var oldest sql.NullTime
+	err = g.db.QueryRow(`
+		SELECT COUNT(*), MIN(attempted_at)
+		FROM login_attempts
+		WHERE ip_address = ? AND succeeded = false AND COALESCE(reason, '') <> 'locked' AND attempted_at > ?
+	`, ip, now.Add(-g.cfg.FailureWindow)).Scan(&failures, &oldest)
+	if err != nil {
+		return 0, 0, err
+	}
+	if failures < g.cfg.IPMaxFailures || !oldest.Valid {
+		return 0, 0, nil
+	}
+	if g.isProxy(ip) {
+		return 0, g.delayFor(failures - g.cfg.IPMaxFailures + 1), nil
+	}
+	return oldest.Time.Add(g.cfg.FailureWindow).Sub(now), 0, nil
+}
+
+func (g *LoginGuard) isProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, proxy := range g.cfg.Proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordFailure audits a failed attempt, locks the account once it reaches
+// the failure limit and returns the delay to apply before responding.
+func (g *LoginGuard) RecordFailure(username, ip, reason string) (time.Duration, error) {
+	now := time.Now().UTC()
+	if err := g.record(username, ip, false, reason, now); err != nil {
+		return 0, err
+	}
+
+	var failures int
+	err := g.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM login_attempts
+		WHERE username = ? AND succeeded = false AND cleared = false
+		  AND COALESCE(reason, '') <> 'locked' AND attempted_at > ?
+	`, username, now.Add(-g.cfg.FailureWindow)).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	if g.cfg.MaxFailures > 0 && failures >= g.cfg.MaxFailures {
+		_, err := g.db.Exec(`
+			INSERT OR REPLACE INTO account_lockouts (username, locked_at, locked_until, failed_attempts)
+			VALUES (?, ?, ?, ?)
+		`, username, now, now.Add(g.cfg.LockoutDuration), failures)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return g.delayFor(failures), nil
+}
+
+// RecordBlocked audits an attempt rejected because of a lockout. Blocked
+// attempts are not failures: they neither extend a lockout nor count towards
+// the next one.
+func (g *LoginGuard) RecordBlocked(username, ip string) error {
+	return g.record(username, ip, false, "locked", time.Now().UTC())
+}
+
+// RecordSuccess audits a successful login and resets the failure count.
+func (g *LoginGuard) RecordSuccess(username, ip string) error {
+	if err := g.record(username, ip, true, "", time.Now().UTC()); err != nil {
+		return err
+	}
+	return g.clearFailures(username)
+}
+
+// Unlock lifts a lockout and forgets the failures that caused it.
+func (g *LoginGuard) Unlock(username string) error {
+	if _, err := g.db.Exec("DELETE FROM account_lockouts WHERE username = ?", username); err != nil {
+		return err
+	}
+	return g.clearFailures(username)
+}
+
+// ActiveLockouts lists accounts that are currently locked.
+func (g *LoginGuard) ActiveLockouts() ([]Lockout, error) {
+	rows, err := g.db.Query(`
+		SELECT username, locked_at, locked_until, failed_attempts
+		FROM account_lockouts
+		WHERE locked_until > ?
+		ORDER BY locked_until DESC
+	`, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []Lockout{}
+	for rows.Next() {
+		var lockout Lockout
+		if err := rows.Scan(&lockout.Username, &lockout.LockedAt, &lockout.LockedUntil, &lockout.FailedAttempts); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, lockout)
+	}
+	return lockouts, rows.Err()
+}
+
+// Wait sleeps for the progressive delay, returning early if the client
+// goes away.
+func (g *LoginGuard) Wait(ctx context.Context, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// delayFor doubles the delay with every consecutive failure, up to MaxDelay.
+func (g *LoginGuard) delayFor(failures int) time.Duration {
+	if failures <= 0 || g.cfg.BaseDelay <= 0 {
+		return 0
+	}
+	delay := g.cfg.BaseDelay
+	for i := 1; i < failures && delay < g.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.cfg.MaxDelay {
+		delay = g.cfg.MaxDelay
+	}
+	return delay
+}
+
+func (g *LoginGuard) record(username, ip string, succeeded bool, reason string, at time.Time) error {
+	_, err := g.db.Exec(`
+		INSERT INTO login_attempts (attempted_at, username, ip_address, succeeded, reason)
+		VALUES (?, ?, ?, ?, ?)
+	`, at, username, ip, succeeded, reason)
+	return err
+}
+
+func (g *LoginGuard) clearFailures(username string) error {
+	_, err := g.db.Exec(
+		"UPDATE login_attempts SET cleared = true WHERE username = ? AND succeeded = false AND cleared = false",
+		username,
+	)
+	return err
+}
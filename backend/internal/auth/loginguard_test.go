@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLoginGuard(t *testing.T, cfg LoginGuardConfig) *LoginGuard {
+	t.Helper()
+	cfg.FailureWindow = 15 * time.Minute
+	cfg.LockoutDuration = 15 * time.Minute
+	return NewLoginGuard(newTestDB(t), cfg)
+}
+
+func recordFailures(t *testing.T, guard *LoginGuard, username, ip string, n int) time.Duration {
+	t.Helper()
+	var delay time.Duration
+	for i := 0; i < n; i++ {
+		var err error
+		if delay, err = guard.RecordFailure(username, ip, "bad_password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return delay
+}
+
+func check(t *testing.T, guard *LoginGuard, username, ip string) (time.Duration, time.Duration) {
+	t.Helper()
+	blocked, delay, err := guard.Check(username, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocked, delay
+}
+
+func TestLoginGuardLocksAccount(t *testing.T) {
+	guard := newTestLoginGuard(t, LoginGuardConfig{MaxFailures: 3})
+
+	recordFailures(t, guard, "analyst", "203.0.113.7", 2)
+	if blocked, _ := check(t, guard, "analyst", "203.0.113.7"); blocked != 0 {
+		t.Fatalf("locked after 2 of 3 failures")
+	}
+
+	// Failures from several IPs add up for the account
+	recordFailures(t, guard, "analyst", "198.51.100.1", 1)
+	blocked, _ := check(t, guard, "analyst", "203.0.113.8")
+	if blocked <= 14*time.Minute || blocked > 15*time.Minute {
+		t.Fatalf("got blocked for %s, want the lockout duration", blocked)
+	}
+	if blocked, _ := check(t, guard, "viewer", "203.0.113.7"); blocked != 0 {
+		t.Errorf("another account locked by analyst's failures")
+	}
+
+	// Attempts refused during the lockout do not count towards the next one
+	for i := 0; i < 3; i++ {
+		if err := guard.RecordBlocked("analyst", "203.0.113.7"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lockouts, err := guard.ActiveLockouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Username != "analyst" || lockouts[0].FailedAttempts != 3 {
+		t.Errorf("got lockouts %+v", lockouts)
+	}
+
+	if err := guard.Unlock("analyst"); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := check(t, guard, "analyst", "203.0.113.7"); blocked != 0 {
+		t.Errorf("still blocked for %s after unlocking", blocked)
+	}
+	// Unlocking forgets the failures, so one more does not lock again
+	recordFailures(t, guard, "analyst", "203.0.113.7", 1)
+	if blocked, _ := check(t, guard, "analyst", "203.0.113.7"); blocked != 0 {
+		t.Errorf("locked again by the first failure after unlocking")
+	}
+}
+
+func TestLoginGuardSuccessResetsFailures(t *testing.T) {
+	guard := newTestLoginGuard(t, LoginGuardConfig{MaxFailures: 3})
+
+	recordFailures(t, guard, "analyst", "203.0.113.7", 2)
+	if err := guard.RecordSuccess("analyst", "203.0.113.7"); err != nil {
+		t.Fatal(err)
+	}
+	recordFailures(t, guard, "analyst", "203.0.113.7", 2)
+	if blocked, _ := check(t, guard, "analyst", "203.0.113.7"); blocked != 0 {
+		t.Errorf("failures before a successful login still counted")
+	}
+}
+
+func TestLoginGuardThrottlesIP(t *testing.T) {
+	guard := newTestLoginGuard(t, LoginGuardConfig{IPMaxFailures: 4})
+
+	// Spread over usernames, so no account reaches a lockout
+	for _, username := range []string{"a", "b", "c"} {
+		recordFailures(t, guard, username, "203.0.113.7", 1)
+	}
+	if blocked, _ := check(t, guard, "d", "203.0.113.7"); blocked != 0 {
+		t.Fatalf("IP blocked after 3 of 4 failures")
+	}
+
+	recordFailures(t, guard, "d", "203.0.113.7", 1)
+	blocked, delay := check(t, guard, "admin", "203.0.113.7")
+	if blocked <= 14*time.Minute || blocked > 15*time.Minute || delay != 0 {
+		t.Errorf("got blocked for %s with delay %s, want blocked for the failure window", blocked, delay)
+	}
+	if blocked, _ := check(t, guard, "admin", "203.0.113.8"); blocked != 0 {
+		t.Errorf("another IP blocked")
+	}
+}
+
+func TestLoginGuardDelaysProxy(t *testing.T) {
+	proxies, err := ParseProxies([]string{"172.28.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard := newTestLoginGuard(t, LoginGuardConfig{
+		IPMaxFailures: 2,
+		BaseDelay:     time.Second,
+		MaxDelay:      4 * time.Second,
+		Proxies:       proxies,
+	})
+
+	recordFailures(t, guard, "a", "172.28.0.5", 1)
+	if blocked, delay := check(t, guard, "b", "172.28.0.5"); blocked != 0 || delay != 0 {
+		t.Fatalf("got blocked for %s with delay %s below the limit", blocked, delay)
+	}
+
+	// Over the limit logins through the proxy slow down progressively
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		recordFailures(t, guard, "a", "172.28.0.5", 1)
+		blocked, delay := check(t, guard, "b", "172.28.0.5")
+		if blocked != 0 || delay != want {
+			t.Errorf("after %d failures got blocked for %s with delay %s, want delay %s", i+2, blocked, delay, want)
+		}
+	}
+}
+
+func TestLoginGuardDelay(t *testing.T) {
+	guard := newTestLoginGuard(t, LoginGuardConfig{BaseDelay: 250 * time.Millisecond, MaxDelay: time.Second})
+
+	for i, want := range []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second, time.Second} {
+		if delay := recordFailures(t, guard, "analyst", "203.0.113.7", 1); delay != want {
+			t.Errorf("failure %d delayed %s, want %s", i+1, delay, want)
+		}
+	}
+
+	disabled := newTestLoginGuard(t, LoginGuardConfig{})
+	if delay := recordFailures(t, disabled, "analyst", "203.0.113.7", 3); delay != 0 {
+		t.Errorf("got delay %s without LOGIN_DELAY_BASE", delay)
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.1", " 172.28.0.0/16 ", "", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 3 || proxies[0].String() != "10.0.0.1/32" || proxies[1].String() != "172.28.0.0/16" || proxies[2].String() != "::1/128" {
+		t.Errorf("got %v", proxies)
+	}
+
+	for _, entry := range []string{"proxy.internal", "10.0.0.0/33"} {
+		if _, err := ParseProxies([]string{entry}); err == nil {
+			t.Errorf("accepted %q", entry)
+		}
+	}
+}
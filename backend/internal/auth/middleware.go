@@ -4,43 +4,35 @@ import (
 	"database/sql"
 	"net/http"
 	"strings"
-	"time"
+
+	"openrtb-insights/internal/logging"
+	"openrtb-insights/internal/tracing"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("openrtb-insights/internal/auth")
+
 type AuthMiddleware struct {
-	db        *sql.DB
-	jwtSecret string
-	limiter   *rate.Limiter
+	db       *sql.DB
+	sessions *SessionStore
+	tokens   *TokenService
+	apiKeys  *APIKeyStore
 }
 
-func NewAuthMiddleware(db *sql.DB, jwtSecret string, rateLimit int) *AuthMiddleware {
+func NewAuthMiddleware(db *sql.DB, sessions *SessionStore, tokens *TokenService, apiKeys *APIKeyStore) *AuthMiddleware {
 	return &AuthMiddleware{
-		db:        db,
-		jwtSecret: jwtSecret,
-		limiter:   rate.NewLimiter(rate.Every(time.Minute), rateLimit),
-	}
-}
-
-func (am *AuthMiddleware) RateLimit() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if !am.limiter.Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
-			})
-			c.Abort()
-			return
-		}
-		c.Next()
+		db:       db,
+		sessions: sessions,
+		tokens:   tokens,
+		apiKeys:  apiKeys,
 	}
 }
 
 func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := am.extractToken(c)
+		tokenString, isAPIKey := am.extractToken(c)
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Authorization token required",
@@ -49,31 +41,71 @@ func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(am.jwtSecret), nil
-		})
+		if isAPIKey {
+			_, span := tracer.Start(c.Request.Context(), "auth.api_key_lookup")
+			key, err := am.apiKeys.Authenticate(tokenString)
+			if err != nil {
+				tracing.RecordError(span, err)
+			}
+			span.End()
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Invalid, expired or revoked API key",
+				})
+				c.Abort()
+				return
+			}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid or expired token",
-			})
-			c.Abort()
+			// API keys carry scopes but no user or role
+			c.Set("auth_method", "api_key")
+			c.Set("api_key_id", key.ID)
+			c.Set("scopes", key.Scopes)
+			c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "api_key_id", key.ID))
+			c.Next()
 			return
 		}
 
-		claims, ok := token.Claims.(*Claims)
-		if !ok {
+		_, span := tracer.Start(c.Request.Context(), "auth.verify_token")
+		claims, err := am.tokens.ParseAccessToken(tokenString)
+		if err != nil {
+			tracing.RecordError(span, err)
+		}
+		span.End()
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token claims",
+				"error": "Invalid or expired token",
 			})
 			c.Abort()
 			return
 		}
 
+		// Reject tokens whose session has been logged out or revoked
+		if claims.SessionID != "" {
+			_, span := tracer.Start(c.Request.Context(), "auth.session_lookup")
+			active, err := am.sessions.IsActive(claims.SessionID)
+			if err != nil {
+				tracing.RecordError(span, err)
+			}
+			span.End()
+			if err != nil || !active {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Session expired or revoked",
+				})
+				c.Abort()
+				return
+			}
+		}
+
 		// Store user info in context
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("session_id", claims.SessionID)
+		c.Set("timezone", claims.Timezone)
+		c.Set("auth_method", "jwt")
+		c.Set("scopes", roleScopes[claims.Role])
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(),
+			"user_id", claims.UserID, "role", claims.Role))
 		c.Next()
 	}
 }
@@ -112,18 +144,57 @@ func (am *AuthMiddleware) RequireRole(roles ...string) gin.HandlerFunc {
 	}
 }
 
-func (am *AuthMiddleware) extractToken(c *gin.Context) string {
+// RequireScope allows API keys holding the scope and users whose role
+// grants it.
+func (am *AuthMiddleware) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, _ := c.Get("scopes")
+		granted, _ := scopes.([]string)
+
+		for _, s := range granted {
+			if s == scope {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Missing required scope: " + scope,
+		})
+		c.Abort()
+	}
+}
+
+// extractToken returns the presented credential and whether it is an API
+// key rather than a JWT.
+func (am *AuthMiddleware) extractToken(c *gin.Context) (string, bool) {
+	// API keys come in their own header or with the ApiKey scheme
+	if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+		return apiKey, true
+	}
+
+	authorization := c.GetHeader("Authorization")
+	if strings.HasPrefix(authorization, "ApiKey ") {
+		return strings.TrimPrefix(authorization, "ApiKey "), true
+	}
+
 	// Try Authorization header first
-	bearerToken := c.GetHeader("Authorization")
-	if strings.HasPrefix(bearerToken, "Bearer ") {
-		return strings.TrimPrefix(bearerToken, "Bearer ")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer "), false
+	}
+
+	// An Authorization header with any other scheme is rejected rather than
+	// falling back to the cookie, which CSRF protection only checks for
+	// requests without one of the schemes above
+	if authorization != "" {
+		return "", false
 	}
 
 	// Try cookie
-	cookie, err := c.Cookie("access_token")
+	cookie, err := c.Cookie(AccessTokenCookie)
 	if err == nil && cookie != "" {
-		return cookie
+		return cookie, false
 	}
 
-	return ""
-}
\ No newline at end of file
+	return "", false
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireAuthCredentialSources(t *testing.T) {
+	db := newTestDB(t)
+	ring := newHMACRing(t, "access-v1", "access-secret", "")
+	middleware := NewAuthMiddleware(db, NewSessionStore(db), newTestTokenService(ring, nil), NewAPIKeyStore(db))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", middleware.RequireAuth(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("auth_method"))
+	})
+
+	// Without a session ID the token is not checked against the sessions
+	token := signedAccessToken(t, ring, func(c *Claims) { c.SessionID = "" })
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        string
+		status        int
+	}{
+		{"cookie", "", token, http.StatusOK},
+		{"Bearer header", "Bearer " + token, "", http.StatusOK},
+		{"Bearer header over the cookie", "Bearer " + token, "invalid", http.StatusOK},
+		{"invalid Bearer header with a valid cookie", "Bearer invalid", token, http.StatusUnauthorized},
+		{"other scheme with a valid cookie", "Basic dXNlcjpwYXNz", token, http.StatusUnauthorized},
+		{"nothing", "", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			if test.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: AccessTokenCookie, Value: test.cookie})
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("got %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+		})
+	}
+}
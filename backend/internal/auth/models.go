@@ -11,6 +11,12 @@ type User struct {
 	Username string `json:"username" db:"username"`
 	Password string `json:"-" db:"password_hash"`
 	Role     string `json:"role" db:"role"`
+	// Timezone is the IANA zone reports default to, e.g. Europe/Berlin
+	Timezone string `json:"timezone" db:"timezone"`
+}
+
+type UpdateTimezoneRequest struct {
+	Timezone string `json:"timezone" binding:"required"`
 }
 
 type LoginRequest struct {
@@ -22,25 +28,73 @@ type LoginResponse struct {
 	User         User   `json:"user"`
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	CSRFToken    string `json:"csrf_token"`
+}
+
+// APIKey is a long-lived, scoped credential for machine clients. Only a
+// hash of the key is stored; the plaintext is shown once at creation.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int        `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
 }
 
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid,omitempty"`
+	Timezone  string `json:"tz,omitempty"`
+	TokenType string `json:"typ"`
 	jwt.RegisteredClaims
 }
 
 type RefreshTokenClaims struct {
-	UserID int `json:"user_id"`
+	UserID    int    `json:"user_id"`
+	SessionID string `json:"sid"`
+	TokenType string `json:"typ"`
 	jwt.RegisteredClaims
 }
 
-func NewClaims(user User, expiry time.Duration) *Claims {
+// Session is a server-side login session backing a refresh token. Only a
+// hash of the current refresh token is stored; presenting any older token
+// of the same session is treated as reuse and revokes the session.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+func NewClaims(user User, sessionID string, expiry time.Duration) *Claims {
 	return &Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Role:     user.Role,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		SessionID: sessionID,
+		Timezone:  user.Timezone,
+		TokenType: TokenTypeAccess,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -48,12 +102,15 @@ func NewClaims(user User, expiry time.Duration) *Claims {
 	}
 }
 
-func NewRefreshTokenClaims(userID int, expiry time.Duration) *RefreshTokenClaims {
+func NewRefreshTokenClaims(userID int, sessionID, tokenID string, expiry time.Duration) *RefreshTokenClaims {
 	return &RefreshTokenClaims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
+		TokenType: TokenTypeRefresh,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-}
\ No newline at end of file
+}
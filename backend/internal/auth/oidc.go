@@ -0,0 +1,352 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"openrtb-insights/internal/logging"
+	"openrtb-insights/internal/metrics"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+
+	// Users provisioned from the IdP never have a usable local password.
+	disabledPasswordHash = "!"
+
+	// maxUsernameLength matches the users.username column
+	maxUsernameLength = 50
+)
+
+var ErrAccountConflict = errors.New("a local account with this username already exists")
+
+type OIDCConfig struct {
+	IssuerURL         string
+	ClientID          string
+	ClientSecret      string
+	RedirectURL       string
+	Scopes            []string
+	GroupsClaim       string
+	AdminGroups       []string
+	AnalystGroups     []string
+	ViewerGroups      []string
+	DefaultRole       string
+	PostLoginRedirect string
+}
+
+// OIDCHandler implements single sign-on with the OIDC authorization code
+// flow and PKCE. Users are provisioned on first login and their role is
+// re-derived from their IdP groups on every login.
+type OIDCHandler struct {
+	handler *Handler
+	cfg     OIDCConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDCHandler(handler *Handler, cfg OIDCConfig) *OIDCHandler {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	return &OIDCHandler{
+		handler: handler,
+		cfg:     cfg,
+	}
+}
+
+func (o *OIDCHandler) Login(c *gin.Context) {
+	oauthConfig, _, err := o.clients(c.Request.Context())
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("OIDC provider unavailable", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Single sign-on is currently unavailable",
+		})
+		return
+	}
+
+	state, err := newRandomID(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start single sign-on",
+		})
+		return
+	}
+	nonce, err := newRandomID(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start single sign-on",
+		})
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// The state, nonce and PKCE verifier only need to survive the round trip
+	// through the IdP, so they live in a short-lived cookie.
+	// Lax even when the auth cookies are Strict, since the IdP redirects back
+	// to the callback cross-site.
+	o.stateCookies().set(c, oidcStateCookie, strings.Join([]string{state, nonce, verifier}, "."),
+		int(oidcStateTTL.Seconds()), "/api/auth/oidc", true)
+
+	c.Redirect(http.StatusFound, oauthConfig.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	))
+}
+
+func (o *OIDCHandler) Callback(c *gin.Context) {
+	stateCookie, err := c.Cookie(oidcStateCookie)
+	o.stateCookies().set(c, oidcStateCookie, "", -1, "/api/auth/oidc", true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Single sign-on session expired, please try again",
+		})
+		return
+	}
+
+	parts := strings.Split(stateCookie, ".")
+	if len(parts) != 3 || c.Query("state") != parts[0] {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid single sign-on state",
+		})
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	if idpError := c.Query("error"); idpError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Single sign-on was rejected by the identity provider: " + idpError,
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	oauthConfig, verifierConfig, err := o.clients(ctx)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("OIDC provider unavailable", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Single sign-on is currently unavailable",
+		})
+		return
+	}
+
+	token, err := oauthConfig.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Warn("OIDC code exchange failed", "error", err)
+		metrics.LoginAttempt("oidc", "failure")
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Failed to complete single sign-on",
+		})
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Identity provider did not return an ID token",
+		})
+		return
+	}
+
+	idToken, err := verifierConfig.Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		logging.FromContext(c.Request.Context()).Warn("OIDC ID token verification failed", "error", err)
+		metrics.LoginAttempt("oidc", "failure")
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid ID token",
+		})
+		return
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid ID token claims",
+		})
+		return
+	}
+
+	role := o.mapRole(groupsFromClaims(claims[o.cfg.GroupsClaim]))
+	if role == "" {
+		metrics.LoginAttempt("oidc", "blocked")
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Your account is not a member of any group with access",
+		})
+		return
+	}
+
+	user, err := o.provisionUser(c.Request.Context(), idToken.Issuer, idToken.Subject, usernameFromClaims(claims, idToken.Subject), role)
+	if err != nil {
+		if errors.Is(err, ErrAccountConflict) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		logging.FromContext(c.Request.Context()).Error("Failed to provision OIDC user", "subject", idToken.Subject, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to provision user",
+		})
+		return
+	}
+
+	if _, ok := o.handler.startSession(c, user); !ok {
+		return
+	}
+	metrics.LoginAttempt("oidc", "success")
+
+	c.Redirect(http.StatusFound, o.cfg.PostLoginRedirect)
+}
+
+func (o *OIDCHandler) stateCookies() CookieConfig {
+	cookies := o.handler.cookies
+	cookies.SameSite = http.SameSiteLaxMode
+	return cookies
+}
+
+// clients lazily discovers the provider so the API can start while the IdP
+// is unreachable; discovery is retried on the next SSO request.
+func (o *OIDCHandler) clients(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.provider == nil {
+		discoveryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		provider, err := oidc.NewProvider(discoveryCtx, o.cfg.IssuerURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("discover %s: %w", o.cfg.IssuerURL, err)
+		}
+		o.provider = provider
+	}
+
+	oauthConfig := &oauth2.Config{
+		ClientID:     o.cfg.ClientID,
+		ClientSecret: o.cfg.ClientSecret,
+		RedirectURL:  o.cfg.RedirectURL,
+		Endpoint:     o.provider.Endpoint(),
+		Scopes:       o.cfg.Scopes,
+	}
+	verifier := o.provider.Verifier(&oidc.Config{ClientID: o.cfg.ClientID})
+	return oauthConfig, verifier, nil
+}
+
+// mapRole returns the most privileged role granted by any of the groups,
+// falling back to the configured default role.
+func (o *OIDCHandler) mapRole(groups []string) string {
+	member := func(allowed []string) bool {
+		for _, group := range groups {
+			for _, candidate := range allowed {
+				if group == candidate {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	switch {
+	case member(o.cfg.AdminGroups):
+		return "Admin"
+	case member(o.cfg.AnalystGroups):
+		return "Analyst"
+	case member(o.cfg.ViewerGroups):
+		return "Viewer"
+	default:
+		return o.cfg.DefaultRole
+	}
+}
+
+// provisionUser finds the user linked to the IdP subject, creating it on
+// first login, and syncs its role with the IdP groups.
+func (o *OIDCHandler) provisionUser(ctx context.Context, issuer, subject, username, role string) (*User, error) {
+	db := o.handler.db
+
+	user, err := scanUser(db.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE auth_provider = ? AND external_subject = ?",
+		issuer, subject,
+	))
+
+	switch {
+	case err == nil:
+		if user.Role != role {
+			if _, err := db.Exec("UPDATE users SET role = ? WHERE id = ?", role, user.ID); err != nil {
+				return nil, err
+			}
+			user.Role = role
+		}
+		return user, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	// Never link an IdP identity to an existing local account by username
+	if _, err := o.handler.getUserByUsername(username); err == nil {
+		return nil, ErrAccountConflict
+	}
+
+	var id int
+	err = db.QueryRow(`
+		INSERT INTO users (id, username, password_hash, role, auth_provider, external_subject)
+		VALUES (nextval('users_id_seq'), ?, ?, ?, ?, ?)
+		RETURNING id
+	`, username, disabledPasswordHash, role, issuer, subject).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Info("Provisioned user", "username", username, "issuer", issuer, "role", role)
+	return &User{ID: id, Username: username, Role: role, Timezone: "UTC"}, nil
+}
+
+func usernameFromClaims(claims map[string]interface{}, subject string) string {
+	for _, key := range []string{"preferred_username", "email"} {
+		if value, ok := claims[key].(string); ok && value != "" {
+			return truncateRunes(value, maxUsernameLength)
+		}
+	}
+	return truncateRunes(subject, maxUsernameLength)
+}
+
+// truncateRunes cuts s to at most n characters without splitting one.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// groupsFromClaims accepts both list and space/comma separated string
+// group claims, since IdPs differ in how they encode them.
+func groupsFromClaims(value interface{}) []string {
+	var groups []string
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if group, ok := item.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	case string:
+		groups = strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	return groups
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"openrtb-insights/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "insights"
+
+// mockIdP is a minimal OIDC provider: discovery, JWKS, an authorization
+// endpoint that approves every request and a token endpoint checking PKCE.
+type mockIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockIdP(t *testing.T, claims jwt.MapClaims) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, claims: claims, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		idp.mu.Lock()
+		idp.codes["code-1"] = query
+		idp.mu.Unlock()
+
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		params := redirect.Query()
+		params.Set("code", "code-1")
+		params.Set("state", query.Get("state"))
+		redirect.RawQuery = params.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		authorize, ok := idp.codes[r.Form.Get("code")]
+		idp.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != authorize.Get("code_challenge") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   idp.URL,
+			"aud":   testClientID,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": authorize.Get("nonce"),
+		}
+		for name, value := range idp.claims {
+			claims[name] = value
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "idp-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Connect("", database.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestOIDCRouter(t *testing.T, db *sql.DB, issuer string) *gin.Engine {
+	t.Helper()
+	accessKeys, err := NewHMACKeyRing("access-test", "access-secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshKeys, err := NewHMACKeyRing("refresh-test", "refresh-secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewTokenService(accessKeys, refreshKeys, "insights-test", "insights-test", 15*time.Minute, time.Hour)
+	handler := NewHandler(db, NewSessionStore(db), tokens, NewAPIKeyStore(db),
+		NewLoginGuard(db, LoginGuardConfig{}), CookieConfig{SameSite: http.SameSiteStrictMode}, true)
+	oidcHandler := NewOIDCHandler(handler, OIDCConfig{
+		IssuerURL:         issuer,
+		ClientID:          testClientID,
+		ClientSecret:      "client-secret",
+		RedirectURL:       "http://insights.test/api/auth/oidc/callback",
+		AnalystGroups:     []string{"insights-analysts"},
+		PostLoginRedirect: "/dashboard",
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/auth/oidc/login", oidcHandler.Login)
+	router.GET("/api/auth/oidc/callback", oidcHandler.Callback)
+	return router
+}
+
+// signIn runs the authorization code flow through the mock IdP and returns
+// the callback response.
+func signIn(t *testing.T, router *gin.Engine) *httptest.ResponseRecorder {
+	t.Helper()
+	login := httptest.NewRecorder()
+	router.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if login.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", login.Code, login.Body)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	authorize, err := client.Get(login.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorize.Body.Close()
+	callbackURL, err := url.Parse(authorize.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback := httptest.NewRequest(http.MethodGet, callbackURL.RequestURI(), nil)
+	for _, cookie := range login.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, callback)
+	return recorder
+}
+
+func TestOIDCCallbackProvisionsUser(t *testing.T) {
+	idp := newMockIdP(t, jwt.MapClaims{
+		"sub":                "subject-42",
+		"preferred_username": "jane.doe",
+		"groups":             []string{"engineering", "insights-analysts"},
+	})
+	db := newTestDB(t)
+	router := newTestOIDCRouter(t, db, idp.URL)
+
+	response := signIn(t, router)
+	if response.Code != http.StatusFound || response.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("callback returned %d to %q: %s", response.Code, response.Header().Get("Location"), response.Body)
+	}
+	cookies := make(map[string]bool)
+	for _, cookie := range response.Result().Cookies() {
+		cookies[cookie.Name] = cookie.Value != ""
+	}
+	if !cookies[AccessTokenCookie] || !cookies[RefreshTokenCookie] {
+		t.Errorf("auth cookies not set: %v", cookies)
+	}
+
+	var username, role, provider string
+	if err := db.QueryRow(
+		"SELECT username, role, auth_provider FROM users WHERE external_subject = ?", "subject-42",
+	).Scan(&username, &role, &provider); err != nil {
+		t.Fatal(err)
+	}
+	if username != "jane.doe" || role != "Analyst" || provider != idp.URL {
+		t.Errorf("provisioned %s as %s from %s", username, role, provider)
+	}
+
+	// A second login finds the same user instead of provisioning another
+	if response := signIn(t, router); response.Code != http.StatusFound {
+		t.Fatalf("second callback returned %d: %s", response.Code, response.Body)
+	}
+	var users int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE external_subject = ?", "subject-42").Scan(&users); err != nil {
+		t.Fatal(err)
+	}
+	if users != 1 {
+		t.Errorf("got %d users for the subject, want 1", users)
+	}
+}
+
+func TestOIDCCallbackRejectsUserWithoutGroup(t *testing.T) {
+	idp := newMockIdP(t, jwt.MapClaims{
+		"sub":                "subject-7",
+		"preferred_username": "outsider",
+		"groups":             []string{"engineering"},
+	})
+	router := newTestOIDCRouter(t, newTestDB(t), idp.URL)
+
+	if response := signIn(t, router); response.Code != http.StatusForbidden {
+		t.Fatalf("callback returned %d, want %d: %s", response.Code, http.StatusForbidden, response.Body)
+	}
+}
+
+func TestUsernameFromClaimsKeepsRunesWhole(t *testing.T) {
+	long := strings.Repeat("é", 60)
+	username := usernameFromClaims(map[string]interface{}{"preferred_username": long}, "subject")
+	if !utf8.ValidString(username) || utf8.RuneCountInString(username) != maxUsernameLength {
+		t.Errorf("got %q, want the first %d characters", username, maxUsernameLength)
+	}
+
+	if username := usernameFromClaims(map[string]interface{}{}, "subject"); username != "subject" {
+		t.Errorf("got %q, want the subject", username)
+	}
+}
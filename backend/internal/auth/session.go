@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session revoked")
+	ErrTokenReused     = errors.New("refresh token reuse detected")
+)
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func NewSessionStore(db *sql.DB) *SessionStore {
+	return &SessionStore{db: db}
+}
+
+// Create persists a new session for the given refresh token.
+func (s *SessionStore) Create(session *Session, refreshToken string) error {
+	now := time.Now().UTC()
+	session.CreatedAt = now
+	session.LastUsedAt = now
+
+	query := `
+		INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address,
+		                      created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := s.db.Exec(query, session.ID, session.UserID, hashToken(refreshToken),
+		session.UserAgent, session.IPAddress, now, now, session.ExpiresAt.UTC())
+	return err
+}
+
+// Rotate swaps the stored refresh token hash from oldToken to newToken. If
+// oldToken is not the session's current token it has already been rotated
+// away, so the session is revoked and ErrTokenReused is returned.
+func (s *SessionStore) Rotate(sessionID, oldToken, newToken string, expiresAt time.Time) error {
+	result, err := s.db.Exec(`
+		UPDATE sessions
+		SET refresh_token_hash = ?, last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?
+	`, hashToken(newToken), time.Now().UTC(), expiresAt.UTC(), sessionID, hashToken(oldToken), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 1 {
+		return nil
+	}
+
+	// Work out why the rotation did not apply
+	var tokenHash string
+	var revokedAt sql.NullTime
+	var expires time.Time
+	err = s.db.QueryRow(
+		"SELECT refresh_token_hash, revoked_at, expires_at FROM sessions WHERE id = ?", sessionID,
+	).Scan(&tokenHash, &revokedAt, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if revokedAt.Valid || !expires.After(time.Now()) {
+		return ErrSessionRevoked
+	}
+	if tokenHash != hashToken(oldToken) {
+		if err := s.Revoke(sessionID, "reuse_detected"); err != nil {
+			return err
+		}
+		return ErrTokenReused
+	}
+	return ErrSessionRevoked
+}
+
+// IsActive reports whether the session exists, is unexpired and not revoked.
+func (s *SessionStore) IsActive(sessionID string) (bool, error) {
+	var count int
+	err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM sessions WHERE id = ? AND revoked_at IS NULL AND expires_at > ?",
+		sessionID, time.Now().UTC(),
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ListActive returns the user's unexpired, unrevoked sessions, most recently
+// used first.
+func (s *SessionStore) ListActive(userID int) ([]Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_used_at DESC
+	`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var userAgent, ipAddress sql.NullString
+		if err := rows.Scan(&session.ID, &session.UserID, &userAgent, &ipAddress,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		session.UserAgent = userAgent.String
+		session.IPAddress = ipAddress.String
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke marks a single session as revoked.
+func (s *SessionStore) Revoke(sessionID, reason string) error {
+	_, err := s.db.Exec(
+		"UPDATE sessions SET revoked_at = ?, revoked_reason = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), reason, sessionID,
+	)
+	return err
+}
+
+// RevokeForUser revokes a session only if it belongs to userID.
+func (s *SessionStore) RevokeForUser(userID int, sessionID, reason string) error {
+	result, err := s.db.Exec(
+		"UPDATE sessions SET revoked_at = ?, revoked_reason = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), reason, sessionID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOthers revokes every active session of userID except keepID and
+// returns how many were revoked.
+func (s *SessionStore) RevokeOthers(userID int, keepID, reason string) (int64, error) {
+	result, err := s.db.Exec(
+		"UPDATE sessions SET revoked_at = ?, revoked_reason = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL",
+		time.Now().UTC(), reason, userID, keepID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRandomID(bytes int) (string, error) {
+	b := make([]byte, bytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
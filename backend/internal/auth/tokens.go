@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// TokenService issues and validates access and refresh tokens. The two token
+// types are signed by separate key rings and carry an explicit typ claim, so
+// one can never be accepted in place of the other.
+type TokenService struct {
+	accessKeys    *KeyRing
+	refreshKeys   *KeyRing
+	issuer        string
+	audience      string
+	accessExpiry  time.Duration
+	refreshExpiry time.Duration
+}
+
+func NewTokenService(accessKeys, refreshKeys *KeyRing, issuer, audience string, accessExpiry, refreshExpiry time.Duration) *TokenService {
+	return &TokenService{
+		accessKeys:    accessKeys,
+		refreshKeys:   refreshKeys,
+		issuer:        issuer,
+		audience:      audience,
+		accessExpiry:  accessExpiry,
+		refreshExpiry: refreshExpiry,
+	}
+}
+
+func (ts *TokenService) AccessExpiry() time.Duration {
+	return ts.accessExpiry
+}
+
+func (ts *TokenService) RefreshExpiry() time.Duration {
+	return ts.refreshExpiry
+}
+
+// JWKS returns the public access token keys for other services to verify
+// our tokens with.
+func (ts *TokenService) JWKS() JWKSet {
+	return ts.accessKeys.JWKS()
+}
+
+func (ts *TokenService) IssueAccessToken(user User, sessionID string) (string, error) {
+	claims := NewClaims(user, sessionID, ts.accessExpiry)
+	claims.Issuer = ts.issuer
+	claims.Audience = jwt.ClaimStrings{ts.audience}
+	return ts.accessKeys.Sign(claims)
+}
+
+func (ts *TokenService) IssueRefreshToken(userID int, sessionID string) (string, error) {
+	tokenID, err := newRandomID(16)
+	if err != nil {
+		return "", err
+	}
+	claims := NewRefreshTokenClaims(userID, sessionID, tokenID, ts.refreshExpiry)
+	claims.Issuer = ts.issuer
+	claims.Audience = jwt.ClaimStrings{ts.audience}
+	return ts.refreshKeys.Sign(claims)
+}
+
+func (ts *TokenService) ParseAccessToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if err := ts.parse(tokenString, claims, ts.accessKeys); err != nil {
+		return nil, err
+	}
+	if claims.TokenType != TokenTypeAccess {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (ts *TokenService) ParseRefreshToken(tokenString string) (*RefreshTokenClaims, error) {
+	claims := &RefreshTokenClaims{}
+	if err := ts.parse(tokenString, claims, ts.refreshKeys); err != nil {
+		return nil, err
+	}
+	if claims.TokenType != TokenTypeRefresh || claims.SessionID == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (ts *TokenService) parse(tokenString string, claims jwt.Claims, keys *KeyRing) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc,
+		jwt.WithValidMethods(keys.Algorithms()),
+		jwt.WithIssuer(ts.issuer),
+		jwt.WithAudience(ts.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil || !token.Valid {
+		return ErrInvalidToken
+	}
+	return nil
+}
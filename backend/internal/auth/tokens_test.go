@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "insights-test"
+	testAudience = "insights-test"
+)
+
+func newHMACRing(t *testing.T, id, secret, previous string) *KeyRing {
+	t.Helper()
+	ring, err := NewHMACKeyRing(id, secret, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func newTestTokenService(accessKeys, refreshKeys *KeyRing) *TokenService {
+	return NewTokenService(accessKeys, refreshKeys, testIssuer, testAudience, 15*time.Minute, time.Hour)
+}
+
+// signedAccessToken signs access token claims as the service would, after
+// edit has changed them.
+func signedAccessToken(t *testing.T, ring *KeyRing, edit func(*Claims)) string {
+	t.Helper()
+	claims := NewClaims(User{ID: 7, Username: "analyst", Role: "Analyst"}, "session", 15*time.Minute)
+	claims.Issuer = testIssuer
+	claims.Audience = jwt.ClaimStrings{testAudience}
+	if edit != nil {
+		edit(claims)
+	}
+	token, err := ring.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestParseAccessToken(t *testing.T) {
+	current := newHMACRing(t, "access-v2", "new-access-secret", "access-v1:old-access-secret")
+	refresh := newHMACRing(t, "refresh-v1", "refresh-secret", "")
+	service := newTestTokenService(current, refresh)
+
+	refreshToken, err := service.IssueRefreshToken(7, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameSecretOtherKid := newHMACRing(t, "access-v3", "new-access-secret", "")
+	retired := newHMACRing(t, "access-v0", "retired-secret", "")
+	previous := newHMACRing(t, "access-v1", "old-access-secret", "")
+	forged := newHMACRing(t, "access-v1", "guessed-secret", "")
+
+	hs384 := jwt.NewWithClaims(jwt.SigningMethodHS384, jwt.MapClaims{
+		"typ": TokenTypeAccess, "iss": testIssuer, "aud": testAudience,
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	hs384.Header["kid"] = "access-v2"
+	hs384Token, err := hs384.SignedString([]byte("new-access-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"active key", signedAccessToken(t, current, nil), true},
+		{"previous key after rotation", signedAccessToken(t, previous, nil), true},
+		{"key rotated out", signedAccessToken(t, retired, nil), false},
+		{"unknown kid", signedAccessToken(t, sameSecretOtherKid, nil), false},
+		{"known kid with wrong secret", signedAccessToken(t, forged, nil), false},
+		{"alg other than the key's", hs384Token, false},
+		{"refresh token", refreshToken, false},
+		{"wrong token type", signedAccessToken(t, current, func(c *Claims) { c.TokenType = TokenTypeRefresh }), false},
+		{"wrong issuer", signedAccessToken(t, current, func(c *Claims) { c.Issuer = "someone-else" }), false},
+		{"wrong audience", signedAccessToken(t, current, func(c *Claims) { c.Audience = jwt.ClaimStrings{"other"} }), false},
+		{"expired", signedAccessToken(t, current, func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		}), false},
+		{"no expiry", signedAccessToken(t, current, func(c *Claims) { c.ExpiresAt = nil }), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := service.ParseAccessToken(test.token)
+			if test.valid {
+				if err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				if claims.UserID != 7 || claims.Role != "Analyst" {
+					t.Errorf("got user %d as %s", claims.UserID, claims.Role)
+				}
+			} else if err == nil {
+				t.Errorf("accepted")
+			}
+		})
+	}
+}
+
+func TestParseRefreshToken(t *testing.T) {
+	access := newHMACRing(t, "access-v1", "access-secret", "")
+	refresh := newHMACRing(t, "refresh-v2", "new-refresh-secret", "refresh-v1:old-refresh-secret")
+	service := newTestTokenService(access, refresh)
+
+	token, err := service.IssueRefreshToken(7, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := service.ParseRefreshToken(token)
+	if err != nil {
+		t.Fatalf("refresh token rejected: %v", err)
+	}
+	if claims.UserID != 7 || claims.SessionID != "session" || claims.ID == "" {
+		t.Errorf("got claims %+v", claims)
+	}
+
+	// A refresh token issued before the rotation stays valid until it expires
+	before := newTestTokenService(access, newHMACRing(t, "refresh-v1", "old-refresh-secret", ""))
+	old, err := before.IssueRefreshToken(7, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ParseRefreshToken(old); err != nil {
+		t.Errorf("refresh token of the previous key rejected: %v", err)
+	}
+
+	accessToken, err := service.IssueAccessToken(User{ID: 7, Role: "Analyst"}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ParseRefreshToken(accessToken); err == nil {
+		t.Errorf("access token accepted as a refresh token")
+	}
+}
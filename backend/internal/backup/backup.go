@@ -0,0 +1,380 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openrtb-insights/internal/metrics"
+	"openrtb-insights/internal/version"
+)
+
+const (
+	// formatVersion changes when the archive layout does
+	formatVersion = 1
+
+	manifestName = "manifest.json"
+	dataDir      = "data/"
+	filePrefix   = "analytics-"
+	fileSuffix   = ".tar.gz"
+	timeLayout   = "20060102T150405Z"
+)
+
+// Manifest describes the database captured in a backup archive.
+type Manifest struct {
+	Format        int              `json:"format"`
+	CreatedAt     time.Time        `json:"created_at"`
+	SchemaVersion int              `json:"schema_version"`
+	AppVersion    string           `json:"app_version"`
+	Commit        string           `json:"commit"`
+	Tables        map[string]int64 `json:"tables"`
+}
+
+// Info is a backup archive in the backup directory.
+type Info struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Manifest *Manifest `json:"manifest"`
+}
+
+// Config controls where backups are written and how many are kept.
+type Config struct {
+	Dir string
+	// Keep is the number of archives kept, 0 for no limit
+	Keep int
+	// MaxAge deletes older archives, 0 for no limit. The newest archive is
+	// always kept.
+	MaxAge time.Duration
+}
+
+// Manager writes consistent backups of a live database. Each backup is an
+// EXPORT DATABASE run inside one transaction, so it is a snapshot even
+// while the server keeps writing, packed with a manifest into a tar.gz.
+type Manager struct {
+	db  *sql.DB
+	cfg Config
+	mu  sync.Mutex
+}
+
+func NewManager(db *sql.DB, cfg Config) *Manager {
+	return &Manager{db: db, cfg: cfg}
+}
+
+// Loop writes a backup every interval until ctx is done.
+func (m *Manager) Loop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := m.Create(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Failed to back up database", "error", err)
+		}
+	}
+}
+
+// Create writes a backup archive and prunes old ones.
+func (m *Manager) Create(ctx context.Context) (info Info, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer func() { metrics.BackupFinished(err == nil) }()
+
+	if err := os.MkdirAll(m.cfg.Dir, 0o750); err != nil {
+		return Info{}, err
+	}
+	exportDir, err := os.MkdirTemp(m.cfg.Dir, ".export-")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.RemoveAll(exportDir)
+
+	manifest, err := export(ctx, m.db, exportDir)
+	if err != nil {
+		return Info{}, fmt.Errorf("export database: %w", err)
+	}
+
+	name := filePrefix + manifest.CreatedAt.Format(timeLayout) + fileSuffix
+	path := filepath.Join(m.cfg.Dir, name)
+	if err := writeArchive(path, manifest, exportDir); err != nil {
+		return Info{}, fmt.Errorf("write archive: %w", err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	info = Info{Name: name, Size: stat.Size(), Manifest: manifest}
+	slog.Info("Backed up database", "file", path, "bytes", info.Size, "schema_version", manifest.SchemaVersion)
+
+	if err := m.prune(); err != nil {
+		slog.Error("Failed to prune old backups", "error", err)
+	}
+	return info, nil
+}
+
+// List returns the archives in the backup directory, newest first.
+func (m *Manager) List() ([]Info, error) {
+	names, err := m.archives()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]Info, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(m.cfg.Dir, name)
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		info := Info{Name: name, Size: stat.Size()}
+		// A damaged archive is still listed, without its manifest
+		if manifest, err := ReadManifest(path); err == nil {
+			info.Manifest = manifest
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// archives returns the archive names, newest first. Names embed the UTC
+// creation time, so they sort chronologically.
+func (m *Manager) archives() ([]string, error) {
+	entries, err := os.ReadDir(m.cfg.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
+
+func (m *Manager) prune() error {
+	names, err := m.archives()
+	if err != nil {
+		return err
+	}
+
+	for i, name := range names {
+		if i == 0 {
+			continue
+		}
+		expired := false
+		if m.cfg.Keep > 0 && i >= m.cfg.Keep {
+			expired = true
+		}
+		if m.cfg.MaxAge > 0 {
+			created, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+			if err == nil && time.Since(created) > m.cfg.MaxAge {
+				expired = true
+			}
+		}
+		if !expired {
+			continue
+		}
+		if err := os.Remove(filepath.Join(m.cfg.Dir, name)); err != nil {
+			return err
+		}
+		slog.Info("Deleted old backup", "file", name)
+	}
+	return nil
+}
+
+// export writes the database to dir with EXPORT DATABASE. The row counts
+// are read in the same transaction, so they describe the exported snapshot.
+func export(ctx context.Context, db *sql.DB, dir string) (*Manifest, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	manifest := &Manifest{
+		Format:     formatVersion,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+		AppVersion: version.Version,
+		Commit:     version.Commit,
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&manifest.SchemaVersion); err != nil {
+		return nil, err
+	}
+	if manifest.Tables, err = countRows(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("EXPORT DATABASE '%s' (FORMAT PARQUET)", escape(dir))); err != nil {
+		return nil, err
+	}
+	return manifest, tx.Commit()
+}
+
+// queryer is satisfied by *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func countRows(ctx context.Context, q queryer) (map[string]int64, error) {
+	rows, err := q.QueryContext(ctx, "SELECT table_name FROM duckdb_tables() WHERE NOT internal AND schema_name = 'main'")
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(tables))
+	for _, table := range tables {
+		var n int64
+		if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM "`+table+`"`).Scan(&n); err != nil {
+			return nil, err
+		}
+		counts[table] = n
+	}
+	return counts, nil
+}
+
+// writeArchive packs the manifest, first so it can be read without
+// unpacking the data, and the export files into a tar.gz. The archive is
+// written under a temporary name and renamed, so a crash never leaves a
+// partial archive that looks complete.
+func writeArchive(path string, manifest *Manifest, exportDir string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, manifestName, manifest.CreatedAt, int64(len(manifestJSON)), strings.NewReader(string(manifestJSON))); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(exportDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := addFile(tw, filepath.Join(exportDir, entry.Name()), dataDir+entry.Name(), manifest.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func addFile(tw *tar.Writer, path, name string, modTime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeEntry(tw, name, modTime, stat.Size(), f)
+}
+
+func writeEntry(tw *tar.Writer, name string, modTime time.Time, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0o640,
+		Size:     size,
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// ReadManifest reads the manifest of a backup archive.
+func ReadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if header.Name != manifestName {
+		return nil, fmt.Errorf("%s is not a backup archive: first entry is %q", path, header.Name)
+	}
+
+	var manifest Manifest
+	if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func escape(path string) string {
+	return strings.ReplaceAll(path, "'", "''")
+}
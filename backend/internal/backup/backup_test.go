@@ -0,0 +1,320 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"openrtb-insights/internal/database"
+)
+
+// newTestDatabase creates a migrated database file with rows in
+// platform_stats.
+func newTestDatabase(t *testing.T, path string, days int) {
+	t.Helper()
+	db := openTestDatabase(t, path)
+	defer db.Close()
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < days; i++ {
+		if _, err := db.Exec("INSERT INTO platform_stats (date, total_requests) VALUES (?, ?)",
+			time.Date(2026, 3, 1+i, 0, 0, 0, 0, time.UTC), 100*(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func openTestDatabase(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := database.Connect(path, database.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func tableCounts(t *testing.T, path string) map[string]int64 {
+	t.Helper()
+	db := openTestDatabase(t, path)
+	defer db.Close()
+	counts, err := countRows(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+// writeTestArchive packs a manifest and data files the way writeArchive
+// does, under any entry names.
+func writeTestArchive(t *testing.T, path string, manifest *Manifest, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeEntry(tw, manifestName, time.Now(), int64(len(manifestJSON)), strings.NewReader(string(manifestJSON))); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := writeEntry(tw, name, time.Now(), int64(len(content)), strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rewriteManifest copies an archive with its manifest changed by edit.
+func rewriteManifest(t *testing.T, from, to string, edit func(*Manifest)) {
+	t.Helper()
+	manifest, err := ReadManifest(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(manifest)
+
+	in, err := os.Open(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	gzIn, err := gzip.NewReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzIn)
+
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name == manifestName {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(content)
+	}
+	writeTestArchive(t, to, manifest, files)
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "analytics.db")
+	newTestDatabase(t, dbPath, 3)
+	backedUp := tableCounts(t, dbPath)
+
+	db := openTestDatabase(t, dbPath)
+	info, err := NewManager(db, Config{Dir: filepath.Join(dir, "backups")}).Create(context.Background())
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	// Rows written after the backup are not in it
+	if _, err := db.Exec("INSERT INTO platform_stats (date, total_requests) VALUES ('2026-04-01', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if info.Manifest.Format != formatVersion || info.Manifest.SchemaVersion != database.LatestSchemaVersion() {
+		t.Errorf("got manifest %+v", info.Manifest)
+	}
+	if info.Manifest.Tables["platform_stats"] != 3 || info.Manifest.Tables["users"] == 0 {
+		t.Errorf("got table counts %v", info.Manifest.Tables)
+	}
+	archive := filepath.Join(dir, "backups", info.Name)
+	if manifest, err := ReadManifest(archive); err != nil || manifest.SchemaVersion != info.Manifest.SchemaVersion {
+		t.Fatalf("got manifest %+v from the archive: %v", manifest, err)
+	}
+
+	result, err := Restore(context.Background(), archive, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SchemaVersion != database.LatestSchemaVersion() {
+		t.Errorf("restored schema version %d", result.SchemaVersion)
+	}
+
+	restored := tableCounts(t, dbPath)
+	if len(restored) != len(backedUp) {
+		t.Errorf("restored %d tables, backed up %d", len(restored), len(backedUp))
+	}
+	for table, want := range backedUp {
+		if got := restored[table]; got != want {
+			t.Errorf("table %s has %d rows after restoring, want %d", table, got, want)
+		}
+	}
+
+	// The replaced database is kept beside the restored one
+	if !strings.HasPrefix(filepath.Base(result.Previous), "analytics.db.pre-restore-") {
+		t.Fatalf("replaced database moved to %q", result.Previous)
+	}
+	if got := tableCounts(t, result.Previous)["platform_stats"]; got != 4 {
+		t.Errorf("replaced database has %d platform_stats rows, want 4", got)
+	}
+	if _, err := os.Stat(dbPath + ".restoring"); !os.IsNotExist(err) {
+		t.Errorf("staged database left behind")
+	}
+}
+
+func TestRestoreChecksManifest(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.db")
+	newTestDatabase(t, source, 2)
+	db := openTestDatabase(t, source)
+	info, err := NewManager(db, Config{Dir: dir}).Create(context.Background())
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, info.Name)
+
+	tests := []struct {
+		name string
+		edit func(*Manifest)
+		want string
+	}{
+		{"format", func(m *Manifest) { m.Format = formatVersion + 1 }, "unsupported backup format"},
+		{"newer schema", func(m *Manifest) { m.SchemaVersion = database.LatestSchemaVersion() + 1 }, "restore it with a newer release"},
+		{"older schema", func(m *Manifest) { m.SchemaVersion-- }, "imported schema version"},
+		{"row counts", func(m *Manifest) { m.Tables["platform_stats"] = 3 }, "table platform_stats has 2 rows after import, manifest says 3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			edited := filepath.Join(t.TempDir(), "edited.tar.gz")
+			rewriteManifest(t, archive, edited, test.edit)
+			dbPath := filepath.Join(t.TempDir(), "analytics.db")
+			newTestDatabase(t, dbPath, 5)
+
+			_, err := Restore(context.Background(), edited, dbPath)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got %v, want an error about %q", err, test.want)
+			}
+			// A failed restore leaves the current database alone
+			if got := tableCounts(t, dbPath)["platform_stats"]; got != 5 {
+				t.Errorf("current database has %d rows after a failed restore, want 5", got)
+			}
+			if _, err := os.Stat(dbPath + ".restoring"); !os.IsNotExist(err) {
+				t.Errorf("staged database left behind")
+			}
+		})
+	}
+}
+
+func TestRestoreRejectsUnexpectedEntries(t *testing.T) {
+	manifest := &Manifest{Format: formatVersion, SchemaVersion: database.LatestSchemaVersion()}
+
+	for _, name := range []string{"../escape", "data/../../escape", "data/nested/file", "data/..", `data/..\escape`, "other/file"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "hostile.tar.gz")
+			writeTestArchive(t, archive, manifest, map[string]string{name: "payload"})
+
+			_, err := Restore(context.Background(), archive, filepath.Join(dir, "analytics.db"))
+			if err == nil || !strings.Contains(err.Error(), "unexpected archive entry") {
+				t.Errorf("got %v, want the entry rejected", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+				t.Errorf("entry written outside the extract directory")
+			}
+		})
+	}
+
+	// An archive whose first entry is not the manifest
+	dir := t.TempDir()
+	reordered := filepath.Join(dir, "reordered.tar.gz")
+	f, err := os.Create(reordered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	if err := writeEntry(tw, dataDir+"schema.sql", time.Now(), 0, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+	if _, err := Restore(context.Background(), reordered, filepath.Join(dir, "analytics.db")); err == nil {
+		t.Errorf("archive without a manifest restored")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	var names []string
+	for _, age := range []time.Duration{0, time.Hour, 25 * time.Hour, 49 * time.Hour} {
+		name := filePrefix + now.Add(-age).Format(timeLayout) + fileSuffix
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o640); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	// Files that are not archives are left alone
+	if err := os.WriteFile(filepath.Join(dir, "analytics.db.pre-restore-"+now.Format(timeLayout)), nil, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining := func() []string {
+		t.Helper()
+		archives, err := (&Manager{cfg: Config{Dir: dir}}).archives()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return archives
+	}
+
+	if err := (&Manager{cfg: Config{Dir: dir, Keep: 3}}).prune(); err != nil {
+		t.Fatal(err)
+	}
+	if got := remaining(); strings.Join(got, ",") != strings.Join(names[:3], ",") {
+		t.Errorf("kept %v with Keep=3, want %v", got, names[:3])
+	}
+
+	if err := (&Manager{cfg: Config{Dir: dir, MaxAge: 24 * time.Hour}}).prune(); err != nil {
+		t.Fatal(err)
+	}
+	if got := remaining(); strings.Join(got, ",") != strings.Join(names[:2], ",") {
+		t.Errorf("kept %v with MaxAge=24h, want %v", got, names[:2])
+	}
+
+	// The newest archive is kept however old it is
+	if err := (&Manager{cfg: Config{Dir: dir, Keep: 1, MaxAge: time.Nanosecond}}).prune(); err != nil {
+		t.Fatal(err)
+	}
+	if got := remaining(); len(got) != 1 || got[0] != names[0] {
+		t.Errorf("kept %v, want only the newest", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "analytics.db.pre-restore-"+now.Format(timeLayout))); err != nil {
+		t.Errorf("pruning removed the pre-restore database: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"net/http"
+
+	"openrtb-insights/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	manager *Manager
+}
+
+func NewHandler(manager *Manager) *Handler {
+	return &Handler{manager: manager}
+}
+
+// ListBackups returns the archives in the backup directory, newest first.
+func (h *Handler) ListBackups(c *gin.Context) {
+	backups, err := h.manager.List()
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to list backups", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve backups",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  backups,
+		"count": len(backups),
+	})
+}
+
+// CreateBackup takes an online backup of the database.
+func (h *Handler) CreateBackup(c *gin.Context) {
+	info, err := h.manager.Create(c.Request.Context())
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to back up database", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create backup",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data": info,
+	})
+}
@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"openrtb-insights/internal/database"
+)
+
+// maxEntrySize bounds each extracted file, so a corrupt or hostile archive
+// cannot fill the disk.
+const maxEntrySize = 64 << 30
+
+// RestoreResult describes a completed restore.
+type RestoreResult struct {
+	Manifest *Manifest
+	// SchemaVersion is the restored database's version after migrating
+	SchemaVersion int
+	// Previous is where the replaced database was moved, empty when there
+	// was none
+	Previous string
+}
+
+// Restore replaces the database at dbPath with the contents of a backup
+// archive. The server must be stopped. The archive is imported into a new
+// file next to dbPath and checked against its manifest, then migrated to
+// this build's schema; only then is the current database moved aside and
+// the new one renamed into place.
+func Restore(ctx context.Context, archive, dbPath string) (*RestoreResult, error) {
+	manifest, err := ReadManifest(archive)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if manifest.Format != formatVersion {
+		return nil, fmt.Errorf("unsupported backup format %d, expected %d", manifest.Format, formatVersion)
+	}
+	latest := database.LatestSchemaVersion()
+	if manifest.SchemaVersion > latest {
+		return nil, fmt.Errorf("backup has schema version %d, newer than this build's %d; restore it with a newer release", manifest.SchemaVersion, latest)
+	}
+
+	if err := ensureUnused(dbPath); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(dbPath)
+	extractDir, err := os.MkdirTemp(dir, ".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(extractDir)
+	if err := extract(archive, extractDir); err != nil {
+		return nil, fmt.Errorf("extract archive: %w", err)
+	}
+
+	staged := dbPath + ".restoring"
+	removeDatabase(staged)
+	schemaVersion, err := importDatabase(ctx, staged, filepath.Join(extractDir, dataDir), manifest)
+	if err != nil {
+		removeDatabase(staged)
+		return nil, err
+	}
+
+	result := &RestoreResult{Manifest: manifest, SchemaVersion: schemaVersion}
+	if _, err := os.Stat(dbPath); err == nil {
+		result.Previous = dbPath + ".pre-restore-" + time.Now().UTC().Format(timeLayout)
+		if err := os.Rename(dbPath, result.Previous); err != nil {
+			removeDatabase(staged)
+			return nil, err
+		}
+		// The WAL moves with its database so it is not replayed into the
+		// restored file
+		if err := os.Rename(dbPath+".wal", result.Previous+".wal"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			removeDatabase(staged)
+			return nil, err
+		}
+	}
+	if err := os.Rename(staged, dbPath); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ensureUnused fails when another process, normally a running server, holds
+// the database open; DuckDB allows a single process per file.
+func ensureUnused(dbPath string) error {
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	db, err := sql.Open("duckdb", dbPath)
+	if err == nil {
+		err = db.Ping()
+		db.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("open %s, stop the server before restoring: %w", dbPath, err)
+	}
+	return nil
+}
+
+func importDatabase(ctx context.Context, path, exportDir string, manifest *Manifest) (int, error) {
+	db, err := database.Connect(path, database.Options{})
+	if err != nil {
+		return 0, err
+	}
+	defer database.Close(db)
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("IMPORT DATABASE '%s'", escape(exportDir))); err != nil {
+		return 0, fmt.Errorf("import database: %w", err)
+	}
+
+	imported, err := database.SchemaVersion(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	if imported != manifest.SchemaVersion {
+		return 0, fmt.Errorf("imported schema version %d, manifest says %d", imported, manifest.SchemaVersion)
+	}
+	counts, err := countRows(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	for table, want := range manifest.Tables {
+		if got, ok := counts[table]; !ok || got != want {
+			return 0, fmt.Errorf("table %s has %d rows after import, manifest says %d", table, got, want)
+		}
+	}
+
+	// Bring an older backup up to this build's schema
+	if err := database.RunMigrations(db); err != nil {
+		return 0, fmt.Errorf("migrate restored database: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, "CHECKPOINT"); err != nil {
+		return 0, err
+	}
+	return database.SchemaVersion(ctx, db)
+}
+
+// extract unpacks the archive into dir. Only regular files directly under
+// the manifest and data directory are accepted.
+func extract(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(filepath.Join(dir, dataDir), 0o750); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Name == manifestName {
+			continue
+		}
+		name := strings.TrimPrefix(header.Name, dataDir)
+		if header.Typeflag != tar.TypeReg || name == header.Name || name == "" || strings.ContainsAny(name, `/\`) || name == ".." {
+			return fmt.Errorf("unexpected archive entry %q", header.Name)
+		}
+		if header.Size > maxEntrySize {
+			return fmt.Errorf("archive entry %q is too large", header.Name)
+		}
+		if err := writeFile(filepath.Join(dir, dataDir, name), tr); err != nil {
+			return err
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func removeDatabase(path string) {
+	os.Remove(path)
+	os.Remove(path + ".wal")
+}
@@ -0,0 +1,226 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// NewWithin is how recently a parameter must have been first seen to
+	// be flagged as new
+	NewWithin = 7 * 24 * time.Hour
+	// GoneAfter is how long a parameter must have been missing from flushes
+	// other parameters still arrive in to be flagged as disappeared
+	GoneAfter = 24 * time.Hour
+
+	maxSampleValues = 5
+	// maxSampleLength skips samples of whole objects and long strings
+	maxSampleLength = 200
+)
+
+// Entry is a parameter path in the catalog. Counts are cumulative over
+// every flush that carried the path, so they outlive the presence tables'
+// retention.
+type Entry struct {
+	Path          string          `json:"path"`
+	FirstSeen     time.Time       `json:"first_seen"`
+	LastSeen      time.Time       `json:"last_seen"`
+	PresenceCount int64           `json:"presence_count"`
+	TotalRequests int64           `json:"total_requests"`
+	PresenceRate  float64         `json:"presence_rate"`
+	SampleValues  json.RawMessage `json:"sample_values"`
+	NewThisWeek   bool            `json:"new_this_week"`
+	Disappeared   bool            `json:"disappeared"`
+}
+
+// Observation is a parameter as reported by one agent flush.
+type Observation struct {
+	Path          string
+	FirstSeen     time.Time
+	LastSeen      time.Time
+	PresenceCount int64
+	TotalRequests int64
+	SampleValues  []interface{}
+}
+
+// Forget takes a flush that is about to be replaced out of the cumulative
+// counts. Call it in the ingest transaction before the flush's
+// parameter_presence rows are deleted.
+func Forget(ctx context.Context, tx *sql.Tx, agentID string, windowStart time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE parameter_catalog
+		SET presence_count = parameter_catalog.presence_count - p.presence_count,
+		    total_requests = parameter_catalog.total_requests - p.total_requests
+		FROM parameter_presence p
+		WHERE p.parameter_path = parameter_catalog.parameter_path
+		  AND p.agent_id = ? AND p.window_start = ?
+	`, agentID, windowStart)
+	if err != nil {
+		return fmt.Errorf("forget replaced flush: %w", err)
+	}
+	return nil
+}
+
+// Record adds a flush's parameters to the catalog, adding new paths. The
+// seen times only ever widen, and sample values are replaced by the
+// flush's when it has any.
+func Record(ctx context.Context, tx *sql.Tx, observations []Observation) error {
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO parameter_catalog
+			(parameter_path, first_seen, last_seen, presence_count, total_requests, sample_values, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (parameter_path) DO UPDATE SET
+			first_seen = LEAST(parameter_catalog.first_seen, excluded.first_seen),
+			last_seen = GREATEST(parameter_catalog.last_seen, excluded.last_seen),
+			presence_count = parameter_catalog.presence_count + excluded.presence_count,
+			total_requests = parameter_catalog.total_requests + excluded.total_requests,
+			sample_values = COALESCE(excluded.sample_values, parameter_catalog.sample_values),
+			updated_at = excluded.updated_at
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	for _, o := range observations {
+		samples, err := encodeSamples(o.SampleValues)
+		if err != nil {
+			return fmt.Errorf("encode samples of %s: %w", o.Path, err)
+		}
+		if _, err := stmt.ExecContext(ctx, o.Path, o.FirstSeen.UTC(), o.LastSeen.UTC(),
+			o.PresenceCount, o.TotalRequests, samples, now); err != nil {
+			return fmt.Errorf("record %s: %w", o.Path, err)
+		}
+	}
+	return nil
+}
+
+// encodeSamples returns up to maxSampleValues distinct short samples as a
+// JSON array, or nil when there are none.
+func encodeSamples(values []interface{}) (interface{}, error) {
+	var samples []json.RawMessage
+	seen := make(map[string]bool)
+	for _, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(encoded) > maxSampleLength || seen[string(encoded)] {
+			continue
+		}
+		seen[string(encoded)] = true
+		samples = append(samples, encoded)
+		if len(samples) == maxSampleValues {
+			break
+		}
+	}
+	if len(samples) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(samples)
+	return string(encoded), err
+}
+
+// Status selects catalog entries by their flags.
+type Status string
+
+const (
+	StatusAll         Status = ""
+	StatusNew         Status = "new"
+	StatusDisappeared Status = "disappeared"
+	StatusActive      Status = "active"
+)
+
+// Filter narrows a catalog listing.
+type Filter struct {
+	Status Status
+	// Prefix matches paths equal to it or nested below it, e.g. app.content
+	Prefix string
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// List returns the catalog entries matching the filter, ordered by path.
+// A parameter counts as disappeared when it is missing from flushes for
+// GoneAfter while other parameters keep arriving; when no agent is
+// flushing, nothing is flagged.
+func (s *Store) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	now := time.Now().UTC()
+	query := `
+		WITH flagged AS (
+			SELECT parameter_path, first_seen, last_seen, presence_count, total_requests,
+			       COALESCE(sample_values, '[]') AS sample_values,
+			       first_seen >= CAST(? AS TIMESTAMP) AS new_this_week,
+			       last_seen < (SELECT MAX(last_seen) FROM parameter_catalog) - CAST(? AS INTERVAL) AS disappeared
+			FROM parameter_catalog
+		)
+		SELECT parameter_path, first_seen, last_seen, presence_count, total_requests,
+		       sample_values, new_this_week, disappeared
+		FROM flagged`
+	args := []interface{}{now.Add(-NewWithin), fmt.Sprintf("%d seconds", int64(GoneAfter.Seconds()))}
+
+	var conditions []string
+	switch filter.Status {
+	case StatusNew:
+		conditions = append(conditions, "new_this_week")
+	case StatusDisappeared:
+		conditions = append(conditions, "disappeared")
+	case StatusActive:
+		conditions = append(conditions, "NOT disappeared")
+	}
+	if filter.Prefix != "" {
+		// Nested paths continue with . for objects and [] for arrays
+		conditions = append(conditions, "(parameter_path = ? OR starts_with(parameter_path, ?) OR starts_with(parameter_path, ?))")
+		args = append(args, filter.Prefix, filter.Prefix+".", filter.Prefix+"[]")
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY parameter_path"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var samples string
+		if err := rows.Scan(&e.Path, &e.FirstSeen, &e.LastSeen, &e.PresenceCount, &e.TotalRequests,
+			&samples, &e.NewThisWeek, &e.Disappeared); err != nil {
+			return nil, fmt.Errorf("failed to scan catalog entry: %w", err)
+		}
+		e.SampleValues = json.RawMessage(samples)
+		if e.TotalRequests > 0 {
+			e.PresenceRate = math.Round(float64(e.PresenceCount)/float64(e.TotalRequests)*10000) / 100
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	return entries, nil
+}
+
+// ParseStatus reads a status query parameter.
+func ParseStatus(value string) (Status, bool) {
+	switch status := Status(strings.ToLower(value)); status {
+	case StatusAll, StatusNew, StatusDisappeared, StatusActive:
+		return status, true
+	}
+	return "", false
+}
@@ -0,0 +1,247 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"openrtb-insights/internal/database"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Connect("", database.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// inTx runs fn in a committed transaction, as the ingester calls the
+// catalog.
+func inTx(t *testing.T, db *sql.DB, fn func(*sql.Tx) error) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func record(t *testing.T, db *sql.DB, observations ...Observation) {
+	t.Helper()
+	inTx(t, db, func(tx *sql.Tx) error {
+		return Record(context.Background(), tx, observations)
+	})
+}
+
+func list(t *testing.T, store *Store, filter Filter) map[string]Entry {
+	t.Helper()
+	entries, err := store.List(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		byPath[e.Path] = e
+	}
+	return byPath
+}
+
+func TestRecordAccumulates(t *testing.T) {
+	db := newTestDB(t)
+	store := NewStore(db)
+	start := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+
+	record(t, db, Observation{
+		Path: "app.content.genre", FirstSeen: start.Add(10 * time.Minute), LastSeen: start.Add(20 * time.Minute),
+		PresenceCount: 30, TotalRequests: 100, SampleValues: []interface{}{"Drama"},
+	})
+	// An earlier flush arriving late widens the seen times backwards
+	record(t, db, Observation{
+		Path: "app.content.genre", FirstSeen: start, LastSeen: start.Add(5 * time.Minute),
+		PresenceCount: 20, TotalRequests: 100,
+	})
+
+	entry := list(t, store, Filter{})["app.content.genre"]
+	if !entry.FirstSeen.Equal(start) || !entry.LastSeen.Equal(start.Add(20*time.Minute)) {
+		t.Errorf("seen %s to %s, want %s to %s", entry.FirstSeen, entry.LastSeen, start, start.Add(20*time.Minute))
+	}
+	if entry.PresenceCount != 50 || entry.TotalRequests != 200 || entry.PresenceRate != 25 {
+		t.Errorf("got %d of %d at %.2f%%, want 50 of 200 at 25%%", entry.PresenceCount, entry.TotalRequests, entry.PresenceRate)
+	}
+	// A flush without samples keeps the earlier ones
+	if string(entry.SampleValues) != `["Drama"]` {
+		t.Errorf("got samples %s", entry.SampleValues)
+	}
+
+	record(t, db, Observation{
+		Path: "app.content.genre", FirstSeen: start, LastSeen: start,
+		PresenceCount: 0, TotalRequests: 100, SampleValues: []interface{}{"Comedy"},
+	})
+	if entry := list(t, store, Filter{})["app.content.genre"]; string(entry.SampleValues) != `["Comedy"]` {
+		t.Errorf("got samples %s, want the latest flush's", entry.SampleValues)
+	}
+}
+
+func TestForget(t *testing.T) {
+	db := newTestDB(t)
+	windowStart := time.Now().UTC().Truncate(time.Hour)
+	observation := Observation{
+		Path: "device.ifa", FirstSeen: windowStart, LastSeen: windowStart.Add(time.Minute),
+		PresenceCount: 40, TotalRequests: 100,
+	}
+	record(t, db, observation)
+	record(t, db, observation)
+	if _, err := db.Exec(`INSERT INTO parameter_presence VALUES ('edge-1', ?, 'device.ifa', 40, 100)`, windowStart); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the replaced flush is taken out, the other one's counts stay
+	inTx(t, db, func(tx *sql.Tx) error {
+		return Forget(context.Background(), tx, "edge-1", windowStart)
+	})
+	entry := list(t, NewStore(db), Filter{})["device.ifa"]
+	if entry.PresenceCount != 40 || entry.TotalRequests != 100 {
+		t.Errorf("got %d of %d, want 40 of 100", entry.PresenceCount, entry.TotalRequests)
+	}
+
+	// Another agent's window is untouched
+	inTx(t, db, func(tx *sql.Tx) error {
+		return Forget(context.Background(), tx, "edge-2", windowStart)
+	})
+	if entry := list(t, NewStore(db), Filter{})["device.ifa"]; entry.PresenceCount != 40 {
+		t.Errorf("got %d after forgetting another agent's flush, want 40", entry.PresenceCount)
+	}
+}
+
+func TestEncodeSamples(t *testing.T) {
+	long := make([]byte, maxSampleLength)
+	for i := range long {
+		long[i] = 'a'
+	}
+
+	tests := []struct {
+		name   string
+		values []interface{}
+		want   interface{}
+	}{
+		{"none", nil, nil},
+		{"only long values", []interface{}{string(long)}, nil},
+		{"mixed types", []interface{}{"IAB1", 3, true}, `["IAB1",3,true]`},
+		{"duplicates", []interface{}{"IAB1", "IAB1", 3, 3.0}, `["IAB1",3]`},
+		{"long values skipped", []interface{}{string(long), "IAB1"}, `["IAB1"]`},
+		{"objects", []interface{}{map[string]interface{}{"id": "1"}}, `[{"id":"1"}]`},
+		{"at most five", []interface{}{1, 2, 3, 4, 5, 6}, `[1,2,3,4,5]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := encodeSamples(test.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestListFlags(t *testing.T) {
+	db := newTestDB(t)
+	store := NewStore(db)
+	now := time.Now().UTC()
+	longAgo := now.AddDate(0, -1, 0)
+
+	seen := func(path string, first, last time.Time) Observation {
+		return Observation{Path: path, FirstSeen: first, LastSeen: last, PresenceCount: 1, TotalRequests: 2}
+	}
+	record(t, db,
+		seen("app.content", longAgo, now),
+		seen("app.content.genre", now.Add(-time.Hour), now),
+		seen("app.content.data[].segment[].id", longAgo, now),
+		seen("app.contentrating", longAgo, now),
+		seen("device.ifa", longAgo, now.Add(-GoneAfter-time.Hour)),
+	)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		paths  []string
+	}{
+		{"all", Filter{}, []string{"app.content", "app.content.data[].segment[].id", "app.content.genre", "app.contentrating", "device.ifa"}},
+		{"new", Filter{Status: StatusNew}, []string{"app.content.genre"}},
+		{"disappeared", Filter{Status: StatusDisappeared}, []string{"device.ifa"}},
+		{"active", Filter{Status: StatusActive}, []string{"app.content", "app.content.data[].segment[].id", "app.content.genre", "app.contentrating"}},
+		{"prefix", Filter{Prefix: "app.content"}, []string{"app.content", "app.content.data[].segment[].id", "app.content.genre"}},
+		{"array prefix", Filter{Prefix: "app.content.data"}, []string{"app.content.data[].segment[].id"}},
+		{"prefix and status", Filter{Status: StatusNew, Prefix: "device"}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := store.List(context.Background(), test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths := make([]string, len(entries))
+			for i, e := range entries {
+				paths[i] = e.Path
+			}
+			if len(paths) != len(test.paths) {
+				t.Fatalf("got %v, want %v", paths, test.paths)
+			}
+			for i := range paths {
+				if paths[i] != test.paths[i] {
+					t.Errorf("got %v, want %v", paths, test.paths)
+					break
+				}
+			}
+		})
+	}
+
+	entries := list(t, store, Filter{})
+	if e := entries["app.content.genre"]; !e.NewThisWeek || e.Disappeared {
+		t.Errorf("app.content.genre flagged new %t, disappeared %t", e.NewThisWeek, e.Disappeared)
+	}
+	if e := entries["device.ifa"]; e.NewThisWeek || !e.Disappeared {
+		t.Errorf("device.ifa flagged new %t, disappeared %t", e.NewThisWeek, e.Disappeared)
+	}
+	if e := entries["device.ifa"]; string(e.SampleValues) != "[]" {
+		t.Errorf("got samples %s without any recorded, want []", e.SampleValues)
+	}
+}
+
+func TestListFlagsNothingGoneWhenAgentsStop(t *testing.T) {
+	db := newTestDB(t)
+	// Every parameter was last seen days ago, as when no agent is flushing
+	stopped := time.Now().UTC().AddDate(0, 0, -3)
+	record(t, db,
+		Observation{Path: "app.id", FirstSeen: stopped.AddDate(0, -1, 0), LastSeen: stopped, PresenceCount: 1, TotalRequests: 1},
+		Observation{Path: "device.ifa", FirstSeen: stopped.AddDate(0, -1, 0), LastSeen: stopped, PresenceCount: 1, TotalRequests: 1},
+	)
+	if entries := list(t, NewStore(db), Filter{Status: StatusDisappeared}); len(entries) != 0 {
+		t.Errorf("got disappeared %v while no agent is flushing", entries)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	for value, want := range map[string]Status{"": StatusAll, "new": StatusNew, "Disappeared": StatusDisappeared, "ACTIVE": StatusActive} {
+		if got, ok := ParseStatus(value); !ok || got != want {
+			t.Errorf("got %q, %t for %q, want %q", got, ok, value, want)
+		}
+	}
+	if _, ok := ParseStatus("gone"); ok {
+		t.Errorf("accepted gone")
+	}
+}
@@ -0,0 +1,60 @@
+package catalog
+
+import (
+	"net/http"
+
+	"openrtb-insights/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	store *Store
+}
+
+func NewHandler(store *Store) *Handler {
+	return &Handler{store: store}
+}
+
+// ListParameters returns the parameter catalog, optionally narrowed to new
+// or disappeared parameters or to the paths below a prefix.
+func (h *Handler) ListParameters(c *gin.Context) {
+	status, ok := ParseStatus(c.Query("status"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "status must be one of: new, disappeared, active",
+		})
+		return
+	}
+	filter := Filter{Status: status, Prefix: c.Query("prefix")}
+
+	entries, err := h.store.List(c.Request.Context(), filter)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to list parameter catalog", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve parameter catalog",
+		})
+		return
+	}
+
+	var newCount, disappeared int
+	for _, e := range entries {
+		if e.NewThisWeek {
+			newCount++
+		}
+		if e.Disappeared {
+			disappeared++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":        entries,
+		"count":       len(entries),
+		"new":         newCount,
+		"disappeared": disappeared,
+		"query": gin.H{
+			"status": filter.Status,
+			"prefix": filter.Prefix,
+		},
+	})
+}
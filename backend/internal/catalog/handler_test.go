@@ -0,0 +1,68 @@
+package catalog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestListParameters(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().UTC()
+	record(t, db,
+		Observation{Path: "app.content.genre", FirstSeen: now.Add(-time.Hour), LastSeen: now, PresenceCount: 1, TotalRequests: 4},
+		Observation{Path: "device.ifa", FirstSeen: now.AddDate(0, -1, 0), LastSeen: now.AddDate(0, 0, -2), PresenceCount: 3, TotalRequests: 4},
+	)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/parameters", NewHandler(NewStore(db)).ListParameters)
+	get := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/parameters?"+query, nil))
+		return recorder
+	}
+
+	if got := get("status=gone").Code; got != http.StatusBadRequest {
+		t.Errorf("got %d for an unknown status, want %d", got, http.StatusBadRequest)
+	}
+
+	tests := []struct {
+		query       string
+		count       int
+		new         int
+		disappeared int
+	}{
+		{"", 2, 1, 1},
+		{"status=new", 1, 1, 0},
+		{"status=active", 1, 1, 0},
+		{"status=disappeared", 1, 0, 1},
+		{"prefix=app", 1, 1, 0},
+		{"prefix=site", 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			recorder := get(test.query)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", recorder.Code, recorder.Body)
+			}
+			var body struct {
+				Data        []Entry `json:"data"`
+				Count       int     `json:"count"`
+				New         int     `json:"new"`
+				Disappeared int     `json:"disappeared"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Data == nil || len(body.Data) != test.count || body.Count != test.count || body.New != test.new || body.Disappeared != test.disappeared {
+				t.Errorf("got %d entries, count %d, new %d, disappeared %d, want %d, %d, %d",
+					len(body.Data), body.Count, body.New, body.Disappeared, test.count, test.new, test.disappeared)
+			}
+		})
+	}
+}
@@ -1,51 +1,104 @@
 package config
 
 import (
-	"log"
-	"os"
-	"strconv"
 	"time"
 
-	"github.com/joho/godotenv"
+	"openrtb-insights/internal/database"
 )
 
+// Config holds the server settings. Each field is read from the `config`
+// key in a YAML or TOML config file and can be overridden by the environment
+// variable of the same name in upper case (jwt_expiry -> JWT_EXPIRY).
+// Fields tagged secret are redacted by `config print --redacted`.
 type Config struct {
-	DBPath               string
-	JWTSecret           string
-	JWTExpiry           time.Duration
-	RefreshTokenExpiry  time.Duration
-	Port                string
-	CORSOrigins         string
-	LogLevel            string
-	RateLimit           int
+	Environment            string        `config:"environment" default:"development"`
+	DBPath                 string        `config:"db_path" default:"./analytics.db"`
+	DBThreads              int           `config:"db_threads" default:"0"`
+	DBMemoryLimit          string        `config:"db_memory_limit"`
+	DBAccessMode           string        `config:"db_access_mode" default:"automatic"`
+	DBMaxOpenConns         int           `config:"db_max_open_conns" default:"16"`
+	DBMaxIdleConns         int           `config:"db_max_idle_conns" default:"4"`
+	DBConnMaxIdleTime      time.Duration `config:"db_conn_max_idle_time" default:"5m"`
+	JWTAlgorithm           string        `config:"jwt_algorithm" default:"HS256"`
+	JWTSecret              string        `config:"jwt_secret" default:"your-jwt-secret-key" secret:"true"`
+	JWTPrivateKeyFile      string        `config:"jwt_private_key_file"`
+	JWTPreviousPublicKeys  string        `config:"jwt_previous_public_keys"`
+	JWTKeyID               string        `config:"jwt_key_id" default:"access-v1"`
+	JWTPreviousKeys        string        `config:"jwt_previous_keys" secret:"true"`
+	JWTRefreshSecret       string        `config:"jwt_refresh_secret" default:"your-jwt-refresh-secret-key" secret:"true"`
+	JWTRefreshKeyID        string        `config:"jwt_refresh_key_id" default:"refresh-v1"`
+	JWTRefreshPreviousKeys string        `config:"jwt_refresh_previous_keys" secret:"true"`
+	JWTIssuer              string        `config:"jwt_issuer" default:"openrtb-insights"`
+	JWTAudience            string        `config:"jwt_audience" default:"openrtb-insights-api"`
+	JWTExpiry              time.Duration `config:"jwt_expiry" default:"15m"`
+	RefreshTokenExpiry     time.Duration `config:"refresh_token_expiry" default:"168h"`
+	Port                   string        `config:"port" default:"8080"`
+	CORSOrigins            []string      `config:"cors_origins" default:"http://localhost:3000"`
+	LogLevel               string        `config:"log_level" default:"info"`
+	LogFormat              string        `config:"log_format" default:"json"`
+	CookieSecure           bool          `config:"cookie_secure" default:"false"`
+	CookieSameSite         string        `config:"cookie_samesite" default:"lax"`
+	CookieDomain           string        `config:"cookie_domain"`
+	ContentSecurityPolicy  string        `config:"content_security_policy"`
+	HSTSMaxAge             time.Duration `config:"hsts_max_age" default:"0s"`
+	MetricsToken           string        `config:"metrics_token" secret:"true"`
+	ReportQueryTimeout     time.Duration `config:"report_query_timeout" default:"5s"`
+	ReportQueryTimeouts    []string      `config:"report_query_timeouts"`
+	ReportCacheTTL         time.Duration `config:"report_cache_ttl" default:"1m"`
+	ReportCacheMaxEntries  int           `config:"report_cache_max_entries" default:"1000"`
+	RollupRefreshInterval  time.Duration `config:"rollup_refresh_interval" default:"5m"`
+	Retention              []string      `config:"retention" default:"platform_stats:730d,content_health:730d,video_health:730d,platform_stats_hourly:90d,content_health_hourly:90d,video_health_hourly:90d,agent_flushes:90d,parameter_presence:90d,dimension_presence:90d"`
+	RetentionInterval      time.Duration `config:"retention_interval" default:"24h"`
+	BackupDir              string        `config:"backup_dir" default:"./backups"`
+	BackupInterval         time.Duration `config:"backup_interval" default:"0s"`
+	BackupKeep             int           `config:"backup_keep" default:"7"`
+	BackupMaxAge           time.Duration `config:"backup_max_age" default:"720h"`
+	TracingEnabled         bool          `config:"tracing_enabled" default:"false"`
+	TracingEndpoint        string        `config:"tracing_endpoint" default:"http://localhost:4318"`
+	TracingServiceName     string        `config:"tracing_service_name" default:"openrtb-insights"`
+	TracingSampleRatio     float64       `config:"tracing_sample_ratio" default:"1"`
+	HealthCheckTimeout     time.Duration `config:"health_check_timeout" default:"2s"`
+	HealthMaxDataAge       time.Duration `config:"health_max_data_age" default:"48h"`
+	HealthMinFreeDiskMB    int           `config:"health_min_free_disk_mb" default:"100"`
+	RateLimit              int           `config:"rate_limit" default:"100"`
+	RateLimitAuth          int           `config:"rate_limit_auth" default:"20"`
+	RateLimitReports       int           `config:"rate_limit_reports" default:"300"`
+	RateLimitIngest        int           `config:"rate_limit_ingest" default:"120"`
+	RateLimitIdleTTL       time.Duration `config:"rate_limit_idle_ttl" default:"10m"`
+	TrustedProxies         []string      `config:"trusted_proxies"`
+	LoginMaxFailures       int           `config:"login_max_failures" default:"5"`
+	LoginIPMaxFailures     int           `config:"login_ip_max_failures" default:"20"`
+	LoginFailureWindow     time.Duration `config:"login_failure_window" default:"15m"`
+	LoginLockoutDuration   time.Duration `config:"login_lockout_duration" default:"15m"`
+	LoginDelayBase         time.Duration `config:"login_delay_base" default:"250ms"`
+	LoginDelayMax          time.Duration `config:"login_delay_max" default:"4s"`
+	LocalLoginEnabled      bool          `config:"local_login_enabled" default:"true"`
+	OIDCIssuerURL          string        `config:"oidc_issuer_url"`
+	OIDCClientID           string        `config:"oidc_client_id"`
+	OIDCClientSecret       string        `config:"oidc_client_secret" secret:"true"`
+	OIDCRedirectURL        string        `config:"oidc_redirect_url" default:"http://localhost:8080/api/auth/oidc/callback"`
+	OIDCScopes             []string      `config:"oidc_scopes" default:"openid,profile,email"`
+	OIDCGroupsClaim        string        `config:"oidc_groups_claim" default:"groups"`
+	OIDCAdminGroups        []string      `config:"oidc_admin_groups"`
+	OIDCAnalystGroups      []string      `config:"oidc_analyst_groups"`
+	OIDCViewerGroups       []string      `config:"oidc_viewer_groups"`
+	OIDCDefaultRole        string        `config:"oidc_default_role"`
+	OIDCPostLoginRedirect  string        `config:"oidc_post_login_redirect" default:"http://localhost:3000/"`
 }
 
-func Load() *Config {
-	// Load .env file if it exists
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
-	}
-
-	rateLimit, _ := strconv.Atoi(getEnv("RATE_LIMIT", "100"))
-	
-	jwtExpiry, _ := time.ParseDuration(getEnv("JWT_EXPIRY", "15m"))
-	refreshExpiry, _ := time.ParseDuration(getEnv("REFRESH_TOKEN_EXPIRY", "168h"))
-
-	return &Config{
-		DBPath:             getEnv("DB_PATH", "./analytics.db"),
-		JWTSecret:          getEnv("JWT_SECRET", "your-jwt-secret-key"),
-		JWTExpiry:          jwtExpiry,
-		RefreshTokenExpiry: refreshExpiry,
-		Port:               getEnv("PORT", "8080"),
-		CORSOrigins:        getEnv("CORS_ORIGINS", "http://localhost:3000"),
-		LogLevel:           getEnv("LOG_LEVEL", "info"),
-		RateLimit:          rateLimit,
+// DatabaseOptions returns the DuckDB and connection pool settings.
+func (c *Config) DatabaseOptions() database.Options {
+	return database.Options{
+		Threads:         c.DBThreads,
+		MemoryLimit:     c.DBMemoryLimit,
+		AccessMode:      c.DBAccessMode,
+		MaxOpenConns:    c.DBMaxOpenConns,
+		MaxIdleConns:    c.DBMaxIdleConns,
+		ConnMaxIdleTime: c.DBConnMaxIdleTime,
 	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
\ No newline at end of file
+// IsProduction reports whether the server runs with production safeguards.
+func (c *Config) IsProduction() bool {
+	return c.Environment == "production"
+}
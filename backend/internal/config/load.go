@@ -0,0 +1,229 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is one tagged Config field.
+type field struct {
+	key    string
+	env    string
+	def    string
+	secret bool
+	value  reflect.Value
+}
+
+// Load builds the configuration from the field defaults, the config file at
+// path and the environment, in increasing order of precedence. If path is
+// empty, CONFIG_FILE is used, and without either only the environment is
+// read. All parse and validation problems are returned together; the
+// partially loaded config is returned alongside them.
+func Load(path string) (*Config, error) {
+	// Load .env file if it exists
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	cfg := &Config{}
+	var problems []error
+
+	for _, f := range fields(cfg) {
+		if f.def == "" {
+			continue
+		}
+		if err := setValue(f.value, f.def); err != nil {
+			// A broken default is a programming error
+			panic(fmt.Sprintf("config: invalid default for %s: %v", f.key, err))
+		}
+	}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		problems = append(problems, applyFile(cfg, path, values)...)
+	}
+
+	problems = append(problems, applyEnv(cfg)...)
+
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+	return cfg, errors.Join(problems...)
+}
+
+func fields(cfg *Config) []field {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	var result []field
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("config")
+		if key == "" {
+			continue
+		}
+		result = append(result, field{
+			key:    key,
+			env:    strings.ToUpper(key),
+			def:    t.Field(i).Tag.Get("default"),
+			secret: t.Field(i).Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return result
+}
+
+func readFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func applyFile(cfg *Config, path string, values map[string]interface{}) []error {
+	byKey := make(map[string]field)
+	for _, f := range fields(cfg) {
+		byKey[f.key] = f
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []error
+	for _, key := range keys {
+		raw := values[key]
+		f, ok := byKey[key]
+		if !ok {
+			problems = append(problems, fmt.Errorf("%s: unknown key %q", path, key))
+			continue
+		}
+
+		var err error
+		switch value := raw.(type) {
+		case []interface{}:
+			err = setList(f.value, value)
+		case map[string]interface{}:
+			err = errors.New("expected a value, not a section")
+		default:
+			err = setValue(f.value, fmt.Sprint(value))
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %s: %w", path, key, err))
+		}
+	}
+	return problems
+}
+
+// applyEnv overrides fields from the environment. Empty variables are
+// treated as unset.
+func applyEnv(cfg *Config) []error {
+	var problems []error
+	for _, f := range fields(cfg) {
+		raw := os.Getenv(f.env)
+		if raw == "" {
+			continue
+		}
+		if err := setValue(f.value, raw); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", f.env, err))
+		}
+	}
+	return problems
+}
+
+func setValue(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, expected e.g. 30s, 15m or 24h", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q, expected true or false", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(splitList(raw)))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+func setList(v reflect.Value, list []interface{}) error {
+	if v.Kind() != reflect.Slice {
+		return errors.New("expected a single value, not a list")
+	}
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		values = append(values, fmt.Sprint(item))
+	}
+	v.Set(reflect.ValueOf(values))
+	return nil
+}
+
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
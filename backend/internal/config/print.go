@@ -0,0 +1,57 @@
+package config
+
+import (
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Write prints the effective configuration as YAML in the config file
+// format, so the output can be used as a starting point for one. With
+// redact set, secrets are masked and the output is safe to share.
+func (c *Config) Write(w io.Writer, redact bool) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+
+	for _, f := range fields(c) {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: f.key}
+
+		var value *yaml.Node
+		switch {
+		case redact && f.secret && f.value.String() != "":
+			value = scalar("!!str", redacted)
+		case f.value.Type() == durationType:
+			value = scalar("!!str", time.Duration(f.value.Int()).String())
+		case f.value.Kind() == reflect.Int:
+			value = scalar("!!int", strconv.FormatInt(f.value.Int(), 10))
+		case f.value.Kind() == reflect.Float64:
+			value = scalar("!!float", strconv.FormatFloat(f.value.Float(), 'g', -1, 64))
+		case f.value.Kind() == reflect.Bool:
+			value = scalar("!!bool", strconv.FormatBool(f.value.Bool()))
+		case f.value.Kind() == reflect.Slice:
+			value = &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+			for i := 0; i < f.value.Len(); i++ {
+				value.Content = append(value.Content, scalar("!!str", f.value.Index(i).String()))
+			}
+		default:
+			value = scalar("!!str", f.value.String())
+		}
+
+		doc.Content = append(doc.Content, key, value)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func scalar(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
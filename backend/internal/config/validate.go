@@ -0,0 +1,229 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"openrtb-insights/internal/database"
+	"openrtb-insights/internal/reports"
+)
+
+// minSecretLength is the shortest HMAC secret accepted in production,
+// matching the 256 bits of HS256.
+const minSecretLength = 32
+
+// Validate checks the configuration as a whole and reports every problem
+// at once rather than stopping at the first.
+func (c *Config) Validate() error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if !oneOf(c.Environment, "development", "staging", "production") {
+		problem("ENVIRONMENT must be development, staging or production, got %q", c.Environment)
+	}
+	if !oneOf(c.LogLevel, "debug", "info", "warn", "error") {
+		problem("LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	if !oneOf(c.LogFormat, "json", "text") {
+		problem("LOG_FORMAT must be json or text, got %q", c.LogFormat)
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problem("PORT must be a port number, got %q", c.Port)
+	}
+	if c.DBPath == "" {
+		problem("DB_PATH is required")
+	}
+	switch c.DBAccessMode {
+	case database.AccessAutomatic, database.AccessReadWrite, database.AccessReadOnly:
+	default:
+		problem("DB_ACCESS_MODE must be automatic, read_write or read_only, got %q", c.DBAccessMode)
+	}
+	if c.DBThreads < 0 || c.DBMaxOpenConns < 0 || c.DBMaxIdleConns < 0 || c.DBConnMaxIdleTime < 0 {
+		problem("DB_THREADS, DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and DB_CONN_MAX_IDLE_TIME must not be negative")
+	}
+
+	// Token signing
+	switch c.JWTAlgorithm {
+	case "HS256":
+		if c.JWTSecret == "" {
+			problem("JWT_SECRET is required with JWT_ALGORITHM=HS256")
+		}
+	case "RS256", "EdDSA":
+		if c.JWTPrivateKeyFile == "" {
+			problem("JWT_PRIVATE_KEY_FILE is required with JWT_ALGORITHM=%s", c.JWTAlgorithm)
+		}
+	default:
+		problem("JWT_ALGORITHM must be HS256, RS256 or EdDSA, got %q", c.JWTAlgorithm)
+	}
+	if c.JWTRefreshSecret == "" {
+		problem("JWT_REFRESH_SECRET is required")
+	} else if c.JWTAlgorithm == "HS256" && c.JWTRefreshSecret == c.JWTSecret {
+		problem("JWT_SECRET and JWT_REFRESH_SECRET must differ")
+	}
+	if c.JWTKeyID == "" || c.JWTRefreshKeyID == "" {
+		problem("JWT_KEY_ID and JWT_REFRESH_KEY_ID are required")
+	} else if c.JWTKeyID == c.JWTRefreshKeyID {
+		problem("JWT_KEY_ID and JWT_REFRESH_KEY_ID must differ")
+	}
+	if c.JWTIssuer == "" || c.JWTAudience == "" {
+		problem("JWT_ISSUER and JWT_AUDIENCE are required")
+	}
+	if c.JWTExpiry <= 0 {
+		problem("JWT_EXPIRY must be positive")
+	}
+	if c.RefreshTokenExpiry <= c.JWTExpiry {
+		problem("REFRESH_TOKEN_EXPIRY must be longer than JWT_EXPIRY")
+	}
+
+	// Browser security
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			problem("CORS_ORIGINS must list origins explicitly, * is not allowed with credentials")
+		}
+	}
+	switch strings.ToLower(c.CookieSameSite) {
+	case "lax", "strict":
+	case "none":
+		if !c.CookieSecure {
+			problem("COOKIE_SAMESITE=none requires COOKIE_SECURE=true")
+		}
+	default:
+		problem("COOKIE_SAMESITE must be lax, strict or none, got %q", c.CookieSameSite)
+	}
+	if c.HSTSMaxAge < 0 {
+		problem("HSTS_MAX_AGE must not be negative")
+	}
+
+	// Reports; the server's 10s write timeout cuts off anything longer
+	if c.ReportQueryTimeout <= 0 || c.ReportQueryTimeout > reports.MaxTimeout {
+		problem("REPORT_QUERY_TIMEOUT must be between 0 and %s, got %s", reports.MaxTimeout, c.ReportQueryTimeout)
+	}
+	if _, err := reports.ParseTimeouts(c.ReportQueryTimeout, c.ReportQueryTimeouts); err != nil {
+		problem("REPORT_QUERY_TIMEOUTS: %w", err)
+	}
+
+	if c.ReportCacheTTL < 0 || c.ReportCacheMaxEntries < 0 {
+		problem("REPORT_CACHE_TTL and REPORT_CACHE_MAX_ENTRIES must not be negative")
+	}
+
+	if c.RollupRefreshInterval <= 0 {
+		problem("ROLLUP_REFRESH_INTERVAL must be positive")
+	}
+	if c.RetentionInterval < 0 {
+		problem("RETENTION_INTERVAL must not be negative")
+	}
+	if c.BackupDir == "" {
+		problem("BACKUP_DIR is required")
+	}
+	if c.BackupInterval < 0 || c.BackupKeep < 0 || c.BackupMaxAge < 0 {
+		problem("BACKUP_INTERVAL, BACKUP_KEEP and BACKUP_MAX_AGE must not be negative")
+	}
+
+	// Observability
+	if c.TracingEnabled {
+		if !isHTTPURL(c.TracingEndpoint) {
+			problem("TRACING_ENDPOINT must be an http(s) URL, got %q", c.TracingEndpoint)
+		}
+		if c.TracingServiceName == "" {
+			problem("TRACING_SERVICE_NAME is required when TRACING_ENABLED is set")
+		}
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		problem("TRACING_SAMPLE_RATIO must be between 0 and 1, got %g", c.TracingSampleRatio)
+	}
+
+	// Health checks
+	if c.HealthCheckTimeout <= 0 {
+		problem("HEALTH_CHECK_TIMEOUT must be positive")
+	}
+	if c.HealthMaxDataAge < 0 || c.HealthMinFreeDiskMB < 0 {
+		problem("HEALTH_MAX_DATA_AGE and HEALTH_MIN_FREE_DISK_MB must not be negative")
+	}
+
+	// Throttling
+	if c.RateLimit < 0 || c.RateLimitAuth < 0 || c.RateLimitReports < 0 || c.RateLimitIngest < 0 {
+		problem("RATE_LIMIT, RATE_LIMIT_AUTH, RATE_LIMIT_REPORTS and RATE_LIMIT_INGEST must not be negative")
+	}
+	if c.RateLimitIdleTTL <= 0 {
+		problem("RATE_LIMIT_IDLE_TTL must be positive")
+	}
+	if c.LoginMaxFailures < 0 || c.LoginIPMaxFailures < 0 {
+		problem("LOGIN_MAX_FAILURES and LOGIN_IP_MAX_FAILURES must not be negative")
+	}
+	if (c.LoginMaxFailures > 0 || c.LoginIPMaxFailures > 0) && c.LoginFailureWindow <= 0 {
+		problem("LOGIN_FAILURE_WINDOW must be positive when login failure limits are set")
+	}
+	if c.LoginMaxFailures > 0 && c.LoginLockoutDuration <= 0 {
+		problem("LOGIN_LOCKOUT_DURATION must be positive when LOGIN_MAX_FAILURES is set")
+	}
+	if c.LoginDelayBase < 0 || c.LoginDelayMax < c.LoginDelayBase {
+		problem("LOGIN_DELAY_BASE must not be negative and LOGIN_DELAY_MAX must be at least LOGIN_DELAY_BASE")
+	}
+
+	// Single sign-on
+	if c.OIDCIssuerURL != "" {
+		if !isHTTPURL(c.OIDCIssuerURL) {
+			problem("OIDC_ISSUER_URL must be an http(s) URL, got %q", c.OIDCIssuerURL)
+		}
+		if c.OIDCClientID == "" {
+			problem("OIDC_CLIENT_ID is required when OIDC_ISSUER_URL is set")
+		}
+		if !isHTTPURL(c.OIDCRedirectURL) {
+			problem("OIDC_REDIRECT_URL must be an http(s) URL, got %q", c.OIDCRedirectURL)
+		}
+	}
+	if !oneOf(c.OIDCDefaultRole, "", "Viewer", "Analyst", "Admin") {
+		problem("OIDC_DEFAULT_ROLE must be empty, Viewer, Analyst or Admin, got %q", c.OIDCDefaultRole)
+	}
+	if !c.LocalLoginEnabled && c.OIDCIssuerURL == "" {
+		problem("LOCAL_LOGIN_ENABLED=false requires OIDC_ISSUER_URL, otherwise nobody can log in")
+	}
+
+	if c.IsProduction() {
+		problems = append(problems, c.validateProduction()...)
+	}
+
+	return errors.Join(problems...)
+}
+
+// validateProduction refuses settings that are only acceptable for local
+// development, above all the well-known default secrets.
+func (c *Config) validateProduction() []error {
+	var problems []error
+	secrets := map[string]string{"JWT_REFRESH_SECRET": c.JWTRefreshSecret}
+	if c.JWTAlgorithm == "HS256" {
+		secrets["JWT_SECRET"] = c.JWTSecret
+	}
+
+	for _, f := range fields(c) {
+		value, checked := secrets[f.env]
+		if !checked {
+			continue
+		}
+		if value == f.def {
+			problems = append(problems, fmt.Errorf("%s must be changed from its default in production", f.env))
+		} else if len(value) < minSecretLength {
+			problems = append(problems, fmt.Errorf("%s must be at least %d characters in production", f.env, minSecretLength))
+		}
+	}
+	return problems
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// defaults returns the config with every field at its default, as Load
+// starts from.
+func defaults(t *testing.T) *Config {
+	t.Helper()
+	cfg := &Config{}
+	for _, f := range fields(cfg) {
+		if f.def == "" {
+			continue
+		}
+		if err := setValue(f.value, f.def); err != nil {
+			t.Fatalf("invalid default for %s: %v", f.key, err)
+		}
+	}
+	return cfg
+}
+
+// problems splits the error Validate returns into its messages.
+func problems(err error) []string {
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []string{err.Error()}
+	}
+	var messages []string
+	for _, problem := range joined.Unwrap() {
+		messages = append(messages, problem.Error())
+	}
+	return messages
+}
+
+func TestValidateDefaults(t *testing.T) {
+	if err := defaults(t).Validate(); err != nil {
+		t.Errorf("defaults rejected: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*Config)
+		want string
+	}{
+		{"environment", func(c *Config) { c.Environment = "prod" }, `ENVIRONMENT must be development, staging or production, got "prod"`},
+		{"log level", func(c *Config) { c.LogLevel = "trace" }, `LOG_LEVEL must be debug, info, warn or error, got "trace"`},
+		{"log format", func(c *Config) { c.LogFormat = "xml" }, `LOG_FORMAT must be json or text, got "xml"`},
+		{"port", func(c *Config) { c.Port = "70000" }, `PORT must be a port number, got "70000"`},
+		{"database path", func(c *Config) { c.DBPath = "" }, "DB_PATH is required"},
+		{"database access mode", func(c *Config) { c.DBAccessMode = "write" }, `DB_ACCESS_MODE must be automatic, read_write or read_only, got "write"`},
+		{"database pool", func(c *Config) { c.DBMaxOpenConns = -1 }, "DB_THREADS, DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and DB_CONN_MAX_IDLE_TIME must not be negative"},
+		{"JWT secret", func(c *Config) { c.JWTSecret = "" }, "JWT_SECRET is required with JWT_ALGORITHM=HS256"},
+		{"JWT private key", func(c *Config) { c.JWTAlgorithm = "RS256" }, "JWT_PRIVATE_KEY_FILE is required with JWT_ALGORITHM=RS256"},
+		{"JWT algorithm", func(c *Config) { c.JWTAlgorithm = "none" }, `JWT_ALGORITHM must be HS256, RS256 or EdDSA, got "none"`},
+		{"refresh secret", func(c *Config) { c.JWTRefreshSecret = "" }, "JWT_REFRESH_SECRET is required"},
+		{"shared secret", func(c *Config) { c.JWTRefreshSecret = c.JWTSecret }, "JWT_SECRET and JWT_REFRESH_SECRET must differ"},
+		{"key ID", func(c *Config) { c.JWTKeyID = "" }, "JWT_KEY_ID and JWT_REFRESH_KEY_ID are required"},
+		{"shared key ID", func(c *Config) { c.JWTRefreshKeyID = c.JWTKeyID }, "JWT_KEY_ID and JWT_REFRESH_KEY_ID must differ"},
+		{"issuer", func(c *Config) { c.JWTAudience = "" }, "JWT_ISSUER and JWT_AUDIENCE are required"},
+		{"token expiry", func(c *Config) { c.JWTExpiry = 0 }, "JWT_EXPIRY must be positive"},
+		{"refresh expiry", func(c *Config) { c.RefreshTokenExpiry = c.JWTExpiry }, "REFRESH_TOKEN_EXPIRY must be longer than JWT_EXPIRY"},
+		{"CORS wildcard", func(c *Config) { c.CORSOrigins = []string{"*"} }, "CORS_ORIGINS must list origins explicitly, * is not allowed with credentials"},
+		{"SameSite none", func(c *Config) { c.CookieSameSite = "none" }, "COOKIE_SAMESITE=none requires COOKIE_SECURE=true"},
+		{"SameSite", func(c *Config) { c.CookieSameSite = "always" }, `COOKIE_SAMESITE must be lax, strict or none, got "always"`},
+		{"HSTS", func(c *Config) { c.HSTSMaxAge = -time.Hour }, "HSTS_MAX_AGE must not be negative"},
+		{"query timeout", func(c *Config) { c.ReportQueryTimeout = 11 * time.Second }, "REPORT_QUERY_TIMEOUT must be between 0 and 10s, got 11s"},
+		{"query timeout override", func(c *Config) { c.ReportQueryTimeouts = []string{"video_health:20s"} },
+			"REPORT_QUERY_TIMEOUTS: timeout 20s for video_health exceeds the server's 10s write timeout"},
+		{"report cache", func(c *Config) { c.ReportCacheTTL = -time.Second }, "REPORT_CACHE_TTL and REPORT_CACHE_MAX_ENTRIES must not be negative"},
+		{"rollup interval", func(c *Config) { c.RollupRefreshInterval = 0 }, "ROLLUP_REFRESH_INTERVAL must be positive"},
+		{"retention interval", func(c *Config) { c.RetentionInterval = -time.Hour }, "RETENTION_INTERVAL must not be negative"},
+		{"backup directory", func(c *Config) { c.BackupDir = "" }, "BACKUP_DIR is required"},
+		{"backups", func(c *Config) { c.BackupKeep = -1 }, "BACKUP_INTERVAL, BACKUP_KEEP and BACKUP_MAX_AGE must not be negative"},
+		{"tracing endpoint", func(c *Config) { c.TracingEnabled, c.TracingEndpoint = true, "collector:4318" },
+			`TRACING_ENDPOINT must be an http(s) URL, got "collector:4318"`},
+		{"tracing service", func(c *Config) { c.TracingEnabled, c.TracingServiceName = true, "" }, "TRACING_SERVICE_NAME is required when TRACING_ENABLED is set"},
+		{"sample ratio", func(c *Config) { c.TracingSampleRatio = 1.5 }, "TRACING_SAMPLE_RATIO must be between 0 and 1, got 1.5"},
+		{"health timeout", func(c *Config) { c.HealthCheckTimeout = 0 }, "HEALTH_CHECK_TIMEOUT must be positive"},
+		{"health thresholds", func(c *Config) { c.HealthMinFreeDiskMB = -1 }, "HEALTH_MAX_DATA_AGE and HEALTH_MIN_FREE_DISK_MB must not be negative"},
+		{"rate limit", func(c *Config) { c.RateLimitIngest = -1 }, "RATE_LIMIT, RATE_LIMIT_AUTH, RATE_LIMIT_REPORTS and RATE_LIMIT_INGEST must not be negative"},
+		{"rate limit TTL", func(c *Config) { c.RateLimitIdleTTL = 0 }, "RATE_LIMIT_IDLE_TTL must be positive"},
+		{"login failures", func(c *Config) { c.LoginIPMaxFailures = -1 }, "LOGIN_MAX_FAILURES and LOGIN_IP_MAX_FAILURES must not be negative"},
+		{"login window", func(c *Config) { c.LoginFailureWindow = 0 }, "LOGIN_FAILURE_WINDOW must be positive when login failure limits are set"},
+		{"lockout duration", func(c *Config) { c.LoginLockoutDuration = 0 }, "LOGIN_LOCKOUT_DURATION must be positive when LOGIN_MAX_FAILURES is set"},
+		{"login delay", func(c *Config) { c.LoginDelayMax = time.Millisecond }, "LOGIN_DELAY_BASE must not be negative and LOGIN_DELAY_MAX must be at least LOGIN_DELAY_BASE"},
+		{"OIDC issuer", func(c *Config) { c.OIDCIssuerURL, c.OIDCClientID = "issuer.example.com", "insights" },
+			`OIDC_ISSUER_URL must be an http(s) URL, got "issuer.example.com"`},
+		{"OIDC client", func(c *Config) { c.OIDCIssuerURL = "https://issuer.example.com" }, "OIDC_CLIENT_ID is required when OIDC_ISSUER_URL is set"},
+		{"OIDC redirect", func(c *Config) {
+			c.OIDCIssuerURL, c.OIDCClientID, c.OIDCRedirectURL = "https://issuer.example.com", "insights", "/callback"
+		}, `OIDC_REDIRECT_URL must be an http(s) URL, got "/callback"`},
+		{"OIDC role", func(c *Config) { c.OIDCDefaultRole = "Owner" }, `OIDC_DEFAULT_ROLE must be empty, Viewer, Analyst or Admin, got "Owner"`},
+		{"no login", func(c *Config) { c.LocalLoginEnabled = false }, "LOCAL_LOGIN_ENABLED=false requires OIDC_ISSUER_URL, otherwise nobody can log in"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaults(t)
+			test.edit(cfg)
+			got := problems(cfg.Validate())
+			if len(got) != 1 || got[0] != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := defaults(t)
+	cfg.Port = "http"
+	cfg.JWTExpiry = 0
+	cfg.RateLimit = -1
+
+	got := problems(cfg.Validate())
+	want := []string{
+		`PORT must be a port number, got "http"`,
+		"JWT_EXPIRY must be positive",
+		"RATE_LIMIT, RATE_LIMIT_AUTH, RATE_LIMIT_REPORTS and RATE_LIMIT_INGEST must not be negative",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got problems %q, want %q", got, want)
+	}
+}
+
+func TestValidateProduction(t *testing.T) {
+	cfg := defaults(t)
+	cfg.Environment = "production"
+	got := strings.Join(problems(cfg.Validate()), "\n")
+	for _, want := range []string{
+		"JWT_SECRET must be changed from its default in production",
+		"JWT_REFRESH_SECRET must be changed from its default in production",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got problems %q, want %q among them", got, want)
+		}
+	}
+
+	cfg.JWTSecret = "short"
+	cfg.JWTRefreshSecret = strings.Repeat("r", minSecretLength)
+	if got := problems(cfg.Validate()); len(got) != 1 || got[0] != "JWT_SECRET must be at least 32 characters in production" {
+		t.Errorf("got %q", got)
+	}
+
+	cfg.JWTSecret = strings.Repeat("a", minSecretLength)
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("production config with strong secrets rejected: %v", err)
+	}
+}
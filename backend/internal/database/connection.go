@@ -2,27 +2,146 @@ package database
 
 import (
 	"database/sql"
-	"log"
+	"database/sql/driver"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
 
-	_ "github.com/marcboeker/go-duckdb"
+	"github.com/marcboeker/go-duckdb"
 )
 
-func Connect(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("duckdb", dbPath)
+// Access modes accepted by DuckDB's access_mode option.
+const (
+	AccessAutomatic = "automatic"
+	AccessReadWrite = "read_write"
+	AccessReadOnly  = "read_only"
+)
+
+// Options configures the DuckDB instance and the connection pools on it.
+// Zero values keep DuckDB's and database/sql's defaults.
+type Options struct {
+	// Threads caps the worker threads used by each query
+	Threads int
+	// MemoryLimit caps DuckDB's memory, e.g. "2GB"
+	MemoryLimit string
+	// AccessMode read_only takes a shared file lock, so several processes
+	// can read one file; every write fails
+	AccessMode string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+}
+
+// Pools are connection pools on one DuckDB instance. DuckDB allows a single
+// instance per file, so the pools share it rather than opening the file
+// again.
+type Pools struct {
+	// Primary is the general read-write pool
+	Primary *sql.DB
+	// Reports only runs SELECT and EXPLAIN statements
+	Reports *sql.DB
+	// Writes has a single connection, serializing bulk writers such as the
+	// rollup refresh, retention and ingestion so their transactions never
+	// conflict with each other
+	Writes *sql.DB
+}
+
+// Connect opens a single pool on the database at dbPath.
+func Connect(dbPath string, opts Options) (*sql.DB, error) {
+	connector, err := newConnector(dbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return openPool(connector, opts)
+}
+
+// Open opens the database at dbPath with a pool per use.
+func Open(dbPath string, opts Options) (*Pools, error) {
+	connector, err := newConnector(dbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Primary owns the connector and closes the instance; the other pools
+	// borrow it
+	primary, err := openPool(connector, opts)
+	if err != nil {
+		return nil, err
+	}
+	reports, err := openPool(readOnlyConnector{connector}, opts)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+	writes, err := openPool(borrowedConnector{connector}, Options{MaxOpenConns: 1, MaxIdleConns: 1})
 	if err != nil {
+		reports.Close()
+		primary.Close()
 		return nil, err
 	}
+	return &Pools{Primary: primary, Reports: reports, Writes: writes}, nil
+}
 
-	if err = db.Ping(); err != nil {
+// Close closes the pools, the borrowing ones first.
+func (p *Pools) Close() {
+	Close(p.Reports)
+	Close(p.Writes)
+	Close(p.Primary)
+}
+
+func newConnector(dbPath string, opts Options) (*duckdb.Connector, error) {
+	connector, err := duckdb.NewConnector(dsn(dbPath, opts), nil)
+	if err != nil {
 		return nil, err
 	}
+	slog.Info("Connected to DuckDB", "path", dbPath, "threads", opts.Threads,
+		"memory_limit", opts.MemoryLimit, "access_mode", opts.AccessMode)
+	return connector, nil
+}
 
-	log.Printf("Connected to DuckDB at %s", dbPath)
+func openPool(connector driver.Connector, opts Options) (*sql.DB, error) {
+	db := sql.OpenDB(connector)
+	configurePool(db, opts)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return db, nil
 }
 
+func configurePool(db *sql.DB, opts Options) {
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	}
+}
+
+// dsn adds the DuckDB options to the path as query parameters.
+func dsn(dbPath string, opts Options) string {
+	params := url.Values{}
+	if opts.Threads > 0 {
+		params.Set("threads", strconv.Itoa(opts.Threads))
+	}
+	if opts.MemoryLimit != "" {
+		params.Set("memory_limit", opts.MemoryLimit)
+	}
+	if opts.AccessMode != "" {
+		params.Set("access_mode", opts.AccessMode)
+	}
+	if len(params) == 0 {
+		return dbPath
+	}
+	return dbPath + "?" + params.Encode()
+}
+
 func Close(db *sql.DB) {
 	if err := db.Close(); err != nil {
-		log.Printf("Error closing database: %v", err)
+		slog.Error("Error closing database", "error", err)
 	}
-}
\ No newline at end of file
+}
@@ -1,124 +1,482 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"fmt"
+	"log/slog"
+	"time"
 )
 
+// Migration is one versioned schema change. Migrations run in order, each
+// in its own transaction, and the applied version is recorded in
+// schema_migrations so it never runs twice. Never edit or reorder a released
+// migration; append a new one instead.
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+// The statements of the early migrations are idempotent because databases
+// created before schema_migrations existed replay them once.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "report tables and users",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY,
+				username VARCHAR(50) UNIQUE NOT NULL,
+				password_hash VARCHAR(255) NOT NULL,
+				role VARCHAR(20) NOT NULL CHECK (role IN ('Viewer', 'Analyst', 'Admin')),
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS platform_stats (
+				date DATE NOT NULL,
+				total_requests BIGINT,
+				multi_impression BIGINT,
+				big_guidance BIGINT,
+				addressable BIGINT,
+				compliance_strings BIGINT,
+				deals BIGINT,
+				tmax BIGINT,
+				invalid_requests BIGINT,
+				timeout_rate DECIMAL(5,2),
+				bid_rate DECIMAL(5,2),
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (date)
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS content_health (
+				date DATE NOT NULL,
+				platform VARCHAR(20) NOT NULL,
+				total_requests BIGINT,
+				album BIGINT,
+				artist BIGINT,
+				cat BIGINT,
+				context BIGINT,
+				data BIGINT,
+				embeddable BIGINT,
+				episode BIGINT,
+				genre BIGINT,
+				id BIGINT,
+				kwarray BIGINT,
+				keywords BIGINT,
+				length BIGINT,
+				language BIGINT,
+				livestream BIGINT,
+				season BIGINT,
+				series BIGINT,
+				title BIGINT,
+				url BIGINT,
+				videoquality BIGINT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (date, platform)
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS video_health (
+				date DATE NOT NULL,
+				platform VARCHAR(20) NOT NULL,
+				percent_ctv DECIMAL(5,2),
+				api BIGINT,
+				boxing_allowed BIGINT,
+				delivery BIGINT,
+				h BIGINT,
+				linearity BIGINT,
+				max_bitrate BIGINT,
+				max_duration BIGINT,
+				mimes BIGINT,
+				min_bitrate BIGINT,
+				min_cpm_per_sec BIGINT,
+				min_duration BIGINT,
+				placement BIGINT,
+				play_backend BIGINT,
+				pod_dur BIGINT,
+				pod_id BIGINT,
+				pos BIGINT,
+				protocols BIGINT,
+				rqd_durs BIGINT,
+				skip BIGINT,
+				skip_after BIGINT,
+				skip_min BIGINT,
+				slot_in_pod BIGINT,
+				start_delay BIGINT,
+				w BIGINT,
+				max_seq BIGINT,
+				companion_ad BIGINT,
+				companion_type BIGINT,
+				protocol BIGINT,
+				placement_type BIGINT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (date, platform)
+			)`,
+
+			// Create indexes for better query performance
+			`CREATE INDEX IF NOT EXISTS idx_platform_stats_date ON platform_stats(date)`,
+			`CREATE INDEX IF NOT EXISTS idx_content_health_date_platform ON content_health(date, platform)`,
+			`CREATE INDEX IF NOT EXISTS idx_video_health_date_platform ON video_health(date, platform)`,
+
+			// Insert default admin user (password: admin123)
+			`INSERT OR IGNORE INTO users (id, username, password_hash, role) VALUES 
+			(1, 'admin', '$2a$10$ek0nw8RvUHOhqP9y48t6uusr3NUq0Zt8rLHKCn.UMVRmzyGEqZ..m', 'Admin')`,
+
+			// Insert demo users
+			`INSERT OR IGNORE INTO users (id, username, password_hash, role) VALUES 
+			(2, 'analyst', '$2a$10$ek0nw8RvUHOhqP9y48t6uusr3NUq0Zt8rLHKCn.UMVRmzyGEqZ..m', 'Analyst')`,
+
+			`INSERT OR IGNORE INTO users (id, username, password_hash, role) VALUES 
+			(3, 'viewer', '$2a$10$ek0nw8RvUHOhqP9y48t6uusr3NUq0Zt8rLHKCn.UMVRmzyGEqZ..m', 'Viewer')`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "identity provider link for single sign-on users",
+		Statements: []string{
+			`ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_provider VARCHAR(255) DEFAULT 'local'`,
+			`ALTER TABLE users ADD COLUMN IF NOT EXISTS external_subject VARCHAR(255)`,
+			`CREATE SEQUENCE IF NOT EXISTS users_id_seq START 1000`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "server-side sessions",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS sessions (
+				id VARCHAR(64) PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				refresh_token_hash VARCHAR(64) NOT NULL,
+				user_agent VARCHAR(512),
+				ip_address VARCHAR(64),
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMP NOT NULL,
+				revoked_at TIMESTAMP,
+				revoked_reason VARCHAR(50)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "API keys",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS api_keys (
+				id VARCHAR(16) PRIMARY KEY,
+				name VARCHAR(100) NOT NULL,
+				key_prefix VARCHAR(32) NOT NULL,
+				key_hash VARCHAR(64) NOT NULL,
+				scopes VARCHAR(255) NOT NULL,
+				created_by INTEGER NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMP,
+				last_used_at TIMESTAMP,
+				revoked_at TIMESTAMP
+			)`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "login attempts and account lockouts",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS login_attempts (
+				attempted_at TIMESTAMP NOT NULL,
+				username VARCHAR(255) NOT NULL,
+				ip_address VARCHAR(64),
+				succeeded BOOLEAN NOT NULL,
+				reason VARCHAR(50),
+				cleared BOOLEAN DEFAULT false
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS account_lockouts (
+				username VARCHAR(255) PRIMARY KEY,
+				locked_at TIMESTAMP NOT NULL,
+				locked_until TIMESTAMP NOT NULL,
+				failed_attempts INTEGER
+			)`,
+
+			`CREATE INDEX IF NOT EXISTS idx_login_attempts_username ON login_attempts(username, attempted_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip_address, attempted_at)`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "weekly and monthly report rollups",
+		// Rollups share the columns of their daily table, with date holding
+		// the first day of the bucket and days the number of daily rows in it
+		Statements: []string{
+			`CREATE TABLE platform_stats_weekly AS SELECT * FROM platform_stats LIMIT 0`,
+			`ALTER TABLE platform_stats_weekly ADD COLUMN days INTEGER`,
+			`CREATE INDEX idx_platform_stats_weekly_date ON platform_stats_weekly(date)`,
+			`CREATE TABLE platform_stats_monthly AS SELECT * FROM platform_stats LIMIT 0`,
+			`ALTER TABLE platform_stats_monthly ADD COLUMN days INTEGER`,
+			`CREATE INDEX idx_platform_stats_monthly_date ON platform_stats_monthly(date)`,
+
+			`CREATE TABLE content_health_weekly AS SELECT * FROM content_health LIMIT 0`,
+			`ALTER TABLE content_health_weekly ADD COLUMN days INTEGER`,
+			`CREATE INDEX idx_content_health_weekly_date_platform ON content_health_weekly(date, platform)`,
+			`CREATE TABLE content_health_monthly AS SELECT * FROM content_health LIMIT 0`,
+			`ALTER TABLE content_health_monthly ADD COLUMN days INTEGER`,
+			`CREATE INDEX idx_content_health_monthly_date_platform ON content_health_monthly(date, platform)`,
+
+			`CREATE TABLE video_health_weekly AS SELECT * FROM video_health LIMIT 0`,
+			`ALTER TABLE video_health_weekly ADD COLUMN days INTEGER`,
+			`CREATE INDEX idx_video_health_weekly_date_platform ON video_health_weekly(date, platform)`,
+			`CREATE TABLE video_health_monthly AS SELECT * FROM video_health LIMIT 0`,
+			`ALTER TABLE video_health_monthly ADD COLUMN days INTEGER`,
+			`CREATE INDEX idx_video_health_monthly_date_platform ON video_health_monthly(date, platform)`,
+
+			`CREATE TABLE rollup_watermarks (
+				source VARCHAR(50) PRIMARY KEY,
+				refreshed_through TIMESTAMP NOT NULL
+			)`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "retention run history",
+		Statements: []string{
+			`CREATE TABLE retention_runs (
+				ran_at TIMESTAMP NOT NULL,
+				table_name VARCHAR(100) NOT NULL,
+				cutoff DATE NOT NULL,
+				rows_deleted BIGINT NOT NULL DEFAULT 0,
+				error VARCHAR,
+				PRIMARY KEY (ran_at, table_name)
+			)`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "hourly report tables",
+		// Hourly tables share the columns of their daily table, with hour
+		// holding the start of the UTC hour instead of date
+		Statements: []string{
+			`CREATE TABLE platform_stats_hourly (
+				hour TIMESTAMP NOT NULL,
+				total_requests BIGINT,
+				multi_impression BIGINT,
+				big_guidance BIGINT,
+				addressable BIGINT,
+				compliance_strings BIGINT,
+				deals BIGINT,
+				tmax BIGINT,
+				invalid_requests BIGINT,
+				timeout_rate DECIMAL(5,2),
+				bid_rate DECIMAL(5,2),
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (hour)
+			)`,
+
+			`CREATE TABLE content_health_hourly (
+				hour TIMESTAMP NOT NULL,
+				platform VARCHAR(20) NOT NULL,
+				total_requests BIGINT,
+				album BIGINT,
+				artist BIGINT,
+				cat BIGINT,
+				context BIGINT,
+				data BIGINT,
+				embeddable BIGINT,
+				episode BIGINT,
+				genre BIGINT,
+				id BIGINT,
+				kwarray BIGINT,
+				keywords BIGINT,
+				length BIGINT,
+				language BIGINT,
+				livestream BIGINT,
+				season BIGINT,
+				series BIGINT,
+				title BIGINT,
+				url BIGINT,
+				videoquality BIGINT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (hour, platform)
+			)`,
+
+			`CREATE TABLE video_health_hourly (
+				hour TIMESTAMP NOT NULL,
+				platform VARCHAR(20) NOT NULL,
+				percent_ctv DECIMAL(5,2),
+				api BIGINT,
+				boxing_allowed BIGINT,
+				delivery BIGINT,
+				h BIGINT,
+				linearity BIGINT,
+				max_bitrate BIGINT,
+				max_duration BIGINT,
+				mimes BIGINT,
+				min_bitrate BIGINT,
+				min_cpm_per_sec BIGINT,
+				min_duration BIGINT,
+				placement BIGINT,
+				play_backend BIGINT,
+				pod_dur BIGINT,
+				pod_id BIGINT,
+				pos BIGINT,
+				protocols BIGINT,
+				rqd_durs BIGINT,
+				skip BIGINT,
+				skip_after BIGINT,
+				skip_min BIGINT,
+				slot_in_pod BIGINT,
+				start_delay BIGINT,
+				w BIGINT,
+				max_seq BIGINT,
+				companion_ad BIGINT,
+				companion_type BIGINT,
+				protocol BIGINT,
+				placement_type BIGINT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (hour, platform)
+			)`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "user time zones",
+		Statements: []string{
+			`ALTER TABLE users ADD COLUMN timezone VARCHAR(64) DEFAULT 'UTC'`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "edge agent parameter presence",
+		// Each agent flush covers a window; a resent flush replaces the rows
+		// of the same agent and window. The count tables have no primary
+		// key, since DuckDB cannot delete and reinsert a key in one
+		// transaction
+		Statements: []string{
+			`CREATE TABLE agent_flushes (
+				agent_id VARCHAR(100) NOT NULL,
+				window_start TIMESTAMP NOT NULL,
+				window_end TIMESTAMP NOT NULL,
+				total_requests BIGINT NOT NULL,
+				processed_requests BIGINT NOT NULL,
+				parameters INTEGER NOT NULL,
+				received_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (agent_id, window_start)
+			)`,
+
+			`CREATE TABLE parameter_presence (
+				agent_id VARCHAR(100) NOT NULL,
+				window_start TIMESTAMP NOT NULL,
+				parameter_path VARCHAR(255) NOT NULL,
+				presence_count BIGINT NOT NULL,
+				total_requests BIGINT NOT NULL
+			)`,
+
+			// dimension_key is the agent's name:value|name:value key of one
+			// to three dimensions
+			`CREATE TABLE dimension_presence (
+				agent_id VARCHAR(100) NOT NULL,
+				window_start TIMESTAMP NOT NULL,
+				parameter_path VARCHAR(255) NOT NULL,
+				dimension_key VARCHAR(500) NOT NULL,
+				level INTEGER NOT NULL,
+				presence_count BIGINT NOT NULL,
+				total_requests BIGINT NOT NULL
+			)`,
+			`CREATE INDEX idx_parameter_presence_path ON parameter_presence(parameter_path, window_start)`,
+			`CREATE INDEX idx_dimension_presence_path ON dimension_presence(parameter_path, window_start)`,
+			`CREATE INDEX idx_parameter_presence_flush ON parameter_presence(agent_id, window_start)`,
+			`CREATE INDEX idx_dimension_presence_flush ON dimension_presence(agent_id, window_start)`,
+		},
+	},
+	{
+		Version:     11,
+		Description: "parameter catalog",
+		// Every parameter path agents have reported, kept after the flushes
+		// themselves are deleted by retention
+		Statements: []string{
+			`CREATE TABLE parameter_catalog (
+				parameter_path VARCHAR(255) PRIMARY KEY,
+				first_seen TIMESTAMP NOT NULL,
+				last_seen TIMESTAMP NOT NULL,
+				presence_count BIGINT NOT NULL,
+				total_requests BIGINT NOT NULL,
+				sample_values VARCHAR,
+				updated_at TIMESTAMP NOT NULL
+			)`,
+
+			// Catalog the flushes stored before it existed
+			`INSERT INTO parameter_catalog
+				(parameter_path, first_seen, last_seen, presence_count, total_requests, updated_at)
+			SELECT p.parameter_path, MIN(p.window_start), MAX(f.window_end),
+			       SUM(p.presence_count), SUM(p.total_requests), CURRENT_TIMESTAMP
+			FROM parameter_presence p
+			JOIN agent_flushes f ON f.agent_id = p.agent_id AND f.window_start = p.window_start
+			GROUP BY p.parameter_path`,
+		},
+	},
+}
+
 func RunMigrations(db *sql.DB) error {
-	migrations := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY,
-			username VARCHAR(50) UNIQUE NOT NULL,
-			password_hash VARCHAR(255) NOT NULL,
-			role VARCHAR(20) NOT NULL CHECK (role IN ('Viewer', 'Analyst', 'Admin')),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-		
-		`CREATE TABLE IF NOT EXISTS platform_stats (
-			date DATE NOT NULL,
-			total_requests BIGINT,
-			multi_impression BIGINT,
-			big_guidance BIGINT,
-			addressable BIGINT,
-			compliance_strings BIGINT,
-			deals BIGINT,
-			tmax BIGINT,
-			invalid_requests BIGINT,
-			timeout_rate DECIMAL(5,2),
-			bid_rate DECIMAL(5,2),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (date)
-		)`,
-		
-		`CREATE TABLE IF NOT EXISTS content_health (
-			date DATE NOT NULL,
-			platform VARCHAR(20) NOT NULL,
-			total_requests BIGINT,
-			album BIGINT,
-			artist BIGINT,
-			cat BIGINT,
-			context BIGINT,
-			data BIGINT,
-			embeddable BIGINT,
-			episode BIGINT,
-			genre BIGINT,
-			id BIGINT,
-			kwarray BIGINT,
-			keywords BIGINT,
-			length BIGINT,
-			language BIGINT,
-			livestream BIGINT,
-			season BIGINT,
-			series BIGINT,
-			title BIGINT,
-			url BIGINT,
-			videoquality BIGINT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (date, platform)
-		)`,
-		
-		`CREATE TABLE IF NOT EXISTS video_health (
-			date DATE NOT NULL,
-			platform VARCHAR(20) NOT NULL,
-			percent_ctv DECIMAL(5,2),
-			api BIGINT,
-			boxing_allowed BIGINT,
-			delivery BIGINT,
-			h BIGINT,
-			linearity BIGINT,
-			max_bitrate BIGINT,
-			max_duration BIGINT,
-			mimes BIGINT,
-			min_bitrate BIGINT,
-			min_cpm_per_sec BIGINT,
-			min_duration BIGINT,
-			placement BIGINT,
-			play_backend BIGINT,
-			pod_dur BIGINT,
-			pod_id BIGINT,
-			pos BIGINT,
-			protocols BIGINT,
-			rqd_durs BIGINT,
-			skip BIGINT,
-			skip_after BIGINT,
-			skip_min BIGINT,
-			slot_in_pod BIGINT,
-			start_delay BIGINT,
-			w BIGINT,
-			max_seq BIGINT,
-			companion_ad BIGINT,
-			companion_type BIGINT,
-			protocol BIGINT,
-			placement_type BIGINT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (date, platform)
-		)`,
-		
-		// Create indexes for better query performance
-		`CREATE INDEX IF NOT EXISTS idx_platform_stats_date ON platform_stats(date)`,
-		`CREATE INDEX IF NOT EXISTS idx_content_health_date_platform ON content_health(date, platform)`,
-		`CREATE INDEX IF NOT EXISTS idx_video_health_date_platform ON video_health(date, platform)`,
-		
-		// Insert default admin user (password: admin123)
-		`INSERT OR IGNORE INTO users (id, username, password_hash, role) VALUES 
-		(1, 'admin', '$2a$10$ek0nw8RvUHOhqP9y48t6uusr3NUq0Zt8rLHKCn.UMVRmzyGEqZ..m', 'Admin')`,
-		
-		// Insert demo users
-		`INSERT OR IGNORE INTO users (id, username, password_hash, role) VALUES 
-		(2, 'analyst', '$2a$10$ek0nw8RvUHOhqP9y48t6uusr3NUq0Zt8rLHKCn.UMVRmzyGEqZ..m', 'Analyst')`,
-		
-		`INSERT OR IGNORE INTO users (id, username, password_hash, role) VALUES 
-		(3, 'viewer', '$2a$10$ek0nw8RvUHOhqP9y48t6uusr3NUq0Zt8rLHKCn.UMVRmzyGEqZ..m', 'Viewer')`,
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description VARCHAR(255),
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	current, err := SchemaVersion(context.Background(), db)
+	if err != nil {
+		return err
 	}
 
-	for i, migration := range migrations {
-		if _, err := db.Exec(migration); err != nil {
-			log.Printf("Migration %d failed: %v", i+1, err)
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		if err := applyMigration(db, migration); err != nil {
+			slog.Error("Migration failed", "version", migration.Version, "description", migration.Description, "error", err)
 			return err
 		}
+		slog.Info("Applied migration", "version", migration.Version, "description", migration.Description)
 	}
 
-	log.Println("All database migrations completed successfully")
+	slog.Info("All database migrations completed successfully", "schema_version", LatestSchemaVersion())
 	return nil
-}
\ No newline at end of file
+}
+
+// SchemaVersion returns the newest migration applied to the database, or 0
+// if none has been.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// LatestSchemaVersion is the version this build migrates databases to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func applyMigration(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, statement := range migration.Statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("statement %d: %w", i+1, err)
+		}
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)",
+		migration.Version, migration.Description, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+// ErrReadOnly is returned for a statement that could modify the database
+// on a read-only pool.
+var ErrReadOnly = errors.New("database: statement not allowed on a read-only connection")
+
+// borrowedConnector shares a connector without owning it: sql.DB closes
+// connectors that implement io.Closer, which would close the DuckDB
+// instance under the other pools.
+type borrowedConnector struct {
+	connector *duckdb.Connector
+}
+
+func (c borrowedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.connector.Connect(ctx)
+}
+
+func (c borrowedConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// readOnlyConnector hands out connections that only run SELECT and
+// EXPLAIN statements. DuckDB has no per-connection read-only mode, so the
+// type of every statement is checked once it is prepared.
+type readOnlyConnector struct {
+	connector *duckdb.Connector
+}
+
+func (c readOnlyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyConn{conn: conn.(*duckdb.Conn)}, nil
+}
+
+func (c readOnlyConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// readOnlyConn deliberately implements neither ExecerContext nor
+// QueryerContext, so database/sql prepares every statement through
+// PrepareContext.
+type readOnlyConn struct {
+	conn *duckdb.Conn
+}
+
+func (c *readOnlyConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *readOnlyConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	stmtType, err := stmt.(*duckdb.Stmt).StatementType()
+	if err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	switch stmtType {
+	case duckdb.STATEMENT_TYPE_SELECT, duckdb.STATEMENT_TYPE_EXPLAIN:
+		return stmt, nil
+	default:
+		stmt.Close()
+		return nil, ErrReadOnly
+	}
+}
+
+func (c *readOnlyConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *readOnlyConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.conn.BeginTx(ctx, opts)
+}
+
+func (c *readOnlyConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.conn.CheckNamedValue(nv)
+}
+
+func (c *readOnlyConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package health
+
+// freeDiskBytes is not implemented on this platform, so the disk check is
+// skipped.
+func freeDiskBytes(path string) (uint64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package health
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// freeDiskBytes returns the space available to unprivileged users on the
+// file system holding path.
+func freeDiskBytes(path string) (uint64, bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(filepath.Dir(path), &stat); err != nil {
+		return 0, true, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true, nil
+}
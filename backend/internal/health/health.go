@@ -0,0 +1,198 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"openrtb-insights/internal/database"
+	"openrtb-insights/internal/logging"
+	"openrtb-insights/internal/version"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Check statuses. A warning is reported but does not make the server
+// unready; a failure does.
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+type Config struct {
+	// DBPath locates the file system whose free space is checked
+	DBPath string
+	// Timeout bounds the whole readiness check
+	Timeout time.Duration
+	// MaxDataAge is how old the newest platform_stats row may be before
+	// the data is reported as stale
+	MaxDataAge time.Duration
+	// MinFreeDisk is the free space, in bytes, below which the server is
+	// not ready; 0 disables the disk check
+	MinFreeDisk uint64
+}
+
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Message    string  `json:"message,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+type Report struct {
+	Status  string                 `json:"status"`
+	Version string                 `json:"version"`
+	Commit  string                 `json:"commit"`
+	Time    string                 `json:"time"`
+	Checks  map[string]CheckResult `json:"checks"`
+}
+
+type Checker struct {
+	db  *sql.DB
+	cfg Config
+	now func() time.Time
+}
+
+func NewChecker(db *sql.DB, cfg Config) *Checker {
+	return &Checker{db: db, cfg: cfg, now: time.Now}
+}
+
+// Livez reports that the process is up and serving requests. It touches no
+// dependencies so a slow database never gets the server restarted.
+func (h *Checker) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "alive",
+		"version": version.Version,
+		"commit":  version.Commit,
+	})
+}
+
+// Readyz runs the readiness checks and responds with 503 when any of them
+// fails, so load balancers stop sending traffic to this instance.
+func (h *Checker) Readyz(c *gin.Context) {
+	report := h.Check(c.Request.Context())
+
+	status := http.StatusOK
+	if report.Status == "not_ready" {
+		status = http.StatusServiceUnavailable
+		logging.FromContext(c.Request.Context()).Warn("Readiness check failed", "checks", report.Checks)
+	}
+	c.JSON(status, report)
+}
+
+// Check runs every readiness check within the configured timeout.
+func (h *Checker) Check(ctx context.Context) Report {
+	if h.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.Timeout)
+		defer cancel()
+	}
+
+	checks := map[string]func(context.Context) CheckResult{
+		"database":       h.checkDatabase,
+		"schema":         h.checkSchema,
+		"data_freshness": h.checkDataFreshness,
+		"disk":           h.checkDisk,
+	}
+
+	report := Report{
+		Status:  "ready",
+		Version: version.Version,
+		Commit:  version.Commit,
+		Time:    h.now().UTC().Format(time.RFC3339),
+		Checks:  make(map[string]CheckResult, len(checks)),
+	}
+	for name, check := range checks {
+		start := time.Now()
+		result := check(ctx)
+		result.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		report.Checks[name] = result
+
+		switch {
+		case result.Status == StatusFail:
+			report.Status = "not_ready"
+		case result.Status == StatusWarn && report.Status == "ready":
+			report.Status = "degraded"
+		}
+	}
+	return report
+}
+
+func (h *Checker) checkDatabase(ctx context.Context) CheckResult {
+	if err := h.db.PingContext(ctx); err != nil {
+		return fail("ping failed: %v", err)
+	}
+	var one int
+	if err := h.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fail("query failed: %v", err)
+	}
+	return pass("")
+}
+
+func (h *Checker) checkSchema(ctx context.Context) CheckResult {
+	current, err := database.SchemaVersion(ctx, h.db)
+	if err != nil {
+		return fail("reading schema version failed: %v", err)
+	}
+
+	latest := database.LatestSchemaVersion()
+	switch {
+	case current < latest:
+		return fail("schema version %d, migrations up to %d have not run", current, latest)
+	case current > latest:
+		return fail("schema version %d is newer than this build (%d)", current, latest)
+	}
+	return pass("schema version %d", current)
+}
+
+func (h *Checker) checkDataFreshness(ctx context.Context) CheckResult {
+	var newest sql.NullTime
+	if err := h.db.QueryRowContext(ctx, "SELECT MAX(date) FROM platform_stats").Scan(&newest); err != nil {
+		return fail("query failed: %v", err)
+	}
+	if !newest.Valid {
+		return warn("platform_stats is empty")
+	}
+
+	// A day's row covers the whole day, so measure from its end
+	age := h.now().Sub(newest.Time.AddDate(0, 0, 1))
+	if age < 0 {
+		age = 0
+	}
+	latest := newest.Time.Format("2006-01-02")
+	if h.cfg.MaxDataAge > 0 && age > h.cfg.MaxDataAge {
+		return warn("newest platform_stats row is from %s, older than %s", latest, h.cfg.MaxDataAge)
+	}
+	return pass("newest platform_stats row is from %s", latest)
+}
+
+func (h *Checker) checkDisk(ctx context.Context) CheckResult {
+	if h.cfg.MinFreeDisk == 0 || h.cfg.DBPath == "" || h.cfg.DBPath == ":memory:" {
+		return pass("disk check disabled")
+	}
+
+	free, supported, err := freeDiskBytes(h.cfg.DBPath)
+	switch {
+	case !supported:
+		return pass("disk check not supported on this platform")
+	case err != nil:
+		return fail("reading free space failed: %v", err)
+	case free < h.cfg.MinFreeDisk:
+		return fail("%d MB free, below the %d MB minimum", free>>20, h.cfg.MinFreeDisk>>20)
+	}
+	return pass("%d MB free", free>>20)
+}
+
+func pass(format string, args ...interface{}) CheckResult {
+	return CheckResult{Status: StatusPass, Message: fmt.Sprintf(format, args...)}
+}
+
+func warn(format string, args ...interface{}) CheckResult {
+	return CheckResult{Status: StatusWarn, Message: fmt.Sprintf(format, args...)}
+}
+
+func fail(format string, args ...interface{}) CheckResult {
+	return CheckResult{Status: StatusFail, Message: fmt.Sprintf(format, args...)}
+}
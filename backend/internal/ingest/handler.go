@@ -0,0 +1,76 @@
+package ingest
+
+import (
+	"errors"
+	"net/http"
+
+	"openrtb-insights/internal/logging"
+	"openrtb-insights/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBodyBytes bounds a flush; one from an agent at its default limits is
+// a few hundred kilobytes.
+const maxBodyBytes = 16 << 20
+
+type Handler struct {
+	ingester *Ingester
+}
+
+func NewHandler(ingester *Ingester) *Handler {
+	return &Handler{ingester: ingester}
+}
+
+// IngestAgentFlush stores an edge agent flush.
+func (h *Handler) IngestAgentFlush(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
+
+	var payload Payload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		metrics.AgentFlushReceived("rejected")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "Payload too large, flush more often",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format",
+		})
+		return
+	}
+
+	logger := logging.FromContext(c.Request.Context())
+	summary, err := h.ingester.Ingest(c.Request.Context(), &payload)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPayload) {
+			metrics.AgentFlushReceived("rejected")
+			logger.Warn("Rejected agent flush", "agent_id", payload.AgentID, "error", err)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		metrics.AgentFlushReceived("failure")
+		logger.Error("Failed to store agent flush", "agent_id", payload.AgentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to store agent flush",
+		})
+		return
+	}
+
+	status := http.StatusCreated
+	if summary.Replaced {
+		status = http.StatusOK
+		metrics.AgentFlushReceived("replaced")
+	} else {
+		metrics.AgentFlushReceived("stored")
+	}
+	logger.Info("Stored agent flush", "agent_id", summary.AgentID, "window_start", summary.WindowStart,
+		"parameters", summary.Parameters, "dimensions", summary.Dimensions, "replaced", summary.Replaced)
+	c.JSON(status, gin.H{
+		"data": summary,
+	})
+}
@@ -0,0 +1,120 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"openrtb-insights/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter serves POST /ingest/agent behind API key authentication
+// and the ingest scope, the way the server mounts the handler. It returns
+// a key with the scope and one without.
+func newTestRouter(t *testing.T) (*gin.Engine, string, string) {
+	t.Helper()
+	db := newTestDB(t)
+	keys := auth.NewAPIKeyStore(db)
+	middleware := auth.NewAuthMiddleware(db, auth.NewSessionStore(db), nil, keys)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/ingest/agent", middleware.RequireAuth(), middleware.RequireScope(auth.ScopeIngestWrite),
+		NewHandler(NewIngester(db, nil)).IngestAgentFlush)
+
+	create := func(scope string) string {
+		plaintext, _, err := keys.Create("test", []string{scope}, 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plaintext
+	}
+	return router, create(auth.ScopeIngestWrite), create(auth.ScopeReportsRead)
+}
+
+func post(router *gin.Engine, key string, body []byte) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/ingest/agent", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		request.Header.Set("X-API-Key", key)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func encode(t *testing.T, payload *Payload) []byte {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestIngestAgentFlush(t *testing.T) {
+	router, ingestKey, reportsKey := newTestRouter(t)
+
+	invalid := testPayload()
+	invalid.Parameters[0].PresenceCount = 101
+	// Padding inside a JSON string takes the body past the limit
+	tooLarge := []byte(`{"agent_id": "edge-1", "metadata": {"padding": "` + strings.Repeat("a", maxBodyBytes) + `"}}`)
+
+	tests := []struct {
+		name   string
+		key    string
+		body   []byte
+		status int
+	}{
+		{"no key", "", encode(t, testPayload()), http.StatusUnauthorized},
+		{"key without the ingest scope", reportsKey, encode(t, testPayload()), http.StatusForbidden},
+		{"new flush", ingestKey, encode(t, testPayload()), http.StatusCreated},
+		{"retried flush", ingestKey, encode(t, testPayload()), http.StatusOK},
+		{"malformed JSON", ingestKey, []byte(`{"agent_id": `), http.StatusBadRequest},
+		{"wrong types", ingestKey, []byte(`{"agent_id": 7}`), http.StatusBadRequest},
+		{"invalid payload", ingestKey, encode(t, invalid), http.StatusUnprocessableEntity},
+		{"body too large", ingestKey, tooLarge, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := post(router, test.key, test.body)
+			if recorder.Code != test.status {
+				t.Errorf("got %d, want %d: %.200s", recorder.Code, test.status, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestIngestAgentFlushResponse(t *testing.T) {
+	router, ingestKey, _ := newTestRouter(t)
+
+	var created struct {
+		Data Summary `json:"data"`
+	}
+	recorder := post(router, ingestKey, encode(t, testPayload()))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if got := created.Data; got.AgentID != "edge-1" || !got.WindowStart.Equal(windowStart) || got.Parameters != 1 || got.Dimensions != 2 || got.Replaced {
+		t.Errorf("got summary %+v", got)
+	}
+
+	// The validation error names the offending parameter
+	invalid := testPayload()
+	invalid.Parameters[0].Dimensions[0].DimensionKey = "device_type"
+	var rejected struct {
+		Error string `json:"error"`
+	}
+	recorder = post(router, ingestKey, encode(t, invalid))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &rejected); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rejected.Error, "app.content.genre") || !strings.Contains(rejected.Error, "device_type") {
+		t.Errorf("got error %q", rejected.Error)
+	}
+}
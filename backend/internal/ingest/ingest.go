@@ -0,0 +1,144 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"openrtb-insights/internal/catalog"
+)
+
+// ErrInvalidPayload rejects a flush failing validation; nothing of it is
+// stored.
+var ErrInvalidPayload = errors.New("invalid agent payload")
+
+// insertBatch is the number of rows written by one INSERT statement.
+const insertBatch = 500
+
+// Ingester stores edge agent flushes for the presence reports and the
+// parameter catalog. A flush is keyed by its agent and window start, so an
+// agent retrying a flush replaces the rows it sent before instead of
+// counting them twice.
+type Ingester struct {
+	db *sql.DB
+	// onChange runs after a flush is stored, e.g. to drop cached reports
+	onChange func()
+}
+
+func NewIngester(db *sql.DB, onChange func()) *Ingester {
+	return &Ingester{db: db, onChange: onChange}
+}
+
+// Summary describes a stored flush.
+type Summary struct {
+	AgentID     string    `json:"agent_id"`
+	WindowStart time.Time `json:"window_start"`
+	Parameters  int       `json:"parameters"`
+	Dimensions  int       `json:"dimensions"`
+	// Replaced is set when the flush replaced one sent earlier
+	Replaced bool `json:"replaced"`
+}
+
+// Ingest validates a flush and stores it in one transaction.
+func (i *Ingester) Ingest(ctx context.Context, p *Payload) (*Summary, error) {
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	windowStart := p.TimestampStart.UTC()
+	summary := &Summary{AgentID: p.AgentID, WindowStart: windowStart, Parameters: len(p.Parameters)}
+
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM agent_flushes WHERE agent_id = ? AND window_start = ?`,
+		p.AgentID, windowStart).Scan(&previous); err != nil {
+		return nil, fmt.Errorf("look up earlier flush: %w", err)
+	}
+	summary.Replaced = previous > 0
+	if summary.Replaced {
+		if err := catalog.Forget(ctx, tx, p.AgentID, windowStart); err != nil {
+			return nil, err
+		}
+	}
+	for _, table := range []string{"parameter_presence", "dimension_presence"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE agent_id = ? AND window_start = ?",
+			p.AgentID, windowStart); err != nil {
+			return nil, fmt.Errorf("replace earlier flush in %s: %w", table, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO agent_flushes (agent_id, window_start, window_end, total_requests, processed_requests, parameters, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, p.AgentID, windowStart, p.TimestampEnd.UTC(), p.TotalRequests, p.ProcessedReqs, len(p.Parameters), time.Now().UTC()); err != nil {
+		return nil, fmt.Errorf("store flush: %w", err)
+	}
+
+	parameters := make([][]any, 0, len(p.Parameters))
+	observations := make([]catalog.Observation, 0, len(p.Parameters))
+	var dimensions [][]any
+	for _, param := range p.Parameters {
+		parameters = append(parameters, []any{p.AgentID, windowStart, param.Path, param.PresenceCount, param.TotalRequests})
+		first, last := param.seen(p)
+		observations = append(observations, catalog.Observation{
+			Path:          param.Path,
+			FirstSeen:     first,
+			LastSeen:      last,
+			PresenceCount: param.PresenceCount,
+			TotalRequests: param.TotalRequests,
+			SampleValues:  param.SampleValues,
+		})
+		for _, dim := range param.Dimensions {
+			level, _ := dimensionLevel(dim.DimensionKey)
+			dimensions = append(dimensions, []any{p.AgentID, windowStart, param.Path, dim.DimensionKey, level, dim.PresenceCount, dim.TotalRequests})
+		}
+	}
+	if err := insertRows(ctx, tx, "parameter_presence",
+		[]string{"agent_id", "window_start", "parameter_path", "presence_count", "total_requests"}, parameters); err != nil {
+		return nil, err
+	}
+	if err := insertRows(ctx, tx, "dimension_presence",
+		[]string{"agent_id", "window_start", "parameter_path", "dimension_key", "level", "presence_count", "total_requests"}, dimensions); err != nil {
+		return nil, err
+	}
+	summary.Dimensions = len(dimensions)
+	if err := catalog.Record(ctx, tx, observations); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if i.onChange != nil {
+		i.onChange()
+	}
+	return summary, nil
+}
+
+// insertRows writes rows in batches of multi-row INSERT statements.
+func insertRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any) error {
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	for start := 0; start < len(rows); start += insertBatch {
+		batch := rows[start:min(start+insertBatch, len(rows))]
+
+		values := make([]string, len(batch))
+		args := make([]any, 0, len(batch)*len(columns))
+		for j, row := range batch {
+			values[j] = placeholder
+			args = append(args, row...)
+		}
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(columns, ", "), strings.Join(values, ", "))
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("store %s: %w", table, err)
+		}
+	}
+	return nil
+}
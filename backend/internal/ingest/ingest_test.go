@@ -0,0 +1,164 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"openrtb-insights/internal/database"
+)
+
+var windowStart = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Connect("", database.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testPayload is a valid flush of one minute with a parameter present in
+// half of 100 requests, broken down by device type.
+func testPayload() *Payload {
+	return &Payload{
+		AgentID:        "edge-1",
+		TimestampStart: windowStart,
+		TimestampEnd:   windowStart.Add(time.Minute),
+		TotalRequests:  100,
+		ProcessedReqs:  100,
+		Parameters: []Parameter{{
+			Path:          "app.content.genre",
+			PresenceCount: 50,
+			TotalRequests: 100,
+			Dimensions: []Dimension{
+				{DimensionKey: "device_type:3", PresenceCount: 40, TotalRequests: 60},
+				{DimensionKey: "device_type:3|has_ifa:true", PresenceCount: 30, TotalRequests: 40},
+			},
+		}},
+	}
+}
+
+func count(t *testing.T, db *sql.DB, query string, args ...any) int64 {
+	t.Helper()
+	var n int64
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestIngestReplacesRetriedFlush(t *testing.T) {
+	db := newTestDB(t)
+	changes := 0
+	ingester := NewIngester(db, func() { changes++ })
+
+	summary, err := ingester.Ingest(context.Background(), testPayload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Replaced || summary.Parameters != 1 || summary.Dimensions != 2 || !summary.WindowStart.Equal(windowStart) {
+		t.Errorf("got summary %+v", summary)
+	}
+
+	// A retry of the same window replaces the rows instead of adding to them
+	retry := testPayload()
+	retry.Parameters[0].PresenceCount = 70
+	retry.Parameters[0].Dimensions = retry.Parameters[0].Dimensions[:1]
+	summary, err = ingester.Ingest(context.Background(), retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.Replaced || summary.Dimensions != 1 {
+		t.Errorf("got summary %+v for the retry", summary)
+	}
+	if changes != 2 {
+		t.Errorf("onChange ran %d times, want 2", changes)
+	}
+
+	if got := count(t, db, `SELECT COUNT(*) FROM agent_flushes`); got != 1 {
+		t.Errorf("got %d flushes, want 1", got)
+	}
+	if got := count(t, db, `SELECT SUM(presence_count) FROM parameter_presence`); got != 70 {
+		t.Errorf("got presence %d, want the retry's 70", got)
+	}
+	if got := count(t, db, `SELECT COUNT(*) FROM dimension_presence`); got != 1 {
+		t.Errorf("got %d dimension rows, want the retry's 1", got)
+	}
+	if got := count(t, db, `SELECT level FROM dimension_presence`); got != 1 {
+		t.Errorf("got level %d for device_type:3, want 1", got)
+	}
+
+	// Another agent's flush of the same window is kept alongside
+	other := testPayload()
+	other.AgentID = "edge-2"
+	if summary, err := ingester.Ingest(context.Background(), other); err != nil || summary.Replaced {
+		t.Fatalf("got %+v, %v for another agent", summary, err)
+	}
+	if got := count(t, db, `SELECT SUM(presence_count) FROM parameter_presence`); got != 120 {
+		t.Errorf("got presence %d, want 120 over both agents", got)
+	}
+}
+
+func TestIngestBatchesRows(t *testing.T) {
+	db := newTestDB(t)
+	payload := testPayload()
+	payload.Parameters = nil
+	for i := 0; i < insertBatch+1; i++ {
+		payload.Parameters = append(payload.Parameters, Parameter{
+			Path: fmt.Sprintf("ext.field%d", i), PresenceCount: 1, TotalRequests: 100,
+		})
+	}
+
+	if _, err := NewIngester(db, nil).Ingest(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(t, db, `SELECT COUNT(*) FROM parameter_presence`); got != insertBatch+1 {
+		t.Errorf("got %d rows, want %d", got, insertBatch+1)
+	}
+}
+
+func TestIngestKeepsCatalogCountsOnRetry(t *testing.T) {
+	db := newTestDB(t)
+	ingester := NewIngester(db, nil)
+
+	payload := testPayload()
+	payload.Parameters[0].SampleValues = []interface{}{"Drama"}
+	for i := 0; i < 2; i++ {
+		if _, err := ingester.Ingest(context.Background(), payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := testPayload()
+	next.TimestampStart, next.TimestampEnd = windowStart.Add(time.Minute), windowStart.Add(2*time.Minute)
+	if _, err := ingester.Ingest(context.Background(), next); err != nil {
+		t.Fatal(err)
+	}
+
+	var presence, total int64
+	var firstSeen, lastSeen time.Time
+	var samples string
+	if err := db.QueryRow(`
+		SELECT presence_count, total_requests, first_seen, last_seen, sample_values
+		FROM parameter_catalog WHERE parameter_path = 'app.content.genre'
+	`).Scan(&presence, &total, &firstSeen, &lastSeen, &samples); err != nil {
+		t.Fatal(err)
+	}
+	// The retried window counts once, next to the following one
+	if presence != 100 || total != 200 {
+		t.Errorf("got %d of %d, want 100 of 200", presence, total)
+	}
+	if !firstSeen.Equal(windowStart) || !lastSeen.Equal(windowStart.Add(2*time.Minute)) {
+		t.Errorf("seen %s to %s, want both windows", firstSeen, lastSeen)
+	}
+	if samples != `["Drama"]` {
+		t.Errorf("got samples %s", samples)
+	}
+}
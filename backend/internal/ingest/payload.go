@@ -0,0 +1,147 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits on a single flush, well above what an agent with the default
+// MaxParameters and MaxDimensionCombos sends.
+const (
+	maxParameters         = 5000
+	maxDimensionsPerParam = 500
+	maxPathLength         = 255
+	maxDimensionKeyLength = 500
+	// maxDimensionLevel is the deepest dimension key the agent builds, a
+	// primary dimension with two conditional ones
+	maxDimensionLevel = 3
+)
+
+// Payload is an edge agent flush: how often each parameter path was
+// present in the requests of one window, overall and per dimension key.
+type Payload struct {
+	AgentID        string                 `json:"agent_id"`
+	TimestampStart time.Time              `json:"timestamp_start"`
+	TimestampEnd   time.Time              `json:"timestamp_end"`
+	TotalRequests  int64                  `json:"total_requests"`
+	ProcessedReqs  int64                  `json:"processed_requests"`
+	Parameters     []Parameter            `json:"parameters"`
+	Metadata       map[string]interface{} `json:"metadata"`
+}
+
+// Parameter counts a parameter path in the window's requests. FirstSeen
+// and LastSeen are when the agent saw it in the window; agents that do not
+// send them are taken to have seen it for the whole window.
+type Parameter struct {
+	Path          string        `json:"path"`
+	PresenceCount int64         `json:"presence_count"`
+	TotalRequests int64         `json:"total_requests"`
+	FirstSeen     time.Time     `json:"first_seen"`
+	LastSeen      time.Time     `json:"last_seen"`
+	SampleValues  []interface{} `json:"sample_values,omitempty"`
+	Dimensions    []Dimension   `json:"dimensions"`
+}
+
+// seen returns when the parameter was first and last seen.
+func (param Parameter) seen(p *Payload) (time.Time, time.Time) {
+	first, last := param.FirstSeen, param.LastSeen
+	if first.IsZero() {
+		first = p.TimestampStart
+	}
+	if last.IsZero() {
+		last = p.TimestampEnd
+	}
+	return first, last
+}
+
+// Dimension counts a parameter within the requests matching a dimension
+// key, e.g. device_type:3|has_ifa:true. The agent's presence_rate is
+// ignored; reports compute rates from the counts.
+type Dimension struct {
+	DimensionKey  string  `json:"dimension_key"`
+	PresenceCount int64   `json:"presence_count"`
+	TotalRequests int64   `json:"total_requests"`
+	PresenceRate  float64 `json:"presence_rate"`
+}
+
+// validate checks a payload before anything is written, so a bad flush is
+// rejected as a whole.
+func (p *Payload) validate() error {
+	if p.AgentID == "" || len(p.AgentID) > 100 {
+		return errors.New("agent_id is required, at most 100 characters")
+	}
+	if p.TimestampStart.IsZero() || p.TimestampEnd.Before(p.TimestampStart) {
+		return errors.New("timestamp_start is required and must not be after timestamp_end")
+	}
+	if p.TimestampStart.After(time.Now().Add(time.Hour)) {
+		return errors.New("timestamp_start is in the future")
+	}
+	if p.ProcessedReqs < 0 || p.TotalRequests < p.ProcessedReqs {
+		return errors.New("processed_requests must be between 0 and total_requests")
+	}
+	if len(p.Parameters) > maxParameters {
+		return fmt.Errorf("at most %d parameters per flush", maxParameters)
+	}
+
+	seen := make(map[string]bool, len(p.Parameters))
+	for _, param := range p.Parameters {
+		if param.Path == "" || len(param.Path) > maxPathLength {
+			return fmt.Errorf("parameter path %q must be 1 to %d characters", param.Path, maxPathLength)
+		}
+		if seen[param.Path] {
+			return fmt.Errorf("duplicate parameter %s", param.Path)
+		}
+		seen[param.Path] = true
+		if err := checkCounts(param.PresenceCount, param.TotalRequests); err != nil {
+			return fmt.Errorf("parameter %s: %w", param.Path, err)
+		}
+		if first, last := param.seen(p); last.Before(first) {
+			return fmt.Errorf("parameter %s: first_seen must not be after last_seen", param.Path)
+		}
+		if len(param.Dimensions) > maxDimensionsPerParam {
+			return fmt.Errorf("parameter %s: at most %d dimension keys", param.Path, maxDimensionsPerParam)
+		}
+
+		keys := make(map[string]bool, len(param.Dimensions))
+		for _, dim := range param.Dimensions {
+			if _, err := dimensionLevel(dim.DimensionKey); err != nil {
+				return fmt.Errorf("parameter %s: %w", param.Path, err)
+			}
+			if keys[dim.DimensionKey] {
+				return fmt.Errorf("parameter %s: duplicate dimension key %s", param.Path, dim.DimensionKey)
+			}
+			keys[dim.DimensionKey] = true
+			if err := checkCounts(dim.PresenceCount, dim.TotalRequests); err != nil {
+				return fmt.Errorf("parameter %s, dimension %s: %w", param.Path, dim.DimensionKey, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkCounts(presence, total int64) error {
+	if presence < 0 || total < presence {
+		return errors.New("presence_count must be between 0 and total_requests")
+	}
+	return nil
+}
+
+// dimensionLevel returns the number of name:value pairs in a dimension
+// key.
+func dimensionLevel(key string) (int, error) {
+	if key == "" || len(key) > maxDimensionKeyLength {
+		return 0, fmt.Errorf("dimension key %q must be 1 to %d characters", key, maxDimensionKeyLength)
+	}
+	parts := strings.Split(key, "|")
+	if len(parts) > maxDimensionLevel {
+		return 0, fmt.Errorf("dimension key %s has more than %d dimensions", key, maxDimensionLevel)
+	}
+	for _, part := range parts {
+		if name, _, ok := strings.Cut(part, ":"); !ok || name == "" {
+			return 0, fmt.Errorf("invalid dimension key %s, expected name:value pairs joined by |", key)
+		}
+	}
+	return len(parts), nil
+}
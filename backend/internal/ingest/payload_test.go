@@ -0,0 +1,99 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPayloadValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(*Payload)
+		valid bool
+	}{
+		{"valid", func(*Payload) {}, true},
+		{"three dimensions", func(p *Payload) {
+			p.Parameters[0].Dimensions[0].DimensionKey = "request_type:video|content_type:movie|has_series_info:false"
+		}, true},
+		{"seen times within the window", func(p *Payload) {
+			p.Parameters[0].FirstSeen = windowStart.Add(10 * time.Second)
+			p.Parameters[0].LastSeen = windowStart.Add(20 * time.Second)
+		}, true},
+		{"no agent", func(p *Payload) { p.AgentID = "" }, false},
+		{"long agent", func(p *Payload) { p.AgentID = strings.Repeat("a", 101) }, false},
+		{"no start", func(p *Payload) { p.TimestampStart = time.Time{} }, false},
+		{"end before start", func(p *Payload) { p.TimestampEnd = windowStart.Add(-time.Second) }, false},
+		{"future window", func(p *Payload) {
+			p.TimestampStart = time.Now().Add(2 * time.Hour)
+			p.TimestampEnd = p.TimestampStart.Add(time.Minute)
+		}, false},
+		{"more processed than total", func(p *Payload) { p.ProcessedReqs = 101 }, false},
+		{"negative processed", func(p *Payload) { p.ProcessedReqs = -1 }, false},
+		{"too many parameters", func(p *Payload) {
+			p.Parameters = make([]Parameter, maxParameters+1)
+		}, false},
+		{"empty path", func(p *Payload) { p.Parameters[0].Path = "" }, false},
+		{"long path", func(p *Payload) { p.Parameters[0].Path = strings.Repeat("a", maxPathLength+1) }, false},
+		{"duplicate path", func(p *Payload) { p.Parameters = append(p.Parameters, p.Parameters[0]) }, false},
+		{"presence above total", func(p *Payload) { p.Parameters[0].PresenceCount = 101 }, false},
+		{"negative presence", func(p *Payload) { p.Parameters[0].PresenceCount = -1 }, false},
+		{"last seen before first seen", func(p *Payload) {
+			p.Parameters[0].FirstSeen = windowStart.Add(20 * time.Second)
+			p.Parameters[0].LastSeen = windowStart.Add(10 * time.Second)
+		}, false},
+		{"first seen after the window without last seen", func(p *Payload) {
+			p.Parameters[0].FirstSeen = windowStart.Add(2 * time.Minute)
+		}, false},
+		{"too many dimension keys", func(p *Payload) {
+			p.Parameters[0].Dimensions = make([]Dimension, maxDimensionsPerParam+1)
+		}, false},
+		{"empty dimension key", func(p *Payload) { p.Parameters[0].Dimensions[0].DimensionKey = "" }, false},
+		{"four dimensions", func(p *Payload) {
+			p.Parameters[0].Dimensions[0].DimensionKey = "a:1|b:2|c:3|d:4"
+		}, false},
+		{"dimension without value separator", func(p *Payload) {
+			p.Parameters[0].Dimensions[0].DimensionKey = "device_type"
+		}, false},
+		{"dimension without name", func(p *Payload) {
+			p.Parameters[0].Dimensions[0].DimensionKey = "device_type:3|:true"
+		}, false},
+		{"duplicate dimension key", func(p *Payload) {
+			p.Parameters[0].Dimensions[1].DimensionKey = p.Parameters[0].Dimensions[0].DimensionKey
+		}, false},
+		{"dimension presence above total", func(p *Payload) {
+			p.Parameters[0].Dimensions[0].PresenceCount = 61
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := testPayload()
+			test.edit(payload)
+			err := payload.validate()
+			if test.valid && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("accepted")
+			}
+		})
+	}
+}
+
+func TestIngestRejectsInvalidPayloadWhole(t *testing.T) {
+	db := newTestDB(t)
+	payload := testPayload()
+	payload.Parameters = append(payload.Parameters, Parameter{Path: "device.ifa", PresenceCount: 200, TotalRequests: 100})
+
+	if _, err := NewIngester(db, nil).Ingest(context.Background(), payload); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("got %v, want ErrInvalidPayload", err)
+	}
+	// The valid parameter before the bad one is not stored either
+	for _, table := range []string{"agent_flushes", "parameter_presence", "dimension_presence", "parameter_catalog"} {
+		if got := count(t, db, "SELECT COUNT(*) FROM "+table); got != 0 {
+			t.Errorf("got %d rows in %s", got, table)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+
+	maxRequestIDLength = 128
+)
+
+// RequestID takes the request ID from the X-Request-ID header, or generates
+// one, echoes it in the response and attaches a logger carrying it to the
+// request context.
+func RequestID(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set("request_id", requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		ctx := WithLogger(c.Request.Context(), logger.With("request_id", requestID))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// AccessLog writes one line per request once it has been handled, including
+// the user and role set by the auth middleware.
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		// Taken before the auth middleware adds the user to the request
+		// logger, so the attributes below are not duplicated
+		logger := FromContext(c.Request.Context())
+		c.Next()
+
+		status := c.Writer.Status()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", route,
+			"status", status,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", max(c.Writer.Size(), 0),
+			"client_ip", c.ClientIP(),
+			"user_agent", c.Request.UserAgent(),
+		}
+		if userID := c.GetInt("user_id"); userID != 0 {
+			attrs = append(attrs, "user_id", userID, "role", c.GetString("role"))
+		}
+		if keyID := c.GetString("api_key_id"); keyID != "" {
+			attrs = append(attrs, "api_key_id", keyID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		logger.Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery logs panics with the request's logger and responds with 500.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, recovered any) {
+		FromContext(c.Request.Context()).Error("Panic while handling request", "panic", recovered)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Internal server error",
+		})
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	// Only allow characters that are safe to copy into logs and headers
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "openrtb"
+
+// Registry holds the application metrics plus the Go runtime and process
+// collectors. A dedicated registry keeps metrics registered by dependencies
+// on the default registry out of /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "DuckDB query latency, including reading the rows, by report query.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"query"})
+
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "DuckDB queries that failed, by report query.",
+	}, []string{"query"})
+
+	reportCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "report_cache_lookups_total",
+		Help:      "Report cache lookups, by report query and result (hit, miss).",
+	}, []string{"query", "result"})
+
+	retentionRowsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retention_rows_deleted_total",
+		Help:      "Rows deleted by retention policies, by table.",
+	}, []string{"table"})
+
+	backups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backups_total",
+		Help:      "Database backups, by result (success, failure).",
+	}, []string{"result"})
+
+	lastBackup = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backup_last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful database backup.",
+	})
+
+	loginAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_attempts_total",
+		Help:      "Login attempts, by method (password, oidc) and result (success, failure, blocked).",
+	}, []string{"method", "result"})
+
+	rateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiter, by policy.",
+	}, []string{"policy"})
+
+	agentFlushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ingest_agent_flushes_total",
+		Help:      "Edge agent flushes received, by result (stored, replaced, rejected, failure).",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		queryDuration,
+		queryErrors,
+		reportCacheLookups,
+		retentionRowsDeleted,
+		backups,
+		lastBackup,
+		loginAttempts,
+		rateLimitRejections,
+		agentFlushes,
+	)
+}
+
+// Middleware records the request count and latency of every request. The
+// route template is used rather than the path so IDs in URLs do not
+// create a series per value.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format. When token is
+// set, scrapers must send it as a bearer token.
+func Handler(token string) gin.HandlerFunc {
+	metricsHandler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+
+	return func(c *gin.Context) {
+		if token != "" {
+			presented := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+				c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Metrics token required",
+				})
+				return
+			}
+		}
+		metricsHandler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// QueryTimer starts timing a report query; call ObserveDuration on the
+// result once the rows have been read.
+func QueryTimer(query string) *prometheus.Timer {
+	return prometheus.NewTimer(queryDuration.WithLabelValues(query))
+}
+
+func QueryFailed(query string) {
+	queryErrors.WithLabelValues(query).Inc()
+}
+
+func ReportCacheLookup(query, result string) {
+	reportCacheLookups.WithLabelValues(query, result).Inc()
+}
+
+func RetentionRowsDeleted(table string, rows int64) {
+	retentionRowsDeleted.WithLabelValues(table).Add(float64(rows))
+}
+
+func BackupFinished(ok bool) {
+	if !ok {
+		backups.WithLabelValues("failure").Inc()
+		return
+	}
+	backups.WithLabelValues("success").Inc()
+	lastBackup.SetToCurrentTime()
+}
+
+func LoginAttempt(method, result string) {
+	loginAttempts.WithLabelValues(method, result).Inc()
+}
+
+func RateLimitRejected(policy string) {
+	rateLimitRejections.WithLabelValues(policy).Inc()
+}
+
+func AgentFlushReceived(result string) {
+	agentFlushes.WithLabelValues(result).Inc()
+}
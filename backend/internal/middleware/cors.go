@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CORSConfig struct {
+	// AllowedOrigins are exact origins such as https://app.example.com, or
+	// wildcard subdomain patterns such as https://*.example.com.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// originPattern matches an origin by scheme, host and port. A leading "*."
+// in the host matches any subdomain, but not the bare domain itself.
+type originPattern struct {
+	scheme   string
+	host     string
+	port     string
+	wildcard bool
+}
+
+type cors struct {
+	origins          []originPattern
+	methods          map[string]bool
+	headers          map[string]bool
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+// CORS only grants cross-origin access to the configured origins. Requests
+// from any other origin are rejected with 403, and preflights are checked
+// against the allowed methods and headers.
+func CORS(cfg CORSConfig) (gin.HandlerFunc, error) {
+	policy := &cors{
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		allowMethods:     strings.Join(cfg.AllowedMethods, ", "),
+		allowHeaders:     strings.Join(cfg.AllowedHeaders, ", "),
+		exposeHeaders:    strings.Join(cfg.ExposedHeaders, ", "),
+		allowCredentials: cfg.AllowCredentials,
+		maxAge:           strconv.Itoa(int(cfg.MaxAge.Seconds())),
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		pattern, err := parseOriginPattern(origin)
+		if err != nil {
+			return nil, err
+		}
+		policy.origins = append(policy.origins, pattern)
+	}
+	for _, method := range cfg.AllowedMethods {
+		policy.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range cfg.AllowedHeaders {
+		policy.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	return policy.handle, nil
+}
+
+func (p *cors) handle(c *gin.Context) {
+	// Responses differ per origin, so shared caches must key on it
+	c.Writer.Header().Add("Vary", "Origin")
+
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		// Not a cross-origin browser request
+		c.Next()
+		return
+	}
+
+	preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+	if preflight {
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	if !p.originAllowed(origin) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "Origin not allowed",
+		})
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", origin)
+	if p.allowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if p.exposeHeaders != "" {
+			c.Header("Access-Control-Expose-Headers", p.exposeHeaders)
+		}
+		c.Next()
+		return
+	}
+
+	if !p.methods[strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))] {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "Method not allowed by CORS policy",
+		})
+		return
+	}
+	for _, header := range strings.Split(c.GetHeader("Access-Control-Request-Headers"), ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !p.headers[http.CanonicalHeaderKey(header)] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Header " + header + " not allowed by CORS policy",
+			})
+			return
+		}
+	}
+
+	c.Header("Access-Control-Allow-Methods", p.allowMethods)
+	c.Header("Access-Control-Allow-Headers", p.allowHeaders)
+	c.Header("Access-Control-Max-Age", p.maxAge)
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+func (p *cors) originAllowed(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+
+	for _, pattern := range p.origins {
+		if pattern.scheme != scheme || pattern.port != port {
+			continue
+		}
+		if pattern.wildcard {
+			if strings.HasSuffix(host, "."+pattern.host) {
+				return true
+			}
+		} else if host == pattern.host {
+			return true
+		}
+	}
+	return false
+}
+
+func parseOriginPattern(origin string) (originPattern, error) {
+	if origin == "*" {
+		return originPattern{}, fmt.Errorf("CORS origin %q is not allowed, list each origin explicitly", origin)
+	}
+
+	var pattern originPattern
+	raw := strings.TrimSuffix(origin, "/")
+	if scheme, rest, ok := strings.Cut(raw, "://*."); ok {
+		pattern.wildcard = true
+		raw = scheme + "://" + rest
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return originPattern{}, fmt.Errorf("invalid CORS origin %q, expected scheme://host[:port]", origin)
+	}
+
+	pattern.scheme = strings.ToLower(u.Scheme)
+	pattern.host = strings.ToLower(u.Hostname())
+	pattern.port = u.Port()
+	return pattern, nil
+}
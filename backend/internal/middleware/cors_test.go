@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newCORSRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	handler, err := CORS(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org", "http://localhost:3000"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		ExposedHeaders:   []string{"ETag"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(handler)
+	router.Any("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestCORSOrigins(t *testing.T) {
+	router := newCORSRouter(t)
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"https://eu.example.org", true},
+		{"https://a.b.example.org", true},
+		{"http://localhost:3000", true},
+		{"https://example.org", false},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://app.example.com.evil.test", false},
+		{"https://evilexample.org", false},
+		{"http://localhost:3001", false},
+		{"null", false},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Origin", test.origin)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			allowOrigin := recorder.Header().Get("Access-Control-Allow-Origin")
+			if !test.allowed {
+				if recorder.Code != http.StatusForbidden || allowOrigin != "" {
+					t.Errorf("got %d with Access-Control-Allow-Origin %q, want 403 without it", recorder.Code, allowOrigin)
+				}
+				return
+			}
+			if recorder.Code != http.StatusOK || allowOrigin != test.origin {
+				t.Errorf("got %d with Access-Control-Allow-Origin %q", recorder.Code, allowOrigin)
+			}
+			if recorder.Header().Get("Access-Control-Allow-Credentials") != "true" || recorder.Header().Get("Access-Control-Expose-Headers") != "ETag" {
+				t.Errorf("got headers %v", recorder.Header())
+			}
+		})
+	}
+}
+
+func TestCORSWithoutOrigin(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	newCORSRouter(t).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("same-origin request got %d with %v", recorder.Code, recorder.Header())
+	}
+	if recorder.Header().Get("Vary") != "Origin" {
+		t.Errorf("got Vary %q, want Origin", recorder.Header().Get("Vary"))
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	router := newCORSRouter(t)
+
+	tests := []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+		status  int
+	}{
+		{"allowed", "https://app.example.com", "POST", "content-type, authorization", http.StatusNoContent},
+		{"no request headers", "https://app.example.com", "GET", "", http.StatusNoContent},
+		{"method not allowed", "https://app.example.com", "DELETE", "", http.StatusForbidden},
+		{"header not allowed", "https://app.example.com", "POST", "X-Custom", http.StatusForbidden},
+		{"origin not allowed", "https://evil.test", "POST", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodOptions, "/", nil)
+			request.Header.Set("Origin", test.origin)
+			request.Header.Set("Access-Control-Request-Method", test.method)
+			if test.headers != "" {
+				request.Header.Set("Access-Control-Request-Headers", test.headers)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("got %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if test.status != http.StatusNoContent {
+				return
+			}
+			header := recorder.Header()
+			if header.Get("Access-Control-Allow-Methods") != "GET, POST" ||
+				header.Get("Access-Control-Allow-Headers") != "Authorization, Content-Type" ||
+				header.Get("Access-Control-Max-Age") != "3600" {
+				t.Errorf("got headers %v", header)
+			}
+			if vary := header.Values("Vary"); len(vary) != 3 {
+				t.Errorf("got Vary %v, want Origin and the preflight request headers", vary)
+			}
+		})
+	}
+}
+
+func TestCORSRejectsInvalidOrigins(t *testing.T) {
+	for _, origin := range []string{"*", "app.example.com", "ftp://app.example.com", "https://app.example.com/path", "https://user@app.example.com"} {
+		if _, err := CORS(CORSConfig{AllowedOrigins: []string{origin}}); err == nil {
+			t.Errorf("accepted %q", origin)
+		}
+	}
+}
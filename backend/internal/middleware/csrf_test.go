@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CSRF(CSRFConfig{
+		CookieName:     "csrf_token",
+		HeaderName:     "X-CSRF-Token",
+		SessionCookies: []string{"access_token", "refresh_token"},
+		ExemptPaths:    []string{"/login"},
+	}))
+	router.Any("/*path", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	session := map[string]string{"access_token": "jwt", "csrf_token": "token"}
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		cookies map[string]string
+		headers map[string]string
+		status  int
+	}{
+		{"cookie session with the token", "POST", "/", session, map[string]string{"X-CSRF-Token": "token"}, http.StatusOK},
+		{"cookie session without the header", "POST", "/", session, nil, http.StatusForbidden},
+		{"cookie session with another token", "DELETE", "/", session, map[string]string{"X-CSRF-Token": "other"}, http.StatusForbidden},
+		{"refresh cookie only", "POST", "/", map[string]string{"refresh_token": "jwt"}, map[string]string{"X-CSRF-Token": ""}, http.StatusForbidden},
+		{"header without the cookie", "PUT", "/", map[string]string{"access_token": "jwt"}, map[string]string{"X-CSRF-Token": "token"}, http.StatusForbidden},
+		{"safe method", "GET", "/", session, nil, http.StatusOK},
+		{"no session cookie", "POST", "/", nil, nil, http.StatusOK},
+		{"exempt path", "POST", "/login", session, nil, http.StatusOK},
+		{"Bearer header", "POST", "/", session, map[string]string{"Authorization": "Bearer jwt"}, http.StatusOK},
+		{"ApiKey header", "POST", "/", session, map[string]string{"Authorization": "ApiKey key"}, http.StatusOK},
+		{"X-API-Key header", "POST", "/", session, map[string]string{"X-API-Key": "key"}, http.StatusOK},
+		// The auth middleware ignores the cookie then, but the check must
+		// not rely on it
+		{"other Authorization scheme", "POST", "/", session, map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, nil)
+			for name, value := range test.cookies {
+				request.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("got %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"openrtb-insights/internal/logging"
+	"openrtb-insights/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFunc identifies the client a request is counted against.
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests against the client IP.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByClient counts requests against the API key or user set by the auth
+// middleware, falling back to the client IP for anonymous requests.
+func ByClient(c *gin.Context) string {
+	if keyID := c.GetString("api_key_id"); keyID != "" {
+		return "key:" + keyID
+	}
+	if userID := c.GetInt("user_id"); userID != 0 {
+		return fmt.Sprintf("user:%d", userID)
+	}
+	return ByIP(c)
+}
+
+// Middleware enforces limit per client for the named policy and reports
+// the budget in X-RateLimit-* headers. Each policy has its own buckets, so
+// a client exhausting one route group is not throttled on another.
+func Middleware(store Store, policy string, limit Limit, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit.Requests <= 0 {
+			c.Next()
+			return
+		}
+
+		result, err := store.Allow(policy+":"+key(c), limit)
+		if err != nil {
+			// Fail open: a broken limiter backend must not take the API down
+			logging.FromContext(c.Request.Context()).Error("Rate limiter unavailable", "policy", policy, "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+
+		if !result.Allowed {
+			metrics.RateLimitRejected(policy)
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "Rate limit exceeded",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
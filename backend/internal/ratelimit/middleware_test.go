@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	store := NewMemoryStore(time.Minute)
+	t.Cleanup(store.Close)
+	return store
+}
+
+// newTestRouter serves GET /, identifying the caller from the X-Test-Key
+// header the way the auth middleware would.
+func newTestRouter(store Store, limit Limit, key KeyFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if keyID := c.GetHeader("X-Test-Key"); keyID != "" {
+			c.Set("api_key_id", keyID)
+		}
+		c.Next()
+	}, Middleware(store, "test", limit, key))
+	router.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+func get(router *gin.Engine, remoteAddr, keyID string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = remoteAddr
+	if keyID != "" {
+		request.Header.Set("X-Test-Key", keyID)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestByClientSeparatesKeysBehindOneIP(t *testing.T) {
+	router := newTestRouter(newTestStore(t), Limit{Requests: 2, Period: time.Minute}, ByClient)
+
+	for i := 0; i < 2; i++ {
+		if got := get(router, "203.0.113.7:1234", "key-a").Code; got != http.StatusNoContent {
+			t.Fatalf("request %d of key-a returned %d", i+1, got)
+		}
+	}
+	if got := get(router, "203.0.113.7:1234", "key-a").Code; got != http.StatusTooManyRequests {
+		t.Errorf("key-a over its budget returned %d, want %d", got, http.StatusTooManyRequests)
+	}
+
+	// Another key from the same address has a bucket of its own
+	if got := get(router, "203.0.113.7:1234", "key-b").Code; got != http.StatusNoContent {
+		t.Errorf("key-b returned %d after key-a ran out, want %d", got, http.StatusNoContent)
+	}
+}
+
+func TestMiddlewareRejectsWithRetryAfter(t *testing.T) {
+	router := newTestRouter(newTestStore(t), Limit{Requests: 2, Period: time.Minute}, ByIP)
+
+	first := get(router, "203.0.113.7:1234", "")
+	if first.Code != http.StatusNoContent {
+		t.Fatalf("got %d", first.Code)
+	}
+	header := first.Header()
+	if header.Get("X-RateLimit-Limit") != "2" || header.Get("X-RateLimit-Remaining") != "1" || header.Get("X-RateLimit-Reset") != "30" {
+		t.Errorf("got headers limit %s, remaining %s, reset %s", header.Get("X-RateLimit-Limit"),
+			header.Get("X-RateLimit-Remaining"), header.Get("X-RateLimit-Reset"))
+	}
+	if header.Get("Retry-After") != "" {
+		t.Errorf("allowed request has Retry-After %s", header.Get("Retry-After"))
+	}
+
+	get(router, "203.0.113.7:1234", "")
+	rejected := get(router, "203.0.113.7:1234", "")
+	if rejected.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want %d", rejected.Code, http.StatusTooManyRequests)
+	}
+	if got := rejected.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("got Retry-After %q, want 30", got)
+	}
+	if got := rejected.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("got X-RateLimit-Remaining %q, want 0", got)
+	}
+	if body := rejected.Body.String(); body != `{"error":"Rate limit exceeded"}` {
+		t.Errorf("got body %s", body)
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    KeyFunc
+		first  [2]string
+		second [2]string
+		shared bool
+	}{
+		{"ByIP shares an address between keys", ByIP, [2]string{"203.0.113.7:1", "key-a"}, [2]string{"203.0.113.7:2", "key-b"}, true},
+		{"ByIP separates addresses", ByIP, [2]string{"203.0.113.7:1", ""}, [2]string{"203.0.113.8:1", ""}, false},
+		{"ByClient separates keys", ByClient, [2]string{"203.0.113.7:1", "key-a"}, [2]string{"203.0.113.7:2", "key-b"}, false},
+		{"ByClient falls back to the address", ByClient, [2]string{"203.0.113.7:1", ""}, [2]string{"203.0.113.7:2", ""}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := newTestRouter(newTestStore(t), Limit{Requests: 1, Period: time.Minute}, test.key)
+
+			get(router, test.first[0], test.first[1])
+			shared := get(router, test.second[0], test.second[1]).Code == http.StatusTooManyRequests
+			if shared != test.shared {
+				t.Errorf("got a shared bucket %t, want %t", shared, test.shared)
+			}
+		})
+	}
+}
+
+func TestByClientUsesUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	c.Set("user_id", 7)
+	if got := ByClient(c); got != "user:7" {
+		t.Errorf("got %s for a user, want user:7", got)
+	}
+	c.Set("api_key_id", "abc")
+	if got := ByClient(c); got != "key:abc" {
+		t.Errorf("got %s for an API key, want key:abc", got)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Allow(string, Limit) (Result, error) {
+	return Result{}, errors.New("unavailable")
+}
+
+func TestMiddlewareFailsOpen(t *testing.T) {
+	router := newTestRouter(failingStore{}, Limit{Requests: 1, Period: time.Minute}, ByIP)
+	if got := get(router, "203.0.113.7:1234", "").Code; got != http.StatusNoContent {
+		t.Errorf("got %d with a broken store, want %d", got, http.StatusNoContent)
+	}
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	router := newTestRouter(newTestStore(t), Limit{Requests: 0, Period: time.Minute}, ByIP)
+	for i := 0; i < 3; i++ {
+		recorder := get(router, "203.0.113.7:1234", "")
+		if recorder.Code != http.StatusNoContent || recorder.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("request %d returned %d with limit %q", i+1, recorder.Code, recorder.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket budget of Requests per Period. The bucket holds
+// at most Requests tokens, so a client may burst up to the full budget.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Result is the outcome of taking one token from a bucket.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+// Store keeps bucket state per client key. The in-memory store is enough
+// for a single instance; a shared implementation (e.g. Redis) can be
+// plugged in when running several replicas behind a load balancer.
+type Store interface {
+	Allow(key string, limit Limit) (Result, error)
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// MemoryStore is a process-local Store. Buckets idle for longer than the
+// idle TTL are evicted so one-off clients do not accumulate forever.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	idleTTL time.Duration
+	stop    chan struct{}
+}
+
+func NewMemoryStore(idleTTL time.Duration) *MemoryStore {
+	store := &MemoryStore{
+		buckets: make(map[string]*bucket),
+		idleTTL: idleTTL,
+		stop:    make(chan struct{}),
+	}
+	go store.evictLoop()
+	return store
+}
+
+func (s *MemoryStore) Allow(key string, limit Limit) (Result, error) {
+	now := time.Now()
+	capacity := float64(limit.Requests)
+	perToken := limit.Period / time.Duration(max(limit.Requests, 1))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: capacity, lastSeen: now}
+		s.buckets[key] = b
+	}
+
+	// Refill for the time elapsed since the last request
+	elapsed := now.Sub(b.lastSeen)
+	b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/float64(perToken))
+	b.lastSeen = now
+
+	result := Result{Limit: limit.Requests}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	result.Remaining = int(b.tokens)
+	result.ResetAfter = time.Duration((capacity - b.tokens) * float64(perToken))
+	return result, nil
+}
+
+// Close stops the eviction loop.
+func (s *MemoryStore) Close() {
+	close(s.stop)
+}
+
+func (s *MemoryStore) evictLoop() {
+	ticker := time.NewTicker(s.idleTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.evictIdle()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) evictIdle() {
+	cutoff := time.Now().Add(-s.idleTTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, b := range s.buckets {
+		if b.lastSeen.Before(cutoff) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreBurst(t *testing.T) {
+	store := newTestStore(t)
+	limit := Limit{Requests: 3, Period: time.Minute}
+
+	for i := 3; i > 0; i-- {
+		result, err := store.Allow("client", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != i-1 || result.Limit != 3 {
+			t.Fatalf("got %+v, want allowed with %d remaining", result, i-1)
+		}
+	}
+
+	result, err := store.Allow("client", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.Remaining != 0 {
+		t.Errorf("got %+v after the burst, want rejected", result)
+	}
+	// One token comes back every 20s
+	if result.RetryAfter <= 0 || result.RetryAfter > 20*time.Second {
+		t.Errorf("got RetryAfter %s, want up to 20s", result.RetryAfter)
+	}
+	if result.ResetAfter <= 40*time.Second || result.ResetAfter > time.Minute {
+		t.Errorf("got ResetAfter %s, want about a minute", result.ResetAfter)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	store := newTestStore(t)
+	limit := Limit{Requests: 2, Period: 100 * time.Millisecond}
+
+	for i := 0; i < 2; i++ {
+		if result, _ := store.Allow("client", limit); !result.Allowed {
+			t.Fatalf("request %d rejected", i+1)
+		}
+	}
+	if result, _ := store.Allow("client", limit); result.Allowed {
+		t.Fatalf("request over the burst allowed")
+	}
+
+	// A token refills every 50ms
+	time.Sleep(60 * time.Millisecond)
+	if result, _ := store.Allow("client", limit); !result.Allowed {
+		t.Errorf("request after a refill interval rejected")
+	}
+	if result, _ := store.Allow("client", limit); result.Allowed {
+		t.Errorf("second request after one refill interval allowed")
+	}
+
+	// The bucket never holds more than the budget
+	time.Sleep(300 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if result, _ := store.Allow("client", limit); !result.Allowed {
+			t.Errorf("request %d after an idle period rejected", i+1)
+		}
+	}
+	if result, _ := store.Allow("client", limit); result.Allowed {
+		t.Errorf("idle period refilled beyond the budget")
+	}
+}
+
+func TestMemoryStoreSeparatesKeys(t *testing.T) {
+	store := newTestStore(t)
+	limit := Limit{Requests: 1, Period: time.Minute}
+
+	if result, _ := store.Allow("a", limit); !result.Allowed {
+		t.Fatalf("first request of a rejected")
+	}
+	if result, _ := store.Allow("a", limit); result.Allowed {
+		t.Errorf("second request of a allowed")
+	}
+	if result, _ := store.Allow("b", limit); !result.Allowed {
+		t.Errorf("b throttled by a's requests")
+	}
+}
+
+func TestMemoryStoreEvictsIdleBuckets(t *testing.T) {
+	store := newTestStore(t)
+	limit := Limit{Requests: 1, Period: time.Minute}
+	for _, key := range []string{"idle", "active"} {
+		if _, err := store.Allow(key, limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.mu.Lock()
+	store.buckets["idle"].lastSeen = time.Now().Add(-2 * time.Minute)
+	store.mu.Unlock()
+	store.evictIdle()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.buckets["idle"]; ok {
+		t.Errorf("idle bucket kept")
+	}
+	if _, ok := store.buckets["active"]; !ok {
+		t.Errorf("active bucket evicted")
+	}
+}
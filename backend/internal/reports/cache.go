@@ -0,0 +1,138 @@
+package reports
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"openrtb-insights/internal/metrics"
+)
+
+// Cache keeps report results in memory for a short time, so dashboards
+// refreshing every 30 seconds do not rerun the same DuckDB scans. It is
+// cleared whenever new data is loaded. A nil Cache or a zero TTL disables
+// caching.
+type Cache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]cacheEntry
+	// generation changes on every invalidation, so a result loaded before
+	// one is not stored after it
+	generation uint64
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func NewCache(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]cacheEntry),
+	}
+}
+
+// Invalidate drops every cached result.
+func (c *Cache) Invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+	c.generation++
+}
+
+func (c *Cache) enabled() bool {
+	return c != nil && c.ttl > 0 && c.maxEntries > 0
+}
+
+func (c *Cache) get(key string) (interface{}, uint64, bool) {
+	if !c.enabled() {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	return entry.value, c.generation, ok
+}
+
+// set stores value unless the cache was invalidated since generation was
+// read.
+func (c *Cache) set(key string, value interface{}, generation uint64) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	now := time.Now()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.ttl)}
+}
+
+// evict makes room for one entry, dropping expired entries or, if there
+// are none, the one closest to expiry.
+func (c *Cache) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.expires.Before(oldest) {
+			oldestKey, oldest = key, entry.expires
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, oldestKey)
+	}
+}
+
+// Result is a report together with when it was loaded, which clients use
+// for conditional requests.
+type Result[T any] struct {
+	Data         T
+	LastModified time.Time
+}
+
+func newResult[T any](data T) *Result[T] {
+	return &Result[T]{
+		Data:         data,
+		LastModified: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// cached returns the result stored under key, or loads and stores it.
+func cached[T any](ctx context.Context, s *Service, query, key string, load func(context.Context) (T, error)) (*Result[T], error) {
+	value, generation, ok := s.cache.get(key)
+	if ok {
+		metrics.ReportCacheLookup(query, "hit")
+		return value.(*Result[T]), nil
+	}
+	if s.cache.enabled() {
+		metrics.ReportCacheLookup(query, "miss")
+	}
+
+	data, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := newResult(data)
+	s.cache.set(key, result, generation)
+	return result, nil
+}
@@ -0,0 +1,204 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DuckDBStore reads reports from the DuckDB tables: daily and hourly rows
+// from their own tables, weekly and monthly rows from the rollup tables.
+type DuckDBStore struct {
+	db *sql.DB
+}
+
+func NewDuckDBStore(db *sql.DB) *DuckDBStore {
+	return &DuckDBStore{db: db}
+}
+
+func (s *DuckDBStore) System() string {
+	return "duckdb"
+}
+
+// platformStatsColumns follows the date column, which differs between the
+// daily and hourly tables.
+const platformStatsColumns = `
+	total_requests, multi_impression, big_guidance, addressable,
+	compliance_strings, deals, tmax, invalid_requests,
+	CAST(timeout_rate AS DOUBLE) AS timeout_rate,
+	CAST(bid_rate AS DOUBLE) AS bid_rate, created_at`
+
+// inRange filters rows to the buckets of a range, taking the first and
+// last bucket starts as parameters.
+func inRange(granularity Granularity) string {
+	column, typ := granularity.column()
+	return fmt.Sprintf("%[1]s BETWEEN CAST(? AS %[2]s) AND CAST(? AS %[2]s)", column, typ)
+}
+
+func (s *DuckDBStore) GetPlatformStats(ctx context.Context, r Range, granularity Granularity) ([]PlatformStats, error) {
+	query := fmt.Sprintf(`
+		SELECT %[2]s, %[3]s
+		FROM %[1]s
+		WHERE %[4]s
+		ORDER BY date ASC
+	`, granularity.table("platform_stats"), granularity.selectDate(), platformStatsColumns, inRange(granularity))
+
+	from, to := granularity.span(r)
+	rows, err := s.db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []PlatformStats
+	for rows.Next() {
+		var stat PlatformStats
+		if err := scanPlatformStats(rows, &stat); err != nil {
+			return nil, fmt.Errorf("failed to scan platform stat: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read platform stats: %w", err)
+	}
+	return stats, nil
+}
+
+func (s *DuckDBStore) GetContentHealth(ctx context.Context, platform string, r Range, granularity Granularity) ([]ContentHealth, error) {
+	query := fmt.Sprintf(`
+		SELECT %[2]s, platform, total_requests, album,
+		       artist, cat, context, data, embeddable, episode, genre, id, kwarray, keywords, length, language,
+		       livestream, season, series, title, url, videoquality, created_at
+		FROM %[1]s
+		WHERE platform = ? AND %[3]s
+		ORDER BY date ASC
+	`, granularity.table("content_health"), granularity.selectDate(), inRange(granularity))
+
+	from, to := granularity.span(r)
+	rows, err := s.db.QueryContext(ctx, query, platform, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var health []ContentHealth
+	for rows.Next() {
+		var h ContentHealth
+		err := rows.Scan(
+			&h.Date, &h.Platform, &h.TotalRequests, &h.Album, &h.Artist, &h.Cat,
+			&h.Context, &h.Data, &h.Embeddable, &h.Episode, &h.Genre, &h.ID,
+			&h.Kwarray, &h.Keywords, &h.Length, &h.Language, &h.Livestream,
+			&h.Season, &h.Series, &h.Title, &h.URL, &h.VideoQuality, &h.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan content health: %w", err)
+		}
+		health = append(health, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read content health: %w", err)
+	}
+	return health, nil
+}
+
+func (s *DuckDBStore) GetVideoHealth(ctx context.Context, platform string, r Range, granularity Granularity) ([]VideoHealth, error) {
+	query := fmt.Sprintf(`
+		SELECT %[2]s, platform,
+		       CAST(percent_ctv AS DOUBLE) AS percent_ctv, api, boxing_allowed,
+		       delivery, h, linearity,
+		       max_bitrate, max_duration, mimes, min_bitrate, min_cpm_per_sec, min_duration,
+		       placement, play_backend, pod_dur, pod_id, pos, protocols, rqd_durs, skip,
+		       skip_after, skip_min, slot_in_pod, start_delay, w, max_seq, companion_ad,
+		       companion_type, protocol, placement_type, created_at
+		FROM %[1]s
+		WHERE platform = ? AND %[3]s
+		ORDER BY date ASC
+	`, granularity.table("video_health"), granularity.selectDate(), inRange(granularity))
+
+	from, to := granularity.span(r)
+	rows, err := s.db.QueryContext(ctx, query, platform, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var health []VideoHealth
+	for rows.Next() {
+		var h VideoHealth
+		err := rows.Scan(
+			&h.Date, &h.Platform, &h.PercentCTV, &h.API, &h.BoxingAllowed, &h.Delivery,
+			&h.H, &h.Linearity, &h.MaxBitrate, &h.MaxDuration, &h.Mimes, &h.MinBitrate,
+			&h.MinCPMPerSec, &h.MinDuration, &h.Placement, &h.PlayBackend, &h.PodDur,
+			&h.PodID, &h.Pos, &h.Protocols, &h.RqdDurs, &h.Skip, &h.SkipAfter,
+			&h.SkipMin, &h.SlotInPod, &h.StartDelay, &h.W, &h.MaxSeq, &h.CompanionAd,
+			&h.CompanionType, &h.Protocol, &h.PlacementType, &h.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan video health: %w", err)
+		}
+		health = append(health, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read video health: %w", err)
+	}
+	return health, nil
+}
+
+func (s *DuckDBStore) GetLatestPlatformStats(ctx context.Context) (*PlatformStats, error) {
+	query := `SELECT ` + Daily.selectDate() + `, ` + platformStatsColumns + ` FROM platform_stats ORDER BY date DESC LIMIT 1`
+
+	var stat PlatformStats
+	if err := scanPlatformStats(s.db.QueryRowContext(ctx, query), &stat); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &stat, nil
+}
+
+func (s *DuckDBStore) GetParameterPresence(ctx context.Context, path string, from, to time.Time) ([]PresenceCount, error) {
+	// SUM over BIGINT is a HUGEINT, which does not scan into int64
+	query := `
+		SELECT '' AS dimension_key,
+		       CAST(SUM(presence_count) AS BIGINT), CAST(SUM(total_requests) AS BIGINT)
+		FROM parameter_presence
+		WHERE parameter_path = ? AND window_start >= CAST(? AS TIMESTAMP) AND window_start < CAST(? AS TIMESTAMP)
+		HAVING COUNT(*) > 0
+		UNION ALL
+		SELECT dimension_key,
+		       CAST(SUM(presence_count) AS BIGINT), CAST(SUM(total_requests) AS BIGINT)
+		FROM dimension_presence
+		WHERE parameter_path = ? AND window_start >= CAST(? AS TIMESTAMP) AND window_start < CAST(? AS TIMESTAMP)
+		GROUP BY dimension_key
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, path, from, to, path, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []PresenceCount
+	for rows.Next() {
+		var count PresenceCount
+		if err := rows.Scan(&count.DimensionKey, &count.PresenceCount, &count.TotalRequests); err != nil {
+			return nil, fmt.Errorf("failed to scan parameter presence: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read parameter presence: %w", err)
+	}
+	return counts, nil
+}
+
+func scanPlatformStats(row interface{ Scan(...any) error }, stat *PlatformStats) error {
+	return row.Scan(
+		&stat.Date, &stat.TotalRequests, &stat.MultiImpression, &stat.BigGuidance,
+		&stat.Addressable, &stat.ComplianceStrings, &stat.Deals, &stat.Tmax,
+		&stat.InvalidRequests, &stat.TimeoutRate, &stat.BidRate, &stat.CreatedAt,
+	)
+}
@@ -0,0 +1,124 @@
+package reports
+
+import (
+	"time"
+
+	"openrtb-insights/internal/rollup"
+)
+
+// Granularity is the bucket size of report rows.
+type Granularity string
+
+const (
+	Hourly  Granularity = "hour"
+	Daily   Granularity = "day"
+	Weekly  Granularity = "week"
+	Monthly Granularity = "month"
+)
+
+// ParseGranularity reads the granularity query parameter, defaulting to
+// daily rows.
+func ParseGranularity(value string) (Granularity, bool) {
+	switch g := Granularity(value); g {
+	case "":
+		return Daily, true
+	case Hourly, Daily, Weekly, Monthly:
+		return g, true
+	}
+	return "", false
+}
+
+// table returns the coarsest table whose rows match the granularity, so
+// weekly and monthly reports read the rollups rather than aggregating
+// daily rows on every request.
+func (g Granularity) table(daily string) string {
+	switch g {
+	case Hourly:
+		return rollup.HourlyTable(daily)
+	case Weekly:
+		return rollup.Table(daily, rollup.Weekly)
+	case Monthly:
+		return rollup.Table(daily, rollup.Monthly)
+	}
+	return daily
+}
+
+// column returns the table column dating rows, and its SQL type.
+func (g Granularity) column() (string, string) {
+	if g == Hourly {
+		return "hour", "TIMESTAMP"
+	}
+	return "date", "DATE"
+}
+
+// bucketStart returns the start of the bucket holding t in t's time zone,
+// matching DuckDB's date_trunc: weeks start on Monday.
+func (g Granularity) bucketStart(t time.Time) time.Time {
+	if g == Hourly {
+		return t.Truncate(time.Hour)
+	}
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	switch g {
+	case Weekly:
+		day = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case Monthly:
+		day = day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// next returns the start of the bucket after the one starting at t.
+func (g Granularity) next(t time.Time) time.Time {
+	switch g {
+	case Hourly:
+		return t.Add(time.Hour)
+	case Weekly:
+		return t.AddDate(0, 0, 7)
+	case Monthly:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// span returns the starts of the first and last UTC buckets in the range,
+// so a report always covers whole buckets.
+func (g Granularity) span(r Range) (time.Time, time.Time) {
+	return g.bucketStart(r.Start), g.bucketStart(r.End)
+}
+
+// format dates a row in t's time zone: YYYY-MM-DD, or an RFC3339 hour for
+// hourly rows. In a single zone both compare correctly as strings.
+func (g Granularity) format(t time.Time) string {
+	if g == Hourly {
+		return t.Format(time.RFC3339)
+	}
+	return t.Format(dateLayout)
+}
+
+// bounds returns the first and last bucket of the range as rows date them.
+func (g Granularity) bounds(r Range) (string, string) {
+	from, to := g.span(r)
+	return g.format(from), g.format(to)
+}
+
+// buckets lists the bucket starts in the range, in its time zone; demo
+// data has a row for each bucket.
+func (g Granularity) buckets(r Range) []time.Time {
+	from := g.bucketStart(r.Start.In(r.zone()))
+	to := g.bucketStart(r.End.In(r.zone()))
+
+	var buckets []time.Time
+	for t := from; !t.After(to); t = g.next(t) {
+		buckets = append(buckets, t)
+	}
+	return buckets
+}
+
+// selectDate is the SQL expression reading a row's date as format writes it.
+func (g Granularity) selectDate() string {
+	if g == Hourly {
+		return "strftime(hour, '%Y-%m-%dT%H:%M:%SZ') AS date"
+	}
+	return "CAST(date AS VARCHAR) AS date"
+}
@@ -1,12 +1,32 @@
 package reports
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
+	"openrtb-insights/internal/logging"
+	"openrtb-insights/internal/tracing"
+
 	"github.com/gin-gonic/gin"
 )
 
+// StatusClientClosedRequest is the nginx status for a request the client
+// abandoned before the response was ready.
+const StatusClientClosedRequest = 499
+
+// maxHourlyRange bounds hourly reports, which are meant for looking into
+// incidents rather than trends.
+const maxHourlyRange = 31 * 24 * time.Hour
+
+// maxParameterPathLength matches the parameter_path columns.
+const maxParameterPathLength = 255
+
 type Handler struct {
 	service *Service
 }
@@ -16,54 +36,31 @@ func NewHandler(service *Service) *Handler {
 }
 
 func (h *Handler) GetPlatformStats(c *gin.Context) {
-	startDate := c.Query("start")
-	endDate := c.Query("end")
-
-	// Validate date parameters
-	if startDate == "" || endDate == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "start and end date parameters are required (format: YYYY-MM-DD)",
-		})
-		return
-	}
-
-	// Validate date format
-	if _, err := time.Parse("2006-01-02", startDate); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid start date format. Use YYYY-MM-DD",
-		})
-		return
-	}
-
-	if _, err := time.Parse("2006-01-02", endDate); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid end date format. Use YYYY-MM-DD",
-		})
+	r, granularity, ok := parseRange(c)
+	if !ok {
 		return
 	}
 
-	stats, err := h.service.GetPlatformStats(startDate, endDate)
+	result, err := h.service.GetPlatformStats(c.Request.Context(), r, granularity)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to retrieve platform statistics",
-		})
+		queryFailed(c, err, "Failed to retrieve platform statistics")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"data":  stats,
-		"count": len(stats),
+	renderResult(c, result.LastModified, gin.H{
+		"data":  result.Data,
+		"count": len(result.Data),
 		"query": gin.H{
-			"startDate": startDate,
-			"endDate":   endDate,
+			"startDate":   granularity.format(r.Start.In(r.Zone)),
+			"endDate":     granularity.format(r.End.In(r.Zone)),
+			"granularity": granularity,
+			"timezone":    r.Zone.String(),
 		},
 	})
 }
 
 func (h *Handler) GetContentHealth(c *gin.Context) {
 	platform := c.Query("platform")
-	startDate := c.Query("start")
-	endDate := c.Query("end")
 
 	// Validate required parameters
 	if platform == "" {
@@ -73,13 +70,6 @@ func (h *Handler) GetContentHealth(c *gin.Context) {
 		return
 	}
 
-	if startDate == "" || endDate == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "start and end date parameters are required (format: YYYY-MM-DD)",
-		})
-		return
-	}
-
 	// Validate platform
 	if platform != "CTV" && platform != "Audio" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -88,44 +78,32 @@ func (h *Handler) GetContentHealth(c *gin.Context) {
 		return
 	}
 
-	// Validate date format
-	if _, err := time.Parse("2006-01-02", startDate); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid start date format. Use YYYY-MM-DD",
-		})
-		return
-	}
-
-	if _, err := time.Parse("2006-01-02", endDate); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid end date format. Use YYYY-MM-DD",
-		})
+	r, granularity, ok := parseRange(c)
+	if !ok {
 		return
 	}
 
-	health, err := h.service.GetContentHealth(platform, startDate, endDate)
+	result, err := h.service.GetContentHealth(c.Request.Context(), platform, r, granularity)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to retrieve content health data",
-		})
+		queryFailed(c, err, "Failed to retrieve content health data")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"data":  health,
-		"count": len(health),
+	renderResult(c, result.LastModified, gin.H{
+		"data":  result.Data,
+		"count": len(result.Data),
 		"query": gin.H{
-			"platform":  platform,
-			"startDate": startDate,
-			"endDate":   endDate,
+			"platform":    platform,
+			"startDate":   granularity.format(r.Start.In(r.Zone)),
+			"endDate":     granularity.format(r.End.In(r.Zone)),
+			"granularity": granularity,
+			"timezone":    r.Zone.String(),
 		},
 	})
 }
 
 func (h *Handler) GetVideoHealth(c *gin.Context) {
 	platform := c.Query("platform")
-	startDate := c.Query("start")
-	endDate := c.Query("end")
 
 	// Validate required parameters
 	if platform == "" {
@@ -135,13 +113,6 @@ func (h *Handler) GetVideoHealth(c *gin.Context) {
 		return
 	}
 
-	if startDate == "" || endDate == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "start and end date parameters are required (format: YYYY-MM-DD)",
-		})
-		return
-	}
-
 	// Validate platform
 	validPlatforms := map[string]bool{
 		"CTV":     true,
@@ -156,48 +127,272 @@ func (h *Handler) GetVideoHealth(c *gin.Context) {
 		return
 	}
 
-	// Validate date format
-	if _, err := time.Parse("2006-01-02", startDate); err != nil {
+	r, granularity, ok := parseRange(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.service.GetVideoHealth(c.Request.Context(), platform, r, granularity)
+	if err != nil {
+		queryFailed(c, err, "Failed to retrieve video health data")
+		return
+	}
+
+	renderResult(c, result.LastModified, gin.H{
+		"data":  result.Data,
+		"count": len(result.Data),
+		"query": gin.H{
+			"platform":    platform,
+			"startDate":   granularity.format(r.Start.In(r.Zone)),
+			"endDate":     granularity.format(r.End.In(r.Zone)),
+			"granularity": granularity,
+			"timezone":    r.Zone.String(),
+		},
+	})
+}
+
+func (h *Handler) GetDashboard(c *gin.Context) {
+	result, err := h.service.GetDashboardSummary(c.Request.Context())
+	if err != nil {
+		queryFailed(c, err, "Failed to retrieve dashboard data")
+		return
+	}
+
+	renderResult(c, result.LastModified, result.Data)
+}
+
+// GetParameterPresence reports how often a parameter path was present in
+// agent flushes, broken down by dimension. Each filter, e.g.
+// filter=device_type:3, narrows the report to a slice one level down.
+func (h *Handler) GetParameterPresence(c *gin.Context) {
+	path := c.Query("path")
+	if path == "" || len(path) > maxParameterPathLength {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid start date format. Use YYYY-MM-DD",
+			"error": "path parameter is required, e.g. app.content.genre",
 		})
 		return
 	}
 
-	if _, err := time.Parse("2006-01-02", endDate); err != nil {
+	rawFilters := c.QueryArray("filter")
+	if rawFilters == nil {
+		rawFilters = []string{}
+	}
+	if len(rawFilters) > maxPresenceFilters {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid end date format. Use YYYY-MM-DD",
+			"error": "At most two filter parameters, agents track up to three dimensions per key",
 		})
 		return
 	}
+	filters := make([]Dimension, 0, len(rawFilters))
+	for _, raw := range rawFilters {
+		filter, err := ParseDimension(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "filter must be a dimension name:value, e.g. device_type:3",
+			})
+			return
+		}
+		for _, other := range filters {
+			if other.Name == filter.Name {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "Each dimension can be filtered only once",
+				})
+				return
+			}
+		}
+		filters = append(filters, filter)
+	}
 
-	health, err := h.service.GetVideoHealth(platform, startDate, endDate)
+	dimension := c.Query("dimension")
+	for _, filter := range filters {
+		if filter.Name == dimension {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "dimension must not be one of the filtered dimensions",
+			})
+			return
+		}
+	}
+
+	r, ok := parseWindow(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.service.GetParameterPresence(c.Request.Context(), path, r, filters, dimension)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to retrieve video health data",
-		})
+		queryFailed(c, err, "Failed to retrieve parameter presence")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"data":  health,
-		"count": len(health),
+	renderResult(c, result.LastModified, gin.H{
+		"data": result.Data,
 		"query": gin.H{
-			"platform":  platform,
-			"startDate": startDate,
-			"endDate":   endDate,
+			"path":      path,
+			"filters":   rawFilters,
+			"dimension": dimension,
+			"startDate": Hourly.format(r.Start.In(r.Zone)),
+			"endDate":   Hourly.format(r.End.In(r.Zone)),
+			"timezone":  r.Zone.String(),
 		},
 	})
 }
 
-func (h *Handler) GetDashboard(c *gin.Context) {
-	summary, err := h.service.GetDashboardSummary()
+// InvalidateCache drops cached reports, for use after importing data
+// outside the ingest API.
+func (h *Handler) InvalidateCache(c *gin.Context) {
+	h.service.InvalidateCache()
+	logging.FromContext(c.Request.Context()).Info("Report cache invalidated")
+	c.Status(http.StatusNoContent)
+}
+
+// parseRange reads the start, end, granularity and tz query parameters,
+// responding 400 when one is missing or invalid. Without tz, dates are in
+// the user's time zone, or UTC for API keys.
+func parseRange(c *gin.Context) (Range, Granularity, bool) {
+	r, ok := parseWindow(c)
+	if !ok {
+		return Range{}, "", false
+	}
+
+	granularity, ok := ParseGranularity(c.Query("granularity"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "granularity must be one of: hour, day, week, month",
+		})
+		return Range{}, "", false
+	}
+
+	if granularity == Hourly && r.End.Sub(r.Start) > maxHourlyRange {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Hourly reports cover at most 31 days, use a shorter range or granularity=day",
+		})
+		return Range{}, "", false
+	}
+
+	return r, granularity, true
+}
+
+// parseWindow reads the start, end and tz query parameters of parseRange.
+func parseWindow(c *gin.Context) (Range, bool) {
+	startDate := c.Query("start")
+	endDate := c.Query("end")
+
+	if startDate == "" || endDate == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "start and end date parameters are required (format: YYYY-MM-DD or RFC3339)",
+		})
+		return Range{}, false
+	}
+
+	zone, err := parseZone(c.Query("tz"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "tz must be an IANA time zone name, e.g. America/New_York",
+		})
+		return Range{}, false
+	}
+	if c.Query("tz") == "" {
+		if userZone, err := parseZone(c.GetString("timezone")); err == nil {
+			zone = userZone
+		}
+	}
+
+	start, err := parseBound(startDate, false, zone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid start date format. Use YYYY-MM-DD or an RFC3339 timestamp",
+		})
+		return Range{}, false
+	}
+
+	end, err := parseBound(endDate, true, zone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid end date format. Use YYYY-MM-DD or an RFC3339 timestamp",
+		})
+		return Range{}, false
+	}
+
+	return Range{Start: start, End: end, Zone: zone}, true
+}
+
+// queryFailed responds to a failed report query: 400 when the range
+// reaches back further than hourly rows are kept, 504 when the query ran
+// past its timeout, 499 when the client went away, 500 otherwise.
+func queryFailed(c *gin.Context, err error, message string) {
+	logger := logging.FromContext(c.Request.Context())
+	var retentionErr *RetentionError
+	switch {
+	case errors.As(err, &retentionErr):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Hourly reports and reports in time zones other than UTC start at " +
+				retentionErr.Oldest.Format(time.RFC3339) + " at the earliest, use tz=UTC with granularity=day for older dates",
+		})
+	case errors.Is(err, context.DeadlineExceeded):
+		logger.Warn("Report query timed out", "error", err)
+		c.JSON(http.StatusGatewayTimeout, gin.H{
+			"error": "Report query timed out, try a shorter date range",
+		})
+	case errors.Is(err, context.Canceled):
+		logger.Info("Report request cancelled", "error", err)
+		c.JSON(StatusClientClosedRequest, gin.H{
+			"error": "Request cancelled",
+		})
+	default:
+		logger.Error(message, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to retrieve dashboard data",
+			"error": message,
 		})
+	}
+}
+
+// renderResult sends a report with its validators, or 304 Not Modified when
+// the client's copy is still current. Clients must revalidate on every use,
+// since the data changes whenever new stats are loaded. The ETag is a hash
+// of the body as served, so it changes with the query echoed alongside the
+// data too.
+func renderResult(c *gin.Context, lastModified time.Time, body interface{}) {
+	_, span := tracer.Start(c.Request.Context(), "reports.render_json")
+	defer span.End()
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.FromContext(c.Request.Context()).Error("Failed to encode report", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to encode report",
+		})
+		return
+	}
+	sum := sha256.Sum256(encoded)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	c.Header("Cache-Control", "private, no-cache")
+
+	if notModified(c.Request, etag, lastModified) {
+		c.Status(http.StatusNotModified)
 		return
 	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", encoded)
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when no ETags
+// were sent, as RFC 9110 requires.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
 
-	c.JSON(http.StatusOK, summary)
-}
\ No newline at end of file
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !lastModified.After(since)
+	}
+	return false
+}
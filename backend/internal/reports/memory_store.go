@@ -0,0 +1,146 @@
+package reports
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps report rows in memory, so the service can run in tests
+// without a database file. Rows are added per granularity, the way the
+// daily and rollup tables hold them; the store does not roll up itself.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	platform map[Granularity][]PlatformStats
+	content  map[Granularity][]ContentHealth
+	video    map[Granularity][]VideoHealth
+	presence map[string][]windowPresence
+}
+
+// windowPresence is a parameter's counts in one agent flush.
+type windowPresence struct {
+	windowStart time.Time
+	PresenceCount
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		platform: make(map[Granularity][]PlatformStats),
+		content:  make(map[Granularity][]ContentHealth),
+		video:    make(map[Granularity][]VideoHealth),
+		presence: make(map[string][]windowPresence),
+	}
+}
+
+func (m *MemoryStore) System() string {
+	return "memory"
+}
+
+func (m *MemoryStore) AddPlatformStats(granularity Granularity, rows ...PlatformStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.platform[granularity] = append(m.platform[granularity], rows...)
+}
+
+func (m *MemoryStore) AddContentHealth(granularity Granularity, rows ...ContentHealth) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.content[granularity] = append(m.content[granularity], rows...)
+}
+
+func (m *MemoryStore) AddVideoHealth(granularity Granularity, rows ...VideoHealth) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.video[granularity] = append(m.video[granularity], rows...)
+}
+
+// AddParameterPresence adds a parameter's counts from an agent flush
+// starting at windowStart; use an empty DimensionKey for the overall count.
+func (m *MemoryStore) AddParameterPresence(path string, windowStart time.Time, counts ...PresenceCount) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, count := range counts {
+		m.presence[path] = append(m.presence[path], windowPresence{windowStart: windowStart, PresenceCount: count})
+	}
+}
+
+func (m *MemoryStore) GetPlatformStats(_ context.Context, r Range, granularity Granularity) ([]PlatformStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return between(m.platform[granularity], r, granularity,
+		func(s PlatformStats) (string, bool) { return s.Date, true }), nil
+}
+
+func (m *MemoryStore) GetContentHealth(_ context.Context, platform string, r Range, granularity Granularity) ([]ContentHealth, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return between(m.content[granularity], r, granularity,
+		func(h ContentHealth) (string, bool) { return h.Date, h.Platform == platform }), nil
+}
+
+func (m *MemoryStore) GetVideoHealth(_ context.Context, platform string, r Range, granularity Granularity) ([]VideoHealth, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return between(m.video[granularity], r, granularity,
+		func(h VideoHealth) (string, bool) { return h.Date, h.Platform == platform }), nil
+}
+
+func (m *MemoryStore) GetLatestPlatformStats(context.Context) (*PlatformStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var latest *PlatformStats
+	for i, stat := range m.platform[Daily] {
+		if latest == nil || stat.Date > latest.Date {
+			latest = &m.platform[Daily][i]
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	stat := *latest
+	return &stat, nil
+}
+
+func (m *MemoryStore) GetParameterPresence(_ context.Context, path string, from, to time.Time) ([]PresenceCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var counts []PresenceCount
+	index := make(map[string]int)
+	for _, row := range m.presence[path] {
+		if row.windowStart.Before(from) || !row.windowStart.Before(to) {
+			continue
+		}
+		i, ok := index[row.DimensionKey]
+		if !ok {
+			i = len(counts)
+			index[row.DimensionKey] = i
+			counts = append(counts, PresenceCount{DimensionKey: row.DimensionKey})
+		}
+		counts[i].PresenceCount += row.PresenceCount.PresenceCount
+		counts[i].TotalRequests += row.TotalRequests
+	}
+	return counts, nil
+}
+
+// between returns copies of the matching rows in the buckets of the range,
+// oldest first.
+func between[T any](rows []T, r Range, granularity Granularity, match func(T) (string, bool)) []T {
+	from, to := granularity.bounds(r)
+
+	var matched []T
+	for _, row := range rows {
+		date, ok := match(row)
+		if ok && date >= from && date <= to {
+			matched = append(matched, row)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		di, _ := match(matched[i])
+		dj, _ := match(matched[j])
+		return di < dj
+	})
+	return matched
+}
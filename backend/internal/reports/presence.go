@@ -0,0 +1,197 @@
+package reports
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxPresenceFilters is the number of dimensions a presence report can be
+// narrowed to. Agents track keys of up to three dimensions, so a slice of
+// two still has a level below it to break down.
+const maxPresenceFilters = 2
+
+// Dimension is one name:value pair of an agent dimension key, e.g.
+// device_type:3.
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+func (d Dimension) String() string {
+	return d.Name + ":" + d.Value
+}
+
+// ParseDimension reads a name:value pair.
+func ParseDimension(s string) (Dimension, error) {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok || name == "" || strings.Contains(s, "|") {
+		return Dimension{}, fmt.Errorf("invalid dimension %q, expected name:value", s)
+	}
+	return Dimension{Name: name, Value: value}, nil
+}
+
+// parseDimensionKey splits an agent dimension key, name:value pairs joined
+// by |.
+func parseDimensionKey(key string) ([]Dimension, error) {
+	parts := strings.Split(key, "|")
+	dims := make([]Dimension, 0, len(parts))
+	for _, part := range parts {
+		dim, err := ParseDimension(part)
+		if err != nil {
+			return nil, err
+		}
+		dims = append(dims, dim)
+	}
+	return dims, nil
+}
+
+// PresenceCount counts the requests carrying a parameter out of the
+// requests in a dimension key's slice, or out of all requests when
+// DimensionKey is empty.
+type PresenceCount struct {
+	DimensionKey  string
+	PresenceCount int64
+	TotalRequests int64
+}
+
+// PresenceRate is a parameter's presence within a slice of requests. The
+// rate is a percentage, like the other report rates.
+type PresenceRate struct {
+	Key           string  `json:"key"`
+	Dimension     string  `json:"dimension,omitempty"`
+	Value         string  `json:"value,omitempty"`
+	Level         int     `json:"level"`
+	PresenceCount int64   `json:"presenceCount"`
+	TotalRequests int64   `json:"totalRequests"`
+	PresenceRate  float64 `json:"presenceRate"`
+}
+
+// PresenceReport breaks a parameter's presence in the slice selected by
+// the filters down by one more dimension.
+type PresenceReport struct {
+	Path string `json:"path"`
+	// Overall is the selected slice, all requests without filters
+	Overall PresenceRate `json:"overall"`
+	// Breakdown holds the slices one level down, most requests first
+	Breakdown []PresenceRate `json:"breakdown"`
+	// Dimensions names the dimensions the slice can be broken down by
+	Dimensions []string `json:"dimensions"`
+}
+
+// explorePresence builds a report from a parameter's counts. Keys match the
+// filters whatever the order of their dimensions; a dimension name narrows
+// the breakdown to that dimension.
+func explorePresence(path string, counts []PresenceCount, filters []Dimension, dimension string) PresenceReport {
+	report := PresenceReport{
+		Path:       path,
+		Overall:    PresenceRate{Key: joinDimensions(filters), Level: len(filters)},
+		Breakdown:  []PresenceRate{},
+		Dimensions: []string{},
+	}
+	if len(filters) > 0 {
+		last := filters[len(filters)-1]
+		report.Overall.Dimension, report.Overall.Value = last.Name, last.Value
+	}
+
+	breakdown := make(map[Dimension]*PresenceRate)
+	names := make(map[string]bool)
+	for _, count := range counts {
+		if count.DimensionKey == "" {
+			if len(filters) == 0 {
+				report.Overall.add(count)
+			}
+			continue
+		}
+		dims, err := parseDimensionKey(count.DimensionKey)
+		if err != nil {
+			continue
+		}
+		rest, ok := without(dims, filters)
+		if !ok {
+			continue
+		}
+		switch len(rest) {
+		case 0:
+			report.Overall.add(count)
+		case 1:
+			extra := rest[0]
+			names[extra.Name] = true
+			if dimension != "" && extra.Name != dimension {
+				continue
+			}
+			rate, ok := breakdown[extra]
+			if !ok {
+				rate = &PresenceRate{
+					Key:       joinDimensions(append(append([]Dimension{}, filters...), extra)),
+					Dimension: extra.Name,
+					Value:     extra.Value,
+					Level:     len(filters) + 1,
+				}
+				breakdown[extra] = rate
+			}
+			rate.add(count)
+		}
+	}
+
+	report.Overall.finish()
+	for _, rate := range breakdown {
+		rate.finish()
+		report.Breakdown = append(report.Breakdown, *rate)
+	}
+	sort.Slice(report.Breakdown, func(i, j int) bool {
+		a, b := report.Breakdown[i], report.Breakdown[j]
+		if a.TotalRequests != b.TotalRequests {
+			return a.TotalRequests > b.TotalRequests
+		}
+		return a.Key < b.Key
+	})
+	for name := range names {
+		report.Dimensions = append(report.Dimensions, name)
+	}
+	sort.Strings(report.Dimensions)
+	return report
+}
+
+// without returns the dimensions of a key that are not among the filters,
+// and false when the key lacks one of the filters.
+func without(dims, filters []Dimension) ([]Dimension, bool) {
+	rest := make([]Dimension, 0, len(dims))
+	matched := 0
+	for _, dim := range dims {
+		if containsDimension(filters, dim) {
+			matched++
+			continue
+		}
+		rest = append(rest, dim)
+	}
+	return rest, matched == len(filters)
+}
+
+func containsDimension(dims []Dimension, dim Dimension) bool {
+	for _, d := range dims {
+		if d == dim {
+			return true
+		}
+	}
+	return false
+}
+
+func joinDimensions(dims []Dimension) string {
+	parts := make([]string, len(dims))
+	for i, dim := range dims {
+		parts[i] = dim.String()
+	}
+	return strings.Join(parts, "|")
+}
+
+func (r *PresenceRate) add(count PresenceCount) {
+	r.PresenceCount += count.PresenceCount
+	r.TotalRequests += count.TotalRequests
+}
+
+func (r *PresenceRate) finish() {
+	if r.TotalRequests > 0 {
+		r.PresenceRate = round2(float64(r.PresenceCount) / float64(r.TotalRequests) * 100)
+	}
+}
@@ -0,0 +1,223 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"openrtb-insights/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// presenceRows are flushes around March 1st: an hour before it, its first
+// and last hours, and the first hour of March 2nd.
+var presenceRows = []struct {
+	windowStart time.Time
+	presence    int64
+}{
+	{time.Date(2026, 2, 28, 23, 0, 0, 0, time.UTC), 1},
+	{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), 10},
+	{time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC), 100},
+	{time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), 1000},
+}
+
+func newPresenceDuckDBStore(t *testing.T) *DuckDBStore {
+	t.Helper()
+	db, err := database.Connect("", database.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range presenceRows {
+		if _, err := db.Exec(`INSERT INTO parameter_presence VALUES ('edge-1', ?, 'app.content.genre', ?, ?)`,
+			row.windowStart, row.presence, 2*row.presence); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO dimension_presence VALUES ('edge-1', ?, 'app.content.genre', 'device_type:3', 1, ?, ?)`,
+			row.windowStart, row.presence, 2*row.presence); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewDuckDBStore(db)
+}
+
+func newPresenceMemoryStore() *MemoryStore {
+	store := NewMemoryStore()
+	for _, row := range presenceRows {
+		store.AddParameterPresence("app.content.genre", row.windowStart,
+			PresenceCount{PresenceCount: row.presence, TotalRequests: 2 * row.presence},
+			PresenceCount{DimensionKey: "device_type:3", PresenceCount: row.presence, TotalRequests: 2 * row.presence})
+	}
+	return store
+}
+
+func TestParameterPresenceWindow(t *testing.T) {
+	stores := []struct {
+		name  string
+		store Store
+	}{
+		{"memory", newPresenceMemoryStore()},
+		{"duckdb", newPresenceDuckDBStore(t)},
+	}
+	tests := []struct {
+		name     string
+		r        Range
+		presence int64
+	}{
+		// The range ends at the start of the day's last hour, whose
+		// flushes count up to the next day
+		{"day in UTC", dateRange(t, "2026-03-01", "2026-03-01", time.UTC), 110},
+		{"day in Berlin", dateRange(t, "2026-03-01", "2026-03-01", loadZone(t, "Europe/Berlin")), 11},
+		{"single hour", Range{Start: presenceRows[2].windowStart.Truncate(time.Hour), End: presenceRows[2].windowStart.Truncate(time.Hour), Zone: time.UTC}, 100},
+		{"two days", dateRange(t, "2026-03-01", "2026-03-02", time.UTC), 1110},
+	}
+	for _, store := range stores {
+		for _, test := range tests {
+			t.Run(store.name+" "+test.name, func(t *testing.T) {
+				service := newTestService(t, store.store)
+				result, err := service.GetParameterPresence(context.Background(), "app.content.genre", test.r, nil, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				overall := result.Data.Overall
+				if overall.PresenceCount != test.presence || overall.TotalRequests != 2*test.presence || overall.PresenceRate != 50 {
+					t.Errorf("got %d of %d at %.2f%%, want %d of %d at 50%%", overall.PresenceCount, overall.TotalRequests,
+						overall.PresenceRate, test.presence, 2*test.presence)
+				}
+				if len(result.Data.Breakdown) != 1 || result.Data.Breakdown[0].PresenceCount != test.presence {
+					t.Errorf("got breakdown %+v, want device_type:3 with %d", result.Data.Breakdown, test.presence)
+				}
+			})
+		}
+	}
+}
+
+func TestExplorePresence(t *testing.T) {
+	counts := []PresenceCount{
+		{DimensionKey: "", PresenceCount: 50, TotalRequests: 100},
+		{DimensionKey: "device_type:3", PresenceCount: 40, TotalRequests: 60},
+		{DimensionKey: "device_type:4", PresenceCount: 10, TotalRequests: 40},
+		{DimensionKey: "has_ifa:true", PresenceCount: 45, TotalRequests: 70},
+		{DimensionKey: "device_type:3|has_ifa:true", PresenceCount: 30, TotalRequests: 40},
+		// Keys match the filters whatever the order of their dimensions
+		{DimensionKey: "has_ifa:false|device_type:3", PresenceCount: 10, TotalRequests: 20},
+		{DimensionKey: "device_type:3|has_ifa:true|content_type:movie", PresenceCount: 20, TotalRequests: 25},
+		{DimensionKey: "device_type", PresenceCount: 1, TotalRequests: 1},
+	}
+
+	tests := []struct {
+		name       string
+		filters    []Dimension
+		dimension  string
+		overall    PresenceRate
+		breakdown  []string
+		dimensions []string
+	}{
+		{"all requests", nil, "",
+			PresenceRate{Key: "", Level: 0, PresenceCount: 50, TotalRequests: 100, PresenceRate: 50},
+			[]string{"has_ifa:true", "device_type:3", "device_type:4"}, []string{"device_type", "has_ifa"}},
+		{"one dimension", nil, "device_type",
+			PresenceRate{Key: "", Level: 0, PresenceCount: 50, TotalRequests: 100, PresenceRate: 50},
+			[]string{"device_type:3", "device_type:4"}, []string{"device_type", "has_ifa"}},
+		{"level 2", []Dimension{{"device_type", "3"}}, "",
+			PresenceRate{Key: "device_type:3", Dimension: "device_type", Value: "3", Level: 1, PresenceCount: 40, TotalRequests: 60, PresenceRate: 66.67},
+			[]string{"device_type:3|has_ifa:true", "device_type:3|has_ifa:false"}, []string{"has_ifa"}},
+		{"level 3", []Dimension{{"has_ifa", "true"}, {"device_type", "3"}}, "",
+			PresenceRate{Key: "has_ifa:true|device_type:3", Dimension: "device_type", Value: "3", Level: 2, PresenceCount: 30, TotalRequests: 40, PresenceRate: 75},
+			[]string{"has_ifa:true|device_type:3|content_type:movie"}, []string{"content_type"}},
+		{"unknown slice", []Dimension{{"device_type", "7"}}, "",
+			PresenceRate{Key: "device_type:7", Dimension: "device_type", Value: "7", Level: 1},
+			[]string{}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := explorePresence("app.content.genre", counts, test.filters, test.dimension)
+			if report.Overall != test.overall {
+				t.Errorf("got overall %+v, want %+v", report.Overall, test.overall)
+			}
+			var keys []string
+			for _, rate := range report.Breakdown {
+				keys = append(keys, rate.Key)
+				if rate.Level != len(test.filters)+1 {
+					t.Errorf("%s has level %d", rate.Key, rate.Level)
+				}
+			}
+			if len(keys) != len(test.breakdown) {
+				t.Fatalf("got breakdown %v, want %v", keys, test.breakdown)
+			}
+			for i := range keys {
+				if keys[i] != test.breakdown[i] {
+					t.Errorf("got breakdown %v, want %v", keys, test.breakdown)
+					break
+				}
+			}
+			if len(report.Dimensions) != len(test.dimensions) {
+				t.Fatalf("got dimensions %v, want %v", report.Dimensions, test.dimensions)
+			}
+			for i := range report.Dimensions {
+				if report.Dimensions[i] != test.dimensions[i] {
+					t.Errorf("got dimensions %v, want %v", report.Dimensions, test.dimensions)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestGetParameterPresence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/presence", NewHandler(newTestService(t, newPresenceMemoryStore())).GetParameterPresence)
+
+	const window = "&start=2026-03-01&end=2026-03-01"
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"path", "path=app.content.genre" + window, http.StatusOK},
+		{"filters and dimension", "path=app.content.genre&filter=device_type:3&filter=has_ifa:true&dimension=content_type" + window, http.StatusOK},
+		{"no path", "start=2026-03-01&end=2026-03-01", http.StatusBadRequest},
+		{"no window", "path=app.content.genre", http.StatusBadRequest},
+		{"three filters", "path=app.content.genre&filter=a:1&filter=b:2&filter=c:3" + window, http.StatusBadRequest},
+		{"filter without value", "path=app.content.genre&filter=device_type" + window, http.StatusBadRequest},
+		{"filter with a key", "path=app.content.genre&filter=device_type:3|has_ifa:true" + window, http.StatusBadRequest},
+		{"dimension filtered twice", "path=app.content.genre&filter=device_type:3&filter=device_type:4" + window, http.StatusBadRequest},
+		{"breakdown by a filtered dimension", "path=app.content.genre&filter=device_type:3&dimension=device_type" + window, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/presence?"+test.query, nil))
+			if recorder.Code != test.status {
+				t.Errorf("got %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/presence?path=app.content.genre"+window, nil))
+	var body struct {
+		Data  PresenceReport `json:"data"`
+		Query struct {
+			StartDate string `json:"startDate"`
+			EndDate   string `json:"endDate"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Data.Overall.PresenceCount != 110 {
+		t.Errorf("got presence %d, want 110 for March 1st", body.Data.Overall.PresenceCount)
+	}
+	if body.Query.StartDate != "2026-03-01T00:00:00Z" || body.Query.EndDate != "2026-03-01T23:00:00Z" {
+		t.Errorf("got query %s to %s", body.Query.StartDate, body.Query.EndDate)
+	}
+}
@@ -1,164 +1,183 @@
 package reports
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"openrtb-insights/internal/metrics"
+	"openrtb-insights/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("openrtb-insights/internal/reports")
+
+// demoData marks spans whose query failed or matched nothing, so the
+// response was filled with generated data.
+var demoData = attribute.Bool("reports.demo_data", true)
+
 type Service struct {
-	db *sql.DB
+	store    Store
+	timeouts Timeouts
+	cache    *Cache
+	// hourlyRetention is how long hourly rows are kept, zero for ever
+	hourlyRetention time.Duration
 }
 
-func NewService(db *sql.DB) *Service {
-	return &Service{db: db}
+func NewService(store Store, timeouts Timeouts, cache *Cache, hourlyRetention time.Duration) *Service {
+	return &Service{store: store, timeouts: timeouts, cache: cache, hourlyRetention: hourlyRetention}
 }
 
-func (s *Service) GetPlatformStats(startDate, endDate string) ([]PlatformStats, error) {
-	query := `
-		SELECT date, total_requests, multi_impression, big_guidance, addressable,
-		       compliance_strings, deals, tmax, invalid_requests, timeout_rate,
-		       bid_rate, created_at
-		FROM platform_stats 
-		WHERE date BETWEEN ? AND ? 
-		ORDER BY date ASC
-	`
-
-	rows, err := s.db.Query(query, startDate, endDate)
-	if err != nil {
-		// Return demo data if query fails
-		return s.generateDemoPlatformStats(startDate, endDate), nil
-	}
-	defer rows.Close()
-
-	var stats []PlatformStats
-	for rows.Next() {
-		var stat PlatformStats
-		err := rows.Scan(
-			&stat.Date, &stat.TotalRequests, &stat.MultiImpression, &stat.BigGuidance,
-			&stat.Addressable, &stat.ComplianceStrings, &stat.Deals, &stat.Tmax,
-			&stat.InvalidRequests, &stat.TimeoutRate, &stat.BidRate, &stat.CreatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan platform stat: %w", err)
-		}
-		stats = append(stats, stat)
-	}
-
-	// If no data found, return demo data
-	if len(stats) == 0 {
-		return s.generateDemoPlatformStats(startDate, endDate), nil
-	}
+// RetentionError rejects a report read from the hourly tables that reaches
+// back further than hourly rows are kept, rather than serving it with the
+// older part silently missing.
+type RetentionError struct {
+	// Oldest is the start of the oldest hourly row kept
+	Oldest time.Time
+}
 
-	return stats, nil
+func (e *RetentionError) Error() string {
+	return "range starts before the oldest hourly row at " + e.Oldest.Format(time.RFC3339)
 }
 
-func (s *Service) GetContentHealth(platform, startDate, endDate string) ([]ContentHealth, error) {
-	query := `
-		SELECT date, platform, total_requests, album, artist, cat, context, data,
-		       embeddable, episode, genre, id, kwarray, keywords, length, language,
-		       livestream, season, series, title, url, videoquality, created_at
-		FROM content_health 
-		WHERE platform = ? AND date BETWEEN ? AND ? 
-		ORDER BY date ASC
-	`
-
-	rows, err := s.db.Query(query, platform, startDate, endDate)
-	if err != nil {
-		// Return demo data if query fails
-		return s.generateDemoContentHealth(platform, startDate, endDate), nil
-	}
-	defer rows.Close()
+// InvalidateCache drops cached reports; call it after loading new data.
+func (s *Service) InvalidateCache() {
+	s.cache.Invalidate()
+}
 
-	var health []ContentHealth
-	for rows.Next() {
-		var h ContentHealth
-		err := rows.Scan(
-			&h.Date, &h.Platform, &h.TotalRequests, &h.Album, &h.Artist, &h.Cat,
-			&h.Context, &h.Data, &h.Embeddable, &h.Episode, &h.Genre, &h.ID,
-			&h.Kwarray, &h.Keywords, &h.Length, &h.Language, &h.Livestream,
-			&h.Season, &h.Series, &h.Title, &h.URL, &h.VideoQuality, &h.CreatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan content health: %w", err)
-		}
-		health = append(health, h)
+func (s *Service) GetPlatformStats(ctx context.Context, r Range, granularity Granularity) (*Result[[]PlatformStats], error) {
+	if err := s.checkHourlyRetention(r, granularity); err != nil {
+		return nil, err
 	}
+	key := reportKey("platform_stats", r, granularity)
+	return cached(ctx, s, "platform_stats", key, func(ctx context.Context) ([]PlatformStats, error) {
+		return s.platformStats(ctx, r, granularity)
+	})
+}
 
-	// If no data found, return demo data
-	if len(health) == 0 {
-		return s.generateDemoContentHealth(platform, startDate, endDate), nil
+func (s *Service) GetContentHealth(ctx context.Context, platform string, r Range, granularity Granularity) (*Result[[]ContentHealth], error) {
+	if err := s.checkHourlyRetention(r, granularity); err != nil {
+		return nil, err
 	}
-
-	return health, nil
+	key := reportKey("content_health", r, granularity, platform)
+	return cached(ctx, s, "content_health", key, func(ctx context.Context) ([]ContentHealth, error) {
+		return s.contentHealth(ctx, platform, r, granularity)
+	})
 }
 
-func (s *Service) GetVideoHealth(platform, startDate, endDate string) ([]VideoHealth, error) {
-	query := `
-		SELECT date, platform, percent_ctv, api, boxing_allowed, delivery, h, linearity,
-		       max_bitrate, max_duration, mimes, min_bitrate, min_cpm_per_sec, min_duration,
-		       placement, play_backend, pod_dur, pod_id, pos, protocols, rqd_durs, skip,
-		       skip_after, skip_min, slot_in_pod, start_delay, w, max_seq, companion_ad,
-		       companion_type, protocol, placement_type, created_at
-		FROM video_health 
-		WHERE platform = ? AND date BETWEEN ? AND ? 
-		ORDER BY date ASC
-	`
-
-	rows, err := s.db.Query(query, platform, startDate, endDate)
-	if err != nil {
-		// Return demo data if query fails
-		return s.generateDemoVideoHealth(platform, startDate, endDate), nil
+func (s *Service) GetVideoHealth(ctx context.Context, platform string, r Range, granularity Granularity) (*Result[[]VideoHealth], error) {
+	if err := s.checkHourlyRetention(r, granularity); err != nil {
+		return nil, err
 	}
-	defer rows.Close()
+	key := reportKey("video_health", r, granularity, platform)
+	return cached(ctx, s, "video_health", key, func(ctx context.Context) ([]VideoHealth, error) {
+		return s.videoHealth(ctx, platform, r, granularity)
+	})
+}
 
-	var health []VideoHealth
-	for rows.Next() {
-		var h VideoHealth
-		err := rows.Scan(
-			&h.Date, &h.Platform, &h.PercentCTV, &h.API, &h.BoxingAllowed, &h.Delivery,
-			&h.H, &h.Linearity, &h.MaxBitrate, &h.MaxDuration, &h.Mimes, &h.MinBitrate,
-			&h.MinCPMPerSec, &h.MinDuration, &h.Placement, &h.PlayBackend, &h.PodDur,
-			&h.PodID, &h.Pos, &h.Protocols, &h.RqdDurs, &h.Skip, &h.SkipAfter,
-			&h.SkipMin, &h.SlotInPod, &h.StartDelay, &h.W, &h.MaxSeq, &h.CompanionAd,
-			&h.CompanionType, &h.Protocol, &h.PlacementType, &h.CreatedAt,
-		)
+// GetParameterPresence reports a parameter's presence in the agent flushes
+// starting in the range, in the slice selected by the filters and broken
+// down one level further. Unlike the other reports there is no demo data:
+// a parameter no agent has sent has an empty report.
+func (s *Service) GetParameterPresence(ctx context.Context, path string, r Range, filters []Dimension, dimension string) (*Result[PresenceReport], error) {
+	from, to := r.Start, r.End.Add(time.Hour)
+	key := cacheKey("parameter_presence", path, from.Format(time.RFC3339), to.Format(time.RFC3339), joinDimensions(filters), dimension)
+	return cached(ctx, s, "parameter_presence", key, func(ctx context.Context) (PresenceReport, error) {
+		counts, err := runQuery(ctx, s, "parameter_presence",
+			func(ctx context.Context) ([]PresenceCount, error) {
+				return s.store.GetParameterPresence(ctx, path, from, to)
+			}, nil)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan video health: %w", err)
+			return PresenceReport{}, err
 		}
-		health = append(health, h)
-	}
+		return explorePresence(path, counts, filters, dimension), nil
+	})
+}
 
-	// If no data found, return demo data
-	if len(health) == 0 {
-		return s.generateDemoVideoHealth(platform, startDate, endDate), nil
-	}
+func (s *Service) GetDashboardSummary(ctx context.Context) (*Result[map[string]interface{}], error) {
+	return cached(ctx, s, "dashboard_latest_stats", cacheKey("dashboard_latest_stats"), s.dashboardSummary)
+}
 
-	return health, nil
+func (s *Service) platformStats(ctx context.Context, r Range, granularity Granularity) ([]PlatformStats, error) {
+	return runQuery(ctx, s, "platform_stats",
+		func(ctx context.Context) ([]PlatformStats, error) {
+			if !r.zoned() {
+				return s.store.GetPlatformStats(ctx, r, granularity)
+			}
+			rows, err := s.store.GetPlatformStats(ctx, r.widen(granularity), Hourly)
+			if err != nil {
+				return nil, err
+			}
+			return inZone(rows, r.Zone, granularity, func(s *PlatformStats) *string { return &s.Date }, mergePlatformStats)
+		},
+		func() []PlatformStats { return s.generateDemoPlatformStats(r.widen(granularity), granularity) })
 }
 
-func (s *Service) GetDashboardSummary() (map[string]interface{}, error) {
+func (s *Service) contentHealth(ctx context.Context, platform string, r Range, granularity Granularity) ([]ContentHealth, error) {
+	return runQuery(ctx, s, "content_health",
+		func(ctx context.Context) ([]ContentHealth, error) {
+			if !r.zoned() {
+				return s.store.GetContentHealth(ctx, platform, r, granularity)
+			}
+			rows, err := s.store.GetContentHealth(ctx, platform, r.widen(granularity), Hourly)
+			if err != nil {
+				return nil, err
+			}
+			return inZone(rows, r.Zone, granularity, func(h *ContentHealth) *string { return &h.Date }, mergeContentHealth)
+		},
+		func() []ContentHealth {
+			return s.generateDemoContentHealth(platform, r.widen(granularity), granularity)
+		})
+}
+
+func (s *Service) videoHealth(ctx context.Context, platform string, r Range, granularity Granularity) ([]VideoHealth, error) {
+	return runQuery(ctx, s, "video_health",
+		func(ctx context.Context) ([]VideoHealth, error) {
+			if !r.zoned() {
+				return s.store.GetVideoHealth(ctx, platform, r, granularity)
+			}
+			rows, err := s.store.GetVideoHealth(ctx, platform, r.widen(granularity), Hourly)
+			if err != nil {
+				return nil, err
+			}
+			return inZone(rows, r.Zone, granularity, func(h *VideoHealth) *string { return &h.Date }, mergeVideoHealth)
+		},
+		func() []VideoHealth { return s.generateDemoVideoHealth(platform, r.widen(granularity), granularity) })
+}
+
+func (s *Service) dashboardSummary(ctx context.Context) (map[string]interface{}, error) {
+	ctx, span := s.startQuery(ctx, "dashboard_latest_stats")
+	defer span.End()
+	timer := metrics.QueryTimer("dashboard_latest_stats")
+	defer timer.ObserveDuration()
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.For("dashboard_latest_stats"))
+	defer cancel()
+
 	// Try to get latest platform stats - if none exist, create dummy data
-	var latestStats PlatformStats
-	platformQuery := `
-		SELECT date, total_requests, multi_impression, big_guidance, addressable,
-		       compliance_strings, deals, tmax, invalid_requests, timeout_rate,
-		       bid_rate, created_at
-		FROM platform_stats 
-		ORDER BY date DESC 
-		LIMIT 1
-	`
-
-	err := s.db.QueryRow(platformQuery).Scan(
-		&latestStats.Date, &latestStats.TotalRequests, &latestStats.MultiImpression,
-		&latestStats.BigGuidance, &latestStats.Addressable, &latestStats.ComplianceStrings,
-		&latestStats.Deals, &latestStats.Tmax, &latestStats.InvalidRequests,
-		&latestStats.TimeoutRate, &latestStats.BidRate, &latestStats.CreatedAt,
-	)
+	latest, err := s.store.GetLatestPlatformStats(ctx)
+	if err != nil && ctx.Err() != nil {
+		return nil, queryAborted(ctx, span, "dashboard_latest_stats")
+	}
 	if err != nil {
-		// If no data exists, return demo data
+		metrics.QueryFailed("dashboard_latest_stats")
+		tracing.RecordError(span, err)
+	}
+	var latestStats PlatformStats
+	if latest != nil {
+		latestStats = *latest
+	} else {
+		span.SetAttributes(demoData)
+		// If no data exists, return demo data, dated in UTC like the
+		// daily tables
+		now := time.Now().UTC()
 		latestStats = PlatformStats{
-			Date:               time.Now().Format("2006-01-02"),
+			Date:               now.Format(dateLayout),
 			TotalRequests:      10000,
 			MultiImpression:    1500,
 			BigGuidance:        3000,
@@ -169,7 +188,7 @@ func (s *Service) GetDashboardSummary() (map[string]interface{}, error) {
 			InvalidRequests:    100,
 			TimeoutRate:        2.5,
 			BidRate:           65.0,
-			CreatedAt:         time.Now(),
+			CreatedAt:         now,
 		}
 	}
 
@@ -190,20 +209,107 @@ func (s *Service) GetDashboardSummary() (map[string]interface{}, error) {
 		"latestStats":    latestStats,
 		"contentSummary": contentSummary,
 		"videoSummary":   videoSummary,
-		"lastUpdated":    time.Now(),
+		"lastUpdated":    time.Now().UTC(),
 	}
 
 	return summary, nil
 }
 
-func (s *Service) generateDemoPlatformStats(startDate, endDate string) []PlatformStats {
-	start, _ := time.Parse("2006-01-02", startDate)
-	end, _ := time.Parse("2006-01-02", endDate)
-	
+// runQuery reads report rows from the store within the query's span,
+// metrics and timeout. A failed read, or one matching nothing, is answered
+// with demo data; without a demo func failures are returned and no rows
+// stay no rows.
+func runQuery[T any](ctx context.Context, s *Service, name string, load func(context.Context) ([]T, error), demo func() []T) ([]T, error) {
+	ctx, span := s.startQuery(ctx, name)
+	defer span.End()
+	timer := metrics.QueryTimer(name)
+	defer timer.ObserveDuration()
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.For(name))
+	defer cancel()
+
+	rows, err := load(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, queryAborted(ctx, span, name)
+		}
+		metrics.QueryFailed(name)
+		tracing.RecordError(span, err)
+		if demo == nil {
+			return nil, err
+		}
+		span.SetAttributes(demoData)
+		return demo(), nil
+	}
+
+	span.SetAttributes(attribute.Int("db.response.returned_rows", len(rows)))
+
+	// If no data found, return demo data
+	if len(rows) == 0 && demo != nil {
+		span.SetAttributes(demoData)
+		return demo(), nil
+	}
+	return rows, nil
+}
+
+// checkHourlyRetention returns a RetentionError when a report read from the
+// hourly tables, an hourly or zoned one, starts before the oldest hourly
+// row the retention job keeps. Hourly rows are deleted by UTC day.
+func (s *Service) checkHourlyRetention(r Range, granularity Granularity) error {
+	if s.hourlyRetention <= 0 || (granularity != Hourly && !r.zoned()) {
+		return nil
+	}
+	y, m, d := time.Now().UTC().Date()
+	oldest := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Add(-s.hourlyRetention)
+	if r.widen(granularity).Start.Before(oldest) {
+		return &RetentionError{Oldest: oldest}
+	}
+	return nil
+}
+
+// reportKey keys a dated report by the hours it reads, the range widened to
+// whole buckets in its zone, rather than by the dates requested: the same
+// dates cover different hours in different zones.
+func reportKey(query string, r Range, granularity Granularity, params ...string) string {
+	w := r.widen(granularity)
+	return cacheKey(query, append([]string{string(granularity), r.zone().String(),
+		w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339)}, params...)...)
+}
+
+// cacheKey joins the query name and its parameters, which the handler has
+// already validated into their canonical form.
+func cacheKey(query string, params ...string) string {
+	return strings.Join(append([]string{query}, params...), "|")
+}
+
+// queryAborted records a query stopped by its timeout or by the client
+// going away. Unlike other failures these are not answered with demo data,
+// which would pass off a timed out report as a real one.
+func queryAborted(ctx context.Context, span trace.Span, name string) error {
+	err := fmt.Errorf("%s query aborted: %w", name, ctx.Err())
+	if errors.Is(err, context.DeadlineExceeded) {
+		metrics.QueryFailed(name)
+	}
+	tracing.RecordError(span, err)
+	return err
+}
+
+// startQuery starts a span for a report query; end it once the rows have
+// been read.
+func (s *Service) startQuery(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "reports."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNameKey.String(s.store.System()),
+			semconv.DBQuerySummary(name),
+		),
+	)
+}
+
+func (s *Service) generateDemoPlatformStats(r Range, granularity Granularity) []PlatformStats {
 	var stats []PlatformStats
-	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+	for _, d := range granularity.buckets(r) {
 		stat := PlatformStats{
-			Date:               d.Format("2006-01-02"),
+			Date:               granularity.format(d),
 			TotalRequests:      8000 + int64(d.Day()*100),
 			MultiImpression:    1200 + int64(d.Day()*20),
 			BigGuidance:        2800 + int64(d.Day()*50),
@@ -221,15 +327,12 @@ func (s *Service) generateDemoPlatformStats(startDate, endDate string) []Platfor
 	return stats
 }
 
-func (s *Service) generateDemoContentHealth(platform, startDate, endDate string) []ContentHealth {
-	start, _ := time.Parse("2006-01-02", startDate)
-	end, _ := time.Parse("2006-01-02", endDate)
-	
+func (s *Service) generateDemoContentHealth(platform string, r Range, granularity Granularity) []ContentHealth {
 	var health []ContentHealth
-	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+	for _, d := range granularity.buckets(r) {
 		baseRequests := int64(3000 + d.Day()*100)
 		h := ContentHealth{
-			Date:          d.Format("2006-01-02"),
+			Date:          granularity.format(d),
 			Platform:      platform,
 			TotalRequests: baseRequests,
 			Album:         baseRequests * 6 / 10,
@@ -258,12 +361,9 @@ func (s *Service) generateDemoContentHealth(platform, startDate, endDate string)
 	return health
 }
 
-func (s *Service) generateDemoVideoHealth(platform, startDate, endDate string) []VideoHealth {
-	start, _ := time.Parse("2006-01-02", startDate)
-	end, _ := time.Parse("2006-01-02", endDate)
-	
+func (s *Service) generateDemoVideoHealth(platform string, r Range, granularity Granularity) []VideoHealth {
 	var health []VideoHealth
-	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+	for _, d := range granularity.buckets(r) {
 		var percentCTV float64
 		switch platform {
 		case "CTV":
@@ -275,7 +375,7 @@ func (s *Service) generateDemoVideoHealth(platform, startDate, endDate string) [
 		}
 
 		h := VideoHealth{
-			Date:            d.Format("2006-01-02"),
+			Date:            granularity.format(d),
 			Platform:        platform,
 			PercentCTV:      percentCTV,
 			API:             500 + int64(d.Day()*20),
@@ -312,4 +412,4 @@ func (s *Service) generateDemoVideoHealth(platform, startDate, endDate string) [
 		health = append(health, h)
 	}
 	return health
-}
\ No newline at end of file
+}
@@ -0,0 +1,266 @@
+package reports
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestService(t *testing.T, store Store) *Service {
+	t.Helper()
+	timeouts, err := ParseTimeouts(5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(store, timeouts, NewCache(time.Minute, 100), 0)
+}
+
+// dateRange is the range a request for the dates in zone covers.
+func dateRange(t *testing.T, start, end string, zone *time.Location) Range {
+	t.Helper()
+	from, err := parseBound(start, false, zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parseBound(end, true, zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Range{Start: from, End: to, Zone: zone}
+}
+
+func loadZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	zone, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zone
+}
+
+func TestPlatformStatsFromMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddPlatformStats(Daily,
+		PlatformStats{Date: "2026-03-03", TotalRequests: 300},
+		PlatformStats{Date: "2026-03-01", TotalRequests: 100},
+		PlatformStats{Date: "2026-03-02", TotalRequests: 200},
+	)
+	service := newTestService(t, store)
+
+	result, err := service.GetPlatformStats(context.Background(), dateRange(t, "2026-03-01", "2026-03-02", time.UTC), Daily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Data) != 2 {
+		t.Fatalf("got %d rows, want 2: %+v", len(result.Data), result.Data)
+	}
+	for i, want := range []PlatformStats{{Date: "2026-03-01", TotalRequests: 100}, {Date: "2026-03-02", TotalRequests: 200}} {
+		if got := result.Data[i]; got.Date != want.Date || got.TotalRequests != want.TotalRequests {
+			t.Errorf("row %d is %s with %d requests, want %s with %d", i, got.Date, got.TotalRequests, want.Date, want.TotalRequests)
+		}
+	}
+}
+
+func TestZonedPlatformStatsFromHourlyRows(t *testing.T) {
+	store := NewMemoryStore()
+	// 23:00 UTC on March 1st is already March 2nd in Berlin
+	store.AddPlatformStats(Hourly,
+		PlatformStats{Date: "2026-03-01T22:00:00Z", TotalRequests: 10, BidRate: 50},
+		PlatformStats{Date: "2026-03-01T23:00:00Z", TotalRequests: 20, BidRate: 60},
+		PlatformStats{Date: "2026-03-02T00:00:00Z", TotalRequests: 30, BidRate: 70},
+	)
+	service := newTestService(t, store)
+
+	berlin := loadZone(t, "Europe/Berlin")
+	result, err := service.GetPlatformStats(context.Background(), dateRange(t, "2026-03-02", "2026-03-02", berlin), Daily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Data) != 1 {
+		t.Fatalf("got %d rows, want 1: %+v", len(result.Data), result.Data)
+	}
+	if got := result.Data[0]; got.Date != "2026-03-02" || got.TotalRequests != 50 || got.BidRate != 66 {
+		t.Errorf("got %s with %d requests at %.2f%%, want 2026-03-02 with 50 at 66%%", got.Date, got.TotalRequests, got.BidRate)
+	}
+}
+
+func TestEmptyStoreServesDemoData(t *testing.T) {
+	service := newTestService(t, NewMemoryStore())
+
+	result, err := service.GetPlatformStats(context.Background(), dateRange(t, "2026-03-01", "2026-03-07", time.UTC), Daily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Data) != 7 {
+		t.Errorf("got %d demo rows, want one per day", len(result.Data))
+	}
+}
+
+func TestReportCache(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddPlatformStats(Daily, PlatformStats{Date: "2026-03-01", TotalRequests: 100})
+	service := newTestService(t, store)
+	r := dateRange(t, "2026-03-01", "2026-03-01", time.UTC)
+
+	first, err := service.GetPlatformStats(context.Background(), r, Daily)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows loaded since are not seen until the cache is invalidated
+	store.AddPlatformStats(Daily, PlatformStats{Date: "2026-03-01", TotalRequests: 50})
+	second, err := service.GetPlatformStats(context.Background(), r, Daily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Errorf("second request was not served from the cache")
+	}
+
+	service.InvalidateCache()
+	third, err := service.GetPlatformStats(context.Background(), r, Daily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first || len(third.Data) != 2 {
+		t.Errorf("got %d rows after invalidation, want the 2 now in the store", len(third.Data))
+	}
+}
+
+func TestPlatformStatsConditionalRequest(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddPlatformStats(Daily, PlatformStats{Date: "2026-03-01", TotalRequests: 100})
+	service := newTestService(t, store)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/platform", NewHandler(service).GetPlatformStats)
+	get := func(url, etag string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		if etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := get("/platform?start=2026-03-01&end=2026-03-01", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with ETag %q", first.Code, etag)
+	}
+	if again := get("/platform?start=2026-03-01&end=2026-03-01", etag); again.Code != http.StatusNotModified {
+		t.Errorf("revalidation returned %d, want %d", again.Code, http.StatusNotModified)
+	}
+
+	// The same dates in another zone are a different report
+	zoned := get("/platform?start=2026-03-01&end=2026-03-01&tz=Asia/Tokyo", etag)
+	if zoned.Code != http.StatusOK || zoned.Header().Get("ETag") == etag {
+		t.Errorf("zoned request returned %d with ETag %q", zoned.Code, zoned.Header().Get("ETag"))
+	}
+
+	service.InvalidateCache()
+	store.AddPlatformStats(Daily, PlatformStats{Date: "2026-03-01", TotalRequests: 50})
+	if changed := get("/platform?start=2026-03-01&end=2026-03-01", etag); changed.Code != http.StatusOK {
+		t.Errorf("request after new data returned %d, want %d", changed.Code, http.StatusOK)
+	}
+}
+
+func TestReportKeyFollowsZone(t *testing.T) {
+	berlin := dateRange(t, "2026-03-01", "2026-03-02", loadZone(t, "Europe/Berlin"))
+	newYork := dateRange(t, "2026-03-01", "2026-03-02", loadZone(t, "America/New_York"))
+
+	berlinKey := reportKey("platform_stats", berlin, Daily)
+	if berlinKey == reportKey("platform_stats", newYork, Daily) {
+		t.Errorf("the same dates in two zones share the key %s", berlinKey)
+	}
+	if want := "platform_stats|day|Europe/Berlin|2026-02-28T23:00:00Z|2026-03-02T22:00:00Z"; berlinKey != want {
+		t.Errorf("got key %s, want %s", berlinKey, want)
+	}
+
+	// Ranges widening to the same buckets read the same rows
+	within := Range{Start: berlin.Start.Add(5 * time.Hour), End: berlin.End.Add(-5 * time.Hour), Zone: berlin.Zone}
+	if key := reportKey("platform_stats", within, Daily); key != berlinKey {
+		t.Errorf("got key %s for a range within the same days, want %s", key, berlinKey)
+	}
+}
+
+func TestDemoDataFollowsReportKey(t *testing.T) {
+	// 2026-03-04 and 2026-03-17 fall in the weeks starting March 2nd and 16th
+	wide := dateRange(t, "2026-03-02", "2026-03-22", time.UTC)
+	narrow := dateRange(t, "2026-03-04", "2026-03-17", time.UTC)
+	if reportKey("platform_stats", wide, Weekly) != reportKey("platform_stats", narrow, Weekly) {
+		t.Fatalf("ranges within the same weeks have different keys")
+	}
+
+	service := newTestService(t, NewMemoryStore())
+	for _, r := range []Range{wide, narrow} {
+		// Each request misses the cache of the other
+		service.InvalidateCache()
+		result, err := service.GetPlatformStats(context.Background(), r, Weekly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dates []string
+		for _, row := range result.Data {
+			dates = append(dates, row.Date)
+		}
+		if len(dates) != 3 || dates[0] != "2026-03-02" || dates[1] != "2026-03-09" || dates[2] != "2026-03-16" {
+			t.Errorf("got demo rows dated %v for %s to %s, want one per week", dates, r.Start, r.End)
+		}
+	}
+}
+
+func TestZonedReportBeyondHourlyRetention(t *testing.T) {
+	service := NewService(NewMemoryStore(), Timeouts{Default: 5 * time.Second}, NewCache(time.Minute, 100), 90*24*time.Hour)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	oldest := today.AddDate(0, 0, -90)
+	berlin := loadZone(t, "Europe/Berlin")
+
+	tests := []struct {
+		name        string
+		r           Range
+		granularity Granularity
+		rejected    bool
+	}{
+		{"zoned before the cutoff", Range{Start: oldest.Add(-24 * time.Hour), End: today, Zone: berlin}, Daily, true},
+		// Berlin's day starts before midnight UTC, so its first whole
+		// day is the one after the cutoff
+		{"zoned from the cutoff", Range{Start: oldest.Add(24 * time.Hour), End: today, Zone: berlin}, Daily, false},
+		{"hourly before the cutoff", Range{Start: oldest.Add(-time.Hour), End: oldest.Add(time.Hour), Zone: time.UTC}, Hourly, true},
+		{"hourly from the cutoff", Range{Start: oldest, End: oldest.Add(time.Hour), Zone: time.UTC}, Hourly, false},
+		{"daily in UTC", Range{Start: today.AddDate(-1, 0, 0), End: today, Zone: time.UTC}, Daily, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := service.GetPlatformStats(context.Background(), test.r, test.granularity)
+			var retentionErr *RetentionError
+			if !test.rejected {
+				if err != nil {
+					t.Errorf("rejected: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &retentionErr) || !retentionErr.Oldest.Equal(oldest) {
+				t.Errorf("got %v, want a RetentionError at %s", err, oldest)
+			}
+		})
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/platform", NewHandler(service).GetPlatformStats)
+	url := "/platform?tz=Europe/Berlin&start=" + oldest.AddDate(0, 0, -1).Format(dateLayout) + "&end=" + today.Format(dateLayout)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), oldest.Format(time.RFC3339)) {
+		t.Errorf("got %d: %s", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,26 @@
+package reports
+
+import (
+	"context"
+	"time"
+)
+
+// Store reads report rows for the service. A report holds every bucket
+// overlapping its range, so a weekly or monthly report always covers whole
+// buckets. Rows are dated YYYY-MM-DD, or by the RFC3339 start of their UTC
+// hour in hourly reports. A store returns no rows, not an error, when
+// nothing matches; the service decides what to show then.
+type Store interface {
+	// System names the backend in traces, e.g. duckdb
+	System() string
+
+	GetPlatformStats(ctx context.Context, r Range, granularity Granularity) ([]PlatformStats, error)
+	GetContentHealth(ctx context.Context, platform string, r Range, granularity Granularity) ([]ContentHealth, error)
+	GetVideoHealth(ctx context.Context, platform string, r Range, granularity Granularity) ([]VideoHealth, error)
+	// GetLatestPlatformStats returns the newest daily platform stats, nil
+	// when there are none
+	GetLatestPlatformStats(ctx context.Context) (*PlatformStats, error)
+	// GetParameterPresence sums a parameter's presence counts from agent
+	// flushes starting in [from, to): overall and per dimension key
+	GetParameterPresence(ctx context.Context, path string, from, to time.Time) ([]PresenceCount, error)
+}
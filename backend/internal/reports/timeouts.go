@@ -0,0 +1,63 @@
+package reports
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxTimeout is the longest query timeout: the server's write timeout cuts
+// off any response taking longer.
+const MaxTimeout = 10 * time.Second
+
+// queryNames are the report queries whose timeout can be set individually.
+var queryNames = []string{"platform_stats", "content_health", "video_health", "dashboard_latest_stats", "parameter_presence"}
+
+// Timeouts bounds how long each report query may run. A query running past
+// its timeout is interrupted and the request fails with 504.
+type Timeouts struct {
+	Default  time.Duration
+	PerQuery map[string]time.Duration
+}
+
+// ParseTimeouts builds Timeouts from the default and query:duration
+// overrides, e.g. "video_health:8s". Each override is bounded by
+// MaxTimeout like the default.
+func ParseTimeouts(def time.Duration, overrides []string) (Timeouts, error) {
+	t := Timeouts{Default: def, PerQuery: make(map[string]time.Duration, len(overrides))}
+	for _, entry := range overrides {
+		name, raw, ok := strings.Cut(entry, ":")
+		if !ok {
+			return Timeouts{}, fmt.Errorf("invalid query timeout %q, expected query:duration", entry)
+		}
+		if !isQueryName(name) {
+			return Timeouts{}, fmt.Errorf("unknown report query %q, expected one of %s", name, strings.Join(queryNames, ", "))
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return Timeouts{}, fmt.Errorf("invalid timeout %q for %s", raw, name)
+		}
+		if d > MaxTimeout {
+			return Timeouts{}, fmt.Errorf("timeout %s for %s exceeds the server's %s write timeout", d, name, MaxTimeout)
+		}
+		t.PerQuery[name] = d
+	}
+	return t, nil
+}
+
+// For returns the timeout for the named query.
+func (t Timeouts) For(query string) time.Duration {
+	if d, ok := t.PerQuery[query]; ok {
+		return d
+	}
+	return t.Default
+}
+
+func isQueryName(name string) bool {
+	for _, q := range queryNames {
+		if q == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package reports
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	timeouts, err := ParseTimeouts(5*time.Second, []string{"video_health:8s", "platform_stats:10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := timeouts.For("video_health"); got != 8*time.Second {
+		t.Errorf("got %s for video_health, want 8s", got)
+	}
+	if got := timeouts.For("platform_stats"); got != 10*time.Second {
+		t.Errorf("got %s for platform_stats, want 10s", got)
+	}
+	if got := timeouts.For("content_health"); got != 5*time.Second {
+		t.Errorf("got %s for content_health, want the 5s default", got)
+	}
+
+	for _, override := range []string{
+		"video_health:20s",
+		"video_health:10001ms",
+		"video_health:0s",
+		"video_health:soon",
+		"video_health",
+		"unknown_query:5s",
+	} {
+		if _, err := ParseTimeouts(5*time.Second, []string{override}); err == nil {
+			t.Errorf("accepted %q", override)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package reports
+
+import (
+	"errors"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Range is the inclusive time range of a report. Start and End are the
+// first and last UTC hours in it; daily and coarser reports read only
+// their dates. Zone is the time zone the report's rows are dated in.
+type Range struct {
+	Start time.Time
+	End   time.Time
+	Zone  *time.Location
+}
+
+// zone returns the range's time zone, UTC when unset.
+func (r Range) zone() *time.Location {
+	if r.Zone == nil {
+		return time.UTC
+	}
+	return r.Zone
+}
+
+// zoned reports whether the range is dated in a zone other than UTC. The
+// daily tables and rollups are dated in UTC, so zoned reports are
+// aggregated from the hourly tables instead.
+func (r Range) zoned() bool {
+	return r.zone() != time.UTC
+}
+
+// parseZone reads a tz query parameter or user default, an IANA zone name.
+// An empty name is UTC.
+func parseZone(name string) (*time.Location, error) {
+	// Local would mean the server's zone, which differs between hosts
+	if name == "Local" {
+		return nil, errors.New("unknown time zone Local")
+	}
+	return time.LoadLocation(name)
+}
+
+// parseBound reads a start or end query parameter, a YYYY-MM-DD date in
+// zone or an RFC3339 timestamp, and returns the first or last UTC hour it
+// covers: an end date covers the whole day. Timestamps are truncated to
+// the hour.
+func parseBound(value string, end bool, zone *time.Location) (time.Time, error) {
+	if day, err := time.ParseInLocation(dateLayout, value, zone); err == nil {
+		if end {
+			return lastHourBefore(day.AddDate(0, 0, 1)), nil
+		}
+		return firstHourFrom(day), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC().Truncate(time.Hour), nil
+}
+
+// widen extends the range to whole buckets of the granularity in its zone.
+func (r Range) widen(granularity Granularity) Range {
+	zone := r.zone()
+	from := granularity.bucketStart(r.Start.In(zone))
+	to := granularity.next(granularity.bucketStart(r.End.In(zone)))
+	return Range{Start: firstHourFrom(from), End: lastHourBefore(to), Zone: r.Zone}
+}
+
+// firstHourFrom returns the first UTC hour starting at or after t, which
+// is later than t in zones with a half hour offset.
+func firstHourFrom(t time.Time) time.Time {
+	hour := t.UTC().Truncate(time.Hour)
+	if hour.Before(t) {
+		hour = hour.Add(time.Hour)
+	}
+	return hour
+}
+
+// lastHourBefore returns the last UTC hour starting before t.
+func lastHourBefore(t time.Time) time.Time {
+	return t.Add(-time.Nanosecond).UTC().Truncate(time.Hour)
+}
@@ -0,0 +1,144 @@
+package reports
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// inZone groups hourly rows, oldest first and for a single platform, into
+// the granularity's buckets in zone, and merges each group into one row
+// dated by its bucket. Rows merge the way the rollups aggregate: counts are
+// summed and rates averaged.
+func inZone[T any](rows []T, zone *time.Location, granularity Granularity, date func(*T) *string, merge func([]T) T) ([]T, error) {
+	var merged []T
+	var group []T
+	var bucket string
+	flush := func() {
+		if len(group) > 0 {
+			row := merge(group)
+			*date(&row) = bucket
+			merged = append(merged, row)
+		}
+	}
+
+	for _, row := range rows {
+		hour, err := time.Parse(time.RFC3339, *date(&row))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hourly row date: %w", err)
+		}
+		start := granularity.format(granularity.bucketStart(hour.In(zone)))
+		if start != bucket {
+			flush()
+			group, bucket = nil, start
+		}
+		group = append(group, row)
+	}
+	flush()
+	return merged, nil
+}
+
+func mergePlatformStats(rows []PlatformStats) PlatformStats {
+	merged := PlatformStats{}
+	var timeoutRate, bidRate, weight float64
+	for _, row := range rows {
+		merged.TotalRequests += row.TotalRequests
+		merged.MultiImpression += row.MultiImpression
+		merged.BigGuidance += row.BigGuidance
+		merged.Addressable += row.Addressable
+		merged.ComplianceStrings += row.ComplianceStrings
+		merged.Deals += row.Deals
+		merged.Tmax += row.Tmax
+		merged.InvalidRequests += row.InvalidRequests
+		timeoutRate += row.TimeoutRate * float64(row.TotalRequests)
+		bidRate += row.BidRate * float64(row.TotalRequests)
+		weight += float64(row.TotalRequests)
+		merged.CreatedAt = latest(merged.CreatedAt, row.CreatedAt)
+	}
+	// Rates are weighted by requests, like the platform stats rollups
+	if weight > 0 {
+		merged.TimeoutRate = round2(timeoutRate / weight)
+		merged.BidRate = round2(bidRate / weight)
+	}
+	return merged
+}
+
+func mergeContentHealth(rows []ContentHealth) ContentHealth {
+	merged := ContentHealth{Platform: rows[0].Platform}
+	for _, row := range rows {
+		merged.TotalRequests += row.TotalRequests
+		merged.Album += row.Album
+		merged.Artist += row.Artist
+		merged.Cat += row.Cat
+		merged.Context += row.Context
+		merged.Data += row.Data
+		merged.Embeddable += row.Embeddable
+		merged.Episode += row.Episode
+		merged.Genre += row.Genre
+		merged.ID += row.ID
+		merged.Kwarray += row.Kwarray
+		merged.Keywords += row.Keywords
+		merged.Length += row.Length
+		merged.Language += row.Language
+		merged.Livestream += row.Livestream
+		merged.Season += row.Season
+		merged.Series += row.Series
+		merged.Title += row.Title
+		merged.URL += row.URL
+		merged.VideoQuality += row.VideoQuality
+		merged.CreatedAt = latest(merged.CreatedAt, row.CreatedAt)
+	}
+	return merged
+}
+
+func mergeVideoHealth(rows []VideoHealth) VideoHealth {
+	merged := VideoHealth{Platform: rows[0].Platform}
+	var percentCTV float64
+	for _, row := range rows {
+		percentCTV += row.PercentCTV
+		merged.API += row.API
+		merged.BoxingAllowed += row.BoxingAllowed
+		merged.Delivery += row.Delivery
+		merged.H += row.H
+		merged.Linearity += row.Linearity
+		merged.MaxBitrate += row.MaxBitrate
+		merged.MaxDuration += row.MaxDuration
+		merged.Mimes += row.Mimes
+		merged.MinBitrate += row.MinBitrate
+		merged.MinCPMPerSec += row.MinCPMPerSec
+		merged.MinDuration += row.MinDuration
+		merged.Placement += row.Placement
+		merged.PlayBackend += row.PlayBackend
+		merged.PodDur += row.PodDur
+		merged.PodID += row.PodID
+		merged.Pos += row.Pos
+		merged.Protocols += row.Protocols
+		merged.RqdDurs += row.RqdDurs
+		merged.Skip += row.Skip
+		merged.SkipAfter += row.SkipAfter
+		merged.SkipMin += row.SkipMin
+		merged.SlotInPod += row.SlotInPod
+		merged.StartDelay += row.StartDelay
+		merged.W += row.W
+		merged.MaxSeq += row.MaxSeq
+		merged.CompanionAd += row.CompanionAd
+		merged.CompanionType += row.CompanionType
+		merged.Protocol += row.Protocol
+		merged.PlacementType += row.PlacementType
+		merged.CreatedAt = latest(merged.CreatedAt, row.CreatedAt)
+	}
+	merged.PercentCTV = round2(percentCTV / float64(len(rows)))
+	return merged
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// round2 rounds a rate to the DECIMAL(5,2) precision of the tables.
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,68 @@
+package retention
+
+import (
+	"net/http"
+	"strconv"
+
+	"openrtb-insights/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxRuns = 100
+
+type Handler struct {
+	job *Job
+}
+
+func NewHandler(job *Job) *Handler {
+	return &Handler{job: job}
+}
+
+// ListRuns returns the configured policies and the most recent runs.
+func (h *Handler) ListRuns(c *gin.Context) {
+	limit := 20
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxRuns {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "limit must be between 1 and 100",
+			})
+			return
+		}
+		limit = n
+	}
+
+	runs, err := h.job.Runs(c.Request.Context(), limit)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to list retention runs", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve retention runs",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"policies": h.job.Policies(),
+		"data":     runs,
+		"count":    len(runs),
+	})
+}
+
+// Run applies the retention policies now instead of waiting for the next
+// scheduled run.
+func (h *Handler) Run(c *gin.Context) {
+	report, err := h.job.Run(c.Request.Context())
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to apply retention policies", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to apply retention policies",
+		})
+		return
+	}
+
+	logging.FromContext(c.Request.Context()).Info("Retention policies applied on request", "rows", report.RowsDeleted)
+	c.JSON(http.StatusOK, gin.H{
+		"data": report,
+	})
+}
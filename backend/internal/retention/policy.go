@@ -0,0 +1,116 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"openrtb-insights/internal/rollup"
+)
+
+// Policy keeps a table's rows for a number of days; older rows are deleted
+// by the retention job. Tables without a policy are kept forever.
+type Policy struct {
+	Table string `json:"table"`
+	Days  int    `json:"days"`
+	// downsampled tables are rolled up before rows are deleted, and only
+	// whole rollup buckets are deleted
+	downsampled bool
+	// column dates the table's rows
+	column string
+}
+
+// tables lists the tables a policy may name, as policies without an age.
+func tables() map[string]Policy {
+	known := make(map[string]Policy)
+	for _, daily := range rollup.DailyTables() {
+		known[daily] = Policy{Table: daily, downsampled: true, column: "date"}
+		hourly := rollup.HourlyTable(daily)
+		known[hourly] = Policy{Table: hourly, column: "hour"}
+		for _, period := range rollup.Periods() {
+			table := rollup.Table(daily, period)
+			known[table] = Policy{Table: table, column: "date"}
+		}
+	}
+	// Edge agent flushes, kept for the presence reports
+	for _, table := range []string{"agent_flushes", "parameter_presence", "dimension_presence"} {
+		known[table] = Policy{Table: table, column: "window_start"}
+	}
+	return known
+}
+
+// ParsePolicies builds policies from table:age entries, e.g.
+// "platform_stats:730d". The age is a number of days, with or without the
+// d suffix.
+func ParsePolicies(entries []string) ([]Policy, error) {
+	known := tables()
+	seen := make(map[string]bool, len(entries))
+
+	policies := make([]Policy, 0, len(entries))
+	for _, entry := range entries {
+		table, raw, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention policy %q, expected table:days", entry)
+		}
+		policy, ok := known[table]
+		if !ok {
+			return nil, fmt.Errorf("unknown table %q, expected one of %s", table, strings.Join(tableNames(known), ", "))
+		}
+		if seen[table] {
+			return nil, fmt.Errorf("duplicate retention policy for %s", table)
+		}
+		days, err := strconv.Atoi(strings.TrimSuffix(raw, "d"))
+		if err != nil || days <= 0 {
+			return nil, fmt.Errorf("invalid retention %q for %s, expected a positive number of days", raw, table)
+		}
+		seen[table] = true
+		policy.Days = days
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// Cutoff returns the first day kept on the given day; rows dated before it
+// are deleted.
+//
+// For tables feeding the rollups the cutoff is moved back to the Monday on
+// or before the first of its month. Every week and month bucket that can
+// still receive rows then lies wholly after the cutoff, so a later refresh
+// never rebuilds a bucket from partly deleted days.
+func (p Policy) Cutoff(today time.Time) time.Time {
+	y, m, d := today.Date()
+	cutoff := time.Date(y, m, d-p.Days, 0, 0, 0, 0, time.UTC)
+	if !p.downsampled {
+		return cutoff
+	}
+	monthStart := time.Date(cutoff.Year(), cutoff.Month(), 1, 0, 0, 0, 0, time.UTC)
+	// date_trunc('week') starts weeks on Monday
+	sinceMonday := (int(monthStart.Weekday()) + 6) % 7
+	return monthStart.AddDate(0, 0, -sinceMonday)
+}
+
+// HourlyRetention returns how long hourly rows are kept, the shortest
+// policy among the hourly tables, or zero when they are kept forever.
+func HourlyRetention(policies []Policy) time.Duration {
+	var shortest time.Duration
+	for _, policy := range policies {
+		if policy.column != "hour" {
+			continue
+		}
+		if age := time.Duration(policy.Days) * 24 * time.Hour; shortest == 0 || age < shortest {
+			shortest = age
+		}
+	}
+	return shortest
+}
+
+func tableNames(known map[string]Policy) []string {
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
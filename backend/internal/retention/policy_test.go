@@ -0,0 +1,90 @@
+package retention
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHourlyRetention(t *testing.T) {
+	policies, err := ParsePolicies([]string{"platform_stats:730d", "platform_stats_hourly:90d", "video_health_hourly:30d", "agent_flushes:7d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := HourlyRetention(policies); got != 30*24*time.Hour {
+		t.Errorf("got %s, want the shortest hourly policy of 30 days", got)
+	}
+
+	policies, err = ParsePolicies([]string{"platform_stats:730d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := HourlyRetention(policies); got != 0 {
+		t.Errorf("got %s without hourly policies, want 0", got)
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies([]string{"platform_stats:730d", "platform_stats_hourly:90", "agent_flushes:7d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		table       string
+		days        int
+		downsampled bool
+		column      string
+	}{
+		{"platform_stats", 730, true, "date"},
+		{"platform_stats_hourly", 90, false, "hour"},
+		{"agent_flushes", 7, false, "window_start"},
+	}
+	if len(policies) != len(want) {
+		t.Fatalf("got %d policies, want %d", len(policies), len(want))
+	}
+	for i, w := range want {
+		p := policies[i]
+		if p.Table != w.table || p.Days != w.days || p.downsampled != w.downsampled || p.column != w.column {
+			t.Errorf("got policy %+v, want %+v", p, w)
+		}
+	}
+
+	for _, entries := range [][]string{
+		{"platform_stats"},
+		{"users:30d"},
+		{"platform_stats:30d", "platform_stats:60d"},
+		{"platform_stats:0d"},
+		{"platform_stats:-1d"},
+		{"platform_stats:1w"},
+	} {
+		if _, err := ParsePolicies(entries); err == nil {
+			t.Errorf("accepted %q", entries)
+		}
+	}
+}
+
+func TestCutoff(t *testing.T) {
+	today := time.Date(2026, 10, 18, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		table string
+		days  int
+		want  string
+	}{
+		{"platform_stats_hourly", 30, "2026-09-18"},
+		{"platform_stats_weekly", 1, "2026-10-17"},
+		// September 1st 2026 is a Tuesday
+		{"platform_stats", 30, "2026-08-31"},
+		// June 1st 2026 is a Monday
+		{"content_health", 120, "2026-06-01"},
+	}
+	for _, test := range tests {
+		policies, err := ParsePolicies([]string{test.table + ":" + strconv.Itoa(test.days)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := policies[0].Cutoff(today).Format("2006-01-02"); got != test.want {
+			t.Errorf("got cutoff %s for %s:%dd, want %s", got, test.table, test.days, test.want)
+		}
+	}
+}
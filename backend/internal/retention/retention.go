@@ -0,0 +1,193 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"openrtb-insights/internal/metrics"
+)
+
+// Refresher brings the rollups up to date with the daily tables.
+type Refresher interface {
+	Refresh(ctx context.Context) (int64, error)
+}
+
+// Job deletes rows older than their table's policy. Daily tables are rolled
+// up first, so their history survives in the weekly and monthly tables.
+type Job struct {
+	db       *sql.DB
+	policies []Policy
+	rollups  Refresher
+	// onChange runs after a run that deleted rows, e.g. to drop cached
+	// reports
+	onChange func()
+	mu       sync.Mutex
+}
+
+func NewJob(db *sql.DB, policies []Policy, rollups Refresher, onChange func()) *Job {
+	return &Job{db: db, policies: policies, rollups: rollups, onChange: onChange}
+}
+
+// Report describes one retention run.
+type Report struct {
+	RanAt       time.Time     `json:"ran_at"`
+	RowsDeleted int64         `json:"rows_deleted"`
+	Tables      []TableReport `json:"tables"`
+}
+
+// TableReport is the outcome of applying one table's policy.
+type TableReport struct {
+	Table       string `json:"table"`
+	Cutoff      string `json:"cutoff"`
+	RowsDeleted int64  `json:"rows_deleted"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Policies returns the configured policies.
+func (j *Job) Policies() []Policy {
+	return j.policies
+}
+
+// Loop applies the policies now and then every interval until ctx is done.
+func (j *Job) Loop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Run(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Failed to apply retention policies", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run applies every policy once and records the outcome. A failing table
+// is reported and does not stop the others.
+func (j *Job) Run(ctx context.Context) (*Report, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	report := &Report{RanAt: time.Now().UTC().Truncate(time.Microsecond), Tables: []TableReport{}}
+	if len(j.policies) == 0 {
+		return report, nil
+	}
+
+	// Downsample before deleting: the rollups must hold every daily row
+	// about to go. Without them daily tables are left alone this run.
+	var rollupErr error
+	if j.rollups != nil {
+		if _, err := j.rollups.Refresh(ctx); err != nil {
+			rollupErr = fmt.Errorf("refresh rollups: %w", err)
+		}
+	}
+
+	for _, policy := range j.policies {
+		cutoff := policy.Cutoff(report.RanAt)
+		table := TableReport{Table: policy.Table, Cutoff: cutoff.Format("2006-01-02")}
+
+		var err error
+		if policy.downsampled && rollupErr != nil {
+			err = rollupErr
+		} else {
+			table.RowsDeleted, err = j.deleteBefore(ctx, policy, table.Cutoff)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			table.Error = err.Error()
+			slog.Error("Failed to apply retention policy", "table", policy.Table, "error", err)
+		}
+
+		metrics.RetentionRowsDeleted(policy.Table, table.RowsDeleted)
+		report.RowsDeleted += table.RowsDeleted
+		report.Tables = append(report.Tables, table)
+	}
+
+	if err := j.record(ctx, report); err != nil {
+		return report, fmt.Errorf("record retention run: %w", err)
+	}
+
+	attrs := []any{"rows", report.RowsDeleted}
+	for _, table := range report.Tables {
+		attrs = append(attrs, table.Table, table.RowsDeleted)
+	}
+	slog.Info("Applied retention policies", attrs...)
+
+	if report.RowsDeleted > 0 && j.onChange != nil {
+		j.onChange()
+	}
+	return report, nil
+}
+
+// Runs returns the most recent runs, newest first.
+func (j *Job) Runs(ctx context.Context, limit int) ([]Report, error) {
+	rows, err := j.db.QueryContext(ctx, `
+		SELECT ran_at, table_name, CAST(cutoff AS VARCHAR), rows_deleted, COALESCE(error, '')
+		FROM retention_runs
+		WHERE ran_at IN (SELECT DISTINCT ran_at FROM retention_runs ORDER BY ran_at DESC LIMIT ?)
+		ORDER BY ran_at DESC, table_name
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Report{}
+	for rows.Next() {
+		var ranAt time.Time
+		var table TableReport
+		if err := rows.Scan(&ranAt, &table.Table, &table.Cutoff, &table.RowsDeleted, &table.Error); err != nil {
+			return nil, err
+		}
+		if len(runs) == 0 || !runs[len(runs)-1].RanAt.Equal(ranAt) {
+			runs = append(runs, Report{RanAt: ranAt, Tables: []TableReport{}})
+		}
+		run := &runs[len(runs)-1]
+		run.Tables = append(run.Tables, table)
+		run.RowsDeleted += table.RowsDeleted
+	}
+	return runs, rows.Err()
+}
+
+func (j *Job) deleteBefore(ctx context.Context, policy Policy, cutoff string) (int64, error) {
+	// Table and column names come from the known tables checked by
+	// ParsePolicies
+	result, err := j.db.ExecContext(ctx,
+		"DELETE FROM "+policy.Table+" WHERE "+policy.column+" < CAST(? AS DATE)", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (j *Job) record(ctx context.Context, report *Report) error {
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range report.Tables {
+		var tableErr sql.NullString
+		if table.Error != "" {
+			tableErr = sql.NullString{String: table.Error, Valid: true}
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO retention_runs (ran_at, table_name, cutoff, rows_deleted, error)
+			VALUES (?, ?, CAST(? AS DATE), ?, ?)
+		`, report.RanAt, table.Table, table.Cutoff, table.RowsDeleted, tableErr)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,146 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"openrtb-insights/internal/database"
+	"openrtb-insights/internal/rollup"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Connect("", database.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func exec(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func count(t *testing.T, db *sql.DB, query string, args ...interface{}) int64 {
+	t.Helper()
+	var n int64
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// seed writes a daily and an hourly platform stats row on each day.
+func seed(t *testing.T, db *sql.DB, days ...time.Time) {
+	t.Helper()
+	for _, day := range days {
+		exec(t, db, "INSERT INTO platform_stats (date, total_requests, bid_rate) VALUES (?, 100, 50)", day.Format("2006-01-02"))
+		exec(t, db, "INSERT INTO platform_stats_hourly (hour, total_requests, bid_rate) VALUES (?, 100, 50)", day.Add(12*time.Hour))
+	}
+}
+
+func TestRunDownsamplesBeforeDeleting(t *testing.T) {
+	db := newTestDB(t)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	// Two old days in one month, well before any 30 day cutoff
+	oldMonth := time.Date(today.Year(), today.Month()-6, 1, 0, 0, 0, 0, time.UTC)
+	recent := today.AddDate(0, 0, -1)
+	seed(t, db, oldMonth, oldMonth.AddDate(0, 0, 1), recent)
+
+	policies, err := ParsePolicies([]string{"platform_stats:30d", "platform_stats_hourly:30d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := false
+	job := NewJob(db, policies, rollup.NewRefresher(db, nil), func() { changed = true })
+
+	report, err := job.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.RowsDeleted != 4 || len(report.Tables) != 2 || !changed {
+		t.Errorf("got report %+v, changed %t, want 4 rows deleted over 2 tables", report, changed)
+	}
+
+	if n := count(t, db, "SELECT COUNT(*) FROM platform_stats WHERE date < ?", recent); n != 0 {
+		t.Errorf("%d old daily rows kept", n)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM platform_stats WHERE date = ?", recent); n != 1 {
+		t.Errorf("recent daily row deleted")
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM platform_stats_hourly WHERE hour < ?", recent); n != 0 {
+		t.Errorf("%d old hourly rows kept", n)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM platform_stats_hourly WHERE hour >= ?", recent); n != 1 {
+		t.Errorf("recent hourly row deleted")
+	}
+
+	// The deleted days survive in the monthly rollup
+	var total, days int64
+	err = db.QueryRow("SELECT total_requests, days FROM platform_stats_monthly WHERE date = ?", oldMonth).Scan(&total, &days)
+	if err != nil {
+		t.Fatalf("no monthly rollup of the deleted days: %v", err)
+	}
+	if total != 200 || days != 2 {
+		t.Errorf("got monthly rollup of %d requests over %d days, want 200 over 2", total, days)
+	}
+
+	runs, err := job.Runs(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].RowsDeleted != 4 || !runs[0].RanAt.Equal(report.RanAt) {
+		t.Errorf("got runs %+v, want the one run", runs)
+	}
+
+	// Nothing is left to delete on the next run
+	changed = false
+	again, err := job.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.RowsDeleted != 0 || changed {
+		t.Errorf("second run deleted %d rows", again.RowsDeleted)
+	}
+}
+
+type failingRefresher struct{}
+
+func (failingRefresher) Refresh(context.Context) (int64, error) {
+	return 0, errors.New("rollups unavailable")
+}
+
+func TestRunKeepsDailyRowsWithoutRollups(t *testing.T) {
+	db := newTestDB(t)
+	old := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -200)
+	seed(t, db, old)
+
+	policies, err := ParsePolicies([]string{"platform_stats:30d", "platform_stats_hourly:30d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewJob(db, policies, failingRefresher{}, nil).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count(t, db, "SELECT COUNT(*) FROM platform_stats"); n != 1 {
+		t.Errorf("daily row deleted although the rollups could not be refreshed")
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM platform_stats_hourly"); n != 0 {
+		t.Errorf("hourly row kept, it does not depend on the rollups")
+	}
+	if report.Tables[0].Error == "" || report.Tables[1].Error != "" || report.RowsDeleted != 1 {
+		t.Errorf("got report %+v, want the daily table failed and the hourly row deleted", report)
+	}
+}
@@ -0,0 +1,111 @@
+package rollup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Refresher keeps the weekly and monthly rollup tables in step with the
+// daily report tables. Each daily table has a watermark, the newest
+// created_at already rolled up; a refresh rebuilds only the buckets holding
+// rows written since.
+type Refresher struct {
+	db *sql.DB
+	// onChange runs after a refresh that rewrote buckets, e.g. to drop
+	// cached reports
+	onChange func()
+	mu       sync.Mutex
+}
+
+func NewRefresher(db *sql.DB, onChange func()) *Refresher {
+	return &Refresher{db: db, onChange: onChange}
+}
+
+// Run refreshes the rollups now and then every interval until ctx is done.
+func (r *Refresher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Failed to refresh report rollups", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh rolls up daily rows written since the last refresh and returns
+// the number of rollup rows rewritten.
+func (r *Refresher) Refresh(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var total int64
+	for _, src := range sources {
+		written, err := r.refreshSource(ctx, src)
+		if err != nil {
+			return total, fmt.Errorf("refresh %s rollups: %w", src.table, err)
+		}
+		total += written
+	}
+
+	if total > 0 {
+		slog.Info("Refreshed report rollups", "rows", total)
+		if r.onChange != nil {
+			r.onChange()
+		}
+	}
+	return total, nil
+}
+
+func (r *Refresher) refreshSource(ctx context.Context, src source) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The transaction's snapshot fixes the newest row, so rows written
+	// while the refresh runs are picked up by the next one
+	var newest sql.NullTime
+	if err := tx.QueryRowContext(ctx, "SELECT MAX(created_at) FROM "+src.table).Scan(&newest); err != nil {
+		return 0, err
+	}
+	var watermark time.Time
+	err = tx.QueryRowContext(ctx, "SELECT refreshed_through FROM rollup_watermarks WHERE source = ?", src.table).Scan(&watermark)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if !newest.Valid || !newest.Time.After(watermark) {
+		return 0, nil
+	}
+
+	var written int64
+	for _, period := range periods {
+		if _, err := tx.ExecContext(ctx, src.deleteSQL(period), watermark); err != nil {
+			return 0, err
+		}
+		result, err := tx.ExecContext(ctx, src.insertSQL(period), watermark)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		written += n
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT OR REPLACE INTO rollup_watermarks (source, refreshed_through) VALUES (?, ?)",
+		src.table, newest.Time)
+	if err != nil {
+		return 0, err
+	}
+	return written, tx.Commit()
+}
@@ -0,0 +1,134 @@
+package rollup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Period is a rollup bucket size, named after the DuckDB date_trunc unit.
+type Period struct {
+	Name string
+	Unit string
+}
+
+var (
+	Weekly  = Period{Name: "weekly", Unit: "week"}
+	Monthly = Period{Name: "monthly", Unit: "month"}
+
+	periods = []Period{Weekly, Monthly}
+)
+
+// source describes how a daily report table is rolled up. Counts are
+// summed; rates are averaged, weighted by a count column when the table
+// has one.
+type source struct {
+	table  string
+	keys   []string
+	sums   []string
+	rates  []string
+	weight string
+}
+
+var sources = []source{
+	{
+		table: "platform_stats",
+		sums: []string{
+			"total_requests", "multi_impression", "big_guidance", "addressable",
+			"compliance_strings", "deals", "tmax", "invalid_requests",
+		},
+		rates:  []string{"timeout_rate", "bid_rate"},
+		weight: "total_requests",
+	},
+	{
+		table: "content_health",
+		keys:  []string{"platform"},
+		sums: []string{
+			"total_requests", "album", "artist", "cat", "context", "data",
+			"embeddable", "episode", "genre", "id", "kwarray", "keywords", "length",
+			"language", "livestream", "season", "series", "title", "url", "videoquality",
+		},
+	},
+	{
+		table: "video_health",
+		keys:  []string{"platform"},
+		sums: []string{
+			"api", "boxing_allowed", "delivery", "h", "linearity", "max_bitrate",
+			"max_duration", "mimes", "min_bitrate", "min_cpm_per_sec", "min_duration",
+			"placement", "play_backend", "pod_dur", "pod_id", "pos", "protocols",
+			"rqd_durs", "skip", "skip_after", "skip_min", "slot_in_pod", "start_delay",
+			"w", "max_seq", "companion_ad", "companion_type", "protocol", "placement_type",
+		},
+		rates: []string{"percent_ctv"},
+	},
+}
+
+// Table returns the rollup table of a daily table for the period.
+func Table(daily string, period Period) string {
+	return daily + "_" + period.Name
+}
+
+// changedBuckets selects the buckets holding daily rows written after the
+// watermark parameter.
+func (s source) changedBuckets(period Period) string {
+	return fmt.Sprintf(
+		"SELECT DISTINCT CAST(date_trunc('%s', date) AS DATE) FROM %s WHERE created_at > ?",
+		period.Unit, s.table)
+}
+
+func (s source) deleteSQL(period Period) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE date IN (%s)", Table(s.table, period), s.changedBuckets(period))
+}
+
+// insertSQL recomputes the changed buckets from the daily rows.
+func (s source) insertSQL(period Period) string {
+	columns := []string{"date"}
+	selects := []string{fmt.Sprintf("CAST(date_trunc('%s', date) AS DATE)", period.Unit)}
+	groupBy := []string{"1"}
+
+	for i, key := range s.keys {
+		columns = append(columns, key)
+		selects = append(selects, key)
+		groupBy = append(groupBy, fmt.Sprint(i+2))
+	}
+	for _, column := range s.sums {
+		columns = append(columns, column)
+		selects = append(selects, fmt.Sprintf("SUM(%s)", column))
+	}
+	for _, column := range s.rates {
+		columns = append(columns, column)
+		if s.weight != "" {
+			selects = append(selects, fmt.Sprintf(
+				"CAST(SUM(%[1]s * %[2]s) / NULLIF(SUM(%[2]s), 0) AS DECIMAL(5,2))", column, s.weight))
+		} else {
+			selects = append(selects, fmt.Sprintf("CAST(AVG(%s) AS DECIMAL(5,2))", column))
+		}
+	}
+	columns = append(columns, "days", "created_at")
+	selects = append(selects, "COUNT(*)", "CURRENT_TIMESTAMP")
+
+	return fmt.Sprintf(
+		"INSERT INTO %s (%s)\nSELECT %s\nFROM %s\nWHERE CAST(date_trunc('%s', date) AS DATE) IN (%s)\nGROUP BY %s",
+		Table(s.table, period), strings.Join(columns, ", "),
+		strings.Join(selects, ", "), s.table, period.Unit,
+		s.changedBuckets(period), strings.Join(groupBy, ", "))
+}
+
+// DailyTables returns the daily report tables that are rolled up.
+func DailyTables() []string {
+	tables := make([]string, len(sources))
+	for i, src := range sources {
+		tables[i] = src.table
+	}
+	return tables
+}
+
+// Periods returns the rollup periods.
+func Periods() []Period {
+	return periods
+}
+
+// HourlyTable returns the hourly table of a daily table. Hourly rows are
+// loaded alongside the daily ones rather than rolled up from them.
+func HourlyTable(daily string) string {
+	return daily + "_hourly"
+}
@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"openrtb-insights/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// untracedRoutes are polled by probes and scrapers; tracing them would
+// only add noise.
+var untracedRoutes = map[string]bool{
+	"/livez":   true,
+	"/readyz":  true,
+	"/health":  true,
+	"/metrics": true,
+}
+
+// Middleware starts a server span for every request, continuing the trace
+// from an incoming traceparent header, and adds the trace ID to the request
+// logger so log lines can be matched with traces. It must run after
+// logging.RequestID.
+func Middleware(serviceName string) []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		otelgin.Middleware(serviceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+			return !untracedRoutes[c.FullPath()]
+		})),
+		correlate,
+	}
+}
+
+func correlate(c *gin.Context) {
+	ctx := c.Request.Context()
+	spanContext := trace.SpanContextFromContext(ctx)
+	if spanContext.IsValid() {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", c.GetString("request_id")))
+		ctx = logging.With(ctx, "trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String())
+		c.Request = c.Request.WithContext(ctx)
+	}
+	c.Next()
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"openrtb-insights/internal/version"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Config struct {
+	Enabled bool
+	// Endpoint is the base URL of an OTLP/HTTP collector, e.g.
+	// http://localhost:4318
+	Endpoint    string
+	ServiceName string
+	Environment string
+	// SampleRatio is the share of new traces recorded; requests arriving
+	// with a sampled parent are always recorded
+	SampleRatio float64
+}
+
+// Setup installs the W3C trace context propagator and, when tracing is
+// enabled, a tracer provider exporting spans over OTLP/HTTP. The returned
+// function flushes buffered spans and must be called on shutdown.
+//
+// With tracing disabled spans are not recorded, but trace IDs from
+// incoming traceparent headers are still propagated and logged.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.ServiceVersion(version.Version),
+			semconv.DeploymentEnvironmentName(cfg.Environment),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// RecordError marks the span as failed with err.
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,12 @@
+// Package version holds build information injected at link time:
+//
+//	go build -ldflags "-X openrtb-insights/internal/version.Version=1.4.0 \
+//	  -X openrtb-insights/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X openrtb-insights/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = ""
+)
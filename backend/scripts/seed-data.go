@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"strings"
 	"time"
 
 	"openrtb-insights/internal/config"
@@ -15,20 +16,26 @@ import (
 
 func main() {
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
 
 	// Connect to database
-	db, err := database.Connect(cfg.DBPath)
+	pools, err := database.Open(cfg.DBPath, cfg.DatabaseOptions())
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	defer database.Close(db)
+	defer pools.Close()
 
 	// Run migrations first
-	if err := database.RunMigrations(db); err != nil {
+	if err := database.RunMigrations(pools.Primary); err != nil {
 		log.Fatalf("Failed to run database migrations: %v", err)
 	}
 
+	// Imports go through the serialized write pool like every bulk writer
+	db := pools.Writes
+
 	// Seed data
 	log.Println("Starting data seeding...")
 	
@@ -44,14 +51,19 @@ func main() {
 		log.Fatalf("Failed to seed video health: %v", err)
 	}
 
+	if err := seedHourly(db); err != nil {
+		log.Fatalf("Failed to seed hourly stats: %v", err)
+	}
+
 	log.Println("Data seeding completed successfully!")
 }
 
 func seedPlatformStats(db *sql.DB) error {
 	log.Println("Seeding platform stats...")
 
-	// Generate data for the last 30 days
-	endDate := time.Now()
+	// Generate data for the last 30 days, dated in UTC like every report
+	// table
+	endDate := time.Now().UTC()
 	startDate := endDate.AddDate(0, 0, -30)
 
 	stmt, err := db.Prepare(`
@@ -96,7 +108,7 @@ func seedContentHealth(db *sql.DB) error {
 	log.Println("Seeding content health...")
 
 	platforms := []string{"CTV", "Audio"}
-	endDate := time.Now()
+	endDate := time.Now().UTC()
 	startDate := endDate.AddDate(0, 0, -30)
 
 	stmt, err := db.Prepare(`
@@ -175,7 +187,7 @@ func seedVideoHealth(db *sql.DB) error {
 	log.Println("Seeding video health...")
 
 	platforms := []string{"CTV", "Display", "App"}
-	endDate := time.Now()
+	endDate := time.Now().UTC()
 	startDate := endDate.AddDate(0, 0, -30)
 
 	stmt, err := db.Prepare(`
@@ -251,4 +263,53 @@ func seedVideoHealth(db *sql.DB) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// hourlyCounts lists the columns of each daily table that are spread over
+// its hours; the other columns are copied.
+var hourlyCounts = map[string][]string{
+	"platform_stats": {
+		"total_requests", "multi_impression", "big_guidance", "addressable",
+		"compliance_strings", "deals", "invalid_requests",
+	},
+	"content_health": {
+		"total_requests", "album", "artist", "cat", "context", "data", "embeddable",
+		"episode", "genre", "id", "kwarray", "keywords", "length", "language",
+		"livestream", "season", "series", "title", "url", "videoquality",
+	},
+	"video_health": {
+		"boxing_allowed", "delivery", "linearity", "mimes", "placement",
+		"play_backend", "protocols", "rqd_durs", "skip", "companion_ad",
+	},
+}
+
+// seedHourly spreads the last week of each daily table over its UTC hours
+// up to now, with traffic peaking in the evening.
+func seedHourly(db *sql.DB) error {
+	log.Println("Seeding hourly stats...")
+
+	now := time.Now().UTC()
+	since := now.AddDate(0, 0, -7).Format("2006-01-02")
+	for table, counts := range hourlyCounts {
+		replaced := make([]string, len(counts))
+		for i, column := range counts {
+			replaced[i] = fmt.Sprintf("CAST(%[1]s * share AS BIGINT) AS %[1]s", column)
+		}
+		query := fmt.Sprintf(`
+			INSERT OR REPLACE INTO %[1]s_hourly BY NAME
+			SELECT CAST(date AS TIMESTAMP) + to_hours(hour_of_day) AS hour,
+			       * EXCLUDE (date, created_at, hour_of_day, share) REPLACE (%[2]s)
+			FROM (
+				SELECT *, (1 + 0.6 * sin(2 * pi() * (hour_of_day - 12) / 24)) / 24 * (0.9 + random() * 0.2) AS share
+				FROM %[1]s, range(24) AS hours(hour_of_day)
+				WHERE date >= CAST(? AS DATE)
+				  AND CAST(date AS TIMESTAMP) + to_hours(hour_of_day) <= CAST(? AS TIMESTAMP)
+			)
+		`, table, strings.Join(replaced, ", "))
+		if _, err := db.Exec(query, since, now); err != nil {
+			return fmt.Errorf("failed to seed %s_hourly: %w", table, err)
+		}
+	}
+
+	return nil
+}
@@ -37,6 +37,7 @@ DB_PATH=./data/analytics.db
 
 # JWT configuration
 JWT_SECRET=$(openssl rand -hex 32)
+JWT_REFRESH_SECRET=$(openssl rand -hex 32)
 JWT_EXPIRY=15m
 REFRESH_TOKEN_EXPIRY=168h
 
@@ -47,8 +48,10 @@ CORS_ORIGINS=http://localhost:3000,http://localhost:5173
 # Logging
 LOG_LEVEL=info
 
-# Rate limiting (requests per minute per IP)
+# Rate limiting (requests per minute)
 RATE_LIMIT=100
+RATE_LIMIT_AUTH=20
+RATE_LIMIT_REPORTS=300
 EOF
     echo "✅ Created .env file with secure JWT secret"
 else
@@ -82,7 +85,7 @@ echo ""
 echo "Next steps:"
 echo "  1. Review the .env file and update configurations as needed"
 echo "  2. Start the server: ./bin/server"
-echo "  3. Or use Go directly: go run cmd/server/main.go"
+echo "  3. Or use Go directly: go run ./cmd/server"
 echo ""
 echo "The server will be available at: http://localhost:8080"
 echo "Health check: http://localhost:8080/health"
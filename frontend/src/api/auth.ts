@@ -8,7 +8,7 @@ export const authApi = {
   logout: (): Promise<{ message: string }> =>
     apiClient.post('/api/auth/logout'),
 
-  refresh: (): Promise<{ access_token: string }> =>
+  refresh: (): Promise<{ access_token: string; csrf_token: string }> =>
     apiClient.post('/api/auth/refresh'),
 
   me: (): Promise<User> =>
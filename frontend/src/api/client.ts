@@ -1,5 +1,14 @@
 const API_BASE_URL = import.meta.env.VITE_API_BASE_URL || 'http://localhost:8080';
 
+const SAFE_METHODS = ['GET', 'HEAD', 'OPTIONS'];
+
+// The backend sets a readable csrf_token cookie at login that must be echoed
+// back on state-changing requests.
+function getCsrfToken(): string | undefined {
+  const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : undefined;
+}
+
 class ApiClient {
   private baseURL: string;
 
@@ -12,14 +21,17 @@ class ApiClient {
     options: RequestInit = {}
   ): Promise<T> {
     const url = `${this.baseURL}${endpoint}`;
-    
+    const method = (options.method || 'GET').toUpperCase();
+    const csrfToken = SAFE_METHODS.includes(method) ? undefined : getCsrfToken();
+
     const config: RequestInit = {
+      ...options,
       headers: {
         'Content-Type': 'application/json',
+        ...(csrfToken ? { 'X-CSRF-Token': csrfToken } : {}),
         ...options.headers,
       },
       credentials: 'include',
-      ...options,
     };
 
     try {
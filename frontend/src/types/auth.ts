@@ -2,6 +2,7 @@ export interface User {
   id: number;
   username: string;
   role: 'Viewer' | 'Analyst' | 'Admin';
+  timezone: string;
 }
 
 export interface AuthState {
@@ -19,4 +20,5 @@ export interface LoginResponse {
   user: User;
   access_token: string;
   refresh_token: string;
+  csrf_token: string;
 }
\ No newline at end of file
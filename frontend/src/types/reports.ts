@@ -89,5 +89,7 @@ export interface ReportsResponse<T> {
     startDate?: string;
     endDate?: string;
     platform?: string;
+    granularity?: string;
+    timezone?: string;
   };
 }
\ No newline at end of file
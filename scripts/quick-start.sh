@@ -26,7 +26,7 @@ else
     echo "❌ Missing prerequisites!"
     echo "Please install either:"
     echo "  Option 1: Docker and Docker Compose (recommended)"
-    echo "  Option 2: Go 1.21+ and Node.js 18+"
+    echo "  Option 2: Go 1.24+ and Node.js 18+"
     exit 1
 fi
 
@@ -103,7 +103,7 @@ else
     
     # Start backend in background
     echo "🚀 Starting backend..."
-    go run cmd/server/main.go &
+    go run ./cmd/server &
     BACKEND_PID=$!
     
     cd ..
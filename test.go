@@ -1,10 +1,14 @@
 package main
 
 import (
+    "bytes"
     "encoding/json"
     "fmt"
+    "io"
     "log"
     "math/rand"
+    "net/http"
+    "os"
     "sort"
     "strings"
     "sync"
@@ -33,6 +37,7 @@ type ParameterMetric struct {
     PresenceCount   int64                     `json:"presence_count"`
     TotalRequests   int64                     `json:"total_requests"`
     SampleValues    []interface{}             `json:"sample_values,omitempty"`
+    FirstSeen       time.Time                 `json:"first_seen"`
     LastSeen        time.Time                 `json:"last_seen"`
     DimensionCounts map[string]*DimensionStat `json:"dimension_counts"`
 }
@@ -45,6 +50,10 @@ type AgentConfig struct {
     MaxSampleValues       int                 `json:"max_sample_values"`
     FlushIntervalSeconds  int                 `json:"flush_interval_seconds"`
     SamplingRate          float64             `json:"sampling_rate"`
+    // Backend ingest endpoint and API key (ingest:write scope); without a
+    // URL flushes are only printed
+    IngestURL             string              `json:"ingest_url,omitempty"`
+    APIKey                string              `json:"-"`
 }
 
 type EdgeAgent struct {
@@ -55,6 +64,8 @@ type EdgeAgent struct {
     totalReqs     int64
     processedReqs int64
     agentID       string
+    // Requests per dimension key, the denominator of its presence rate
+    dimensionTotals map[string]int64
 }
 
 type CloudPayload struct {
@@ -71,15 +82,19 @@ type ParameterCloudData struct {
     Path          string               `json:"path"`
     PresenceCount int64                `json:"presence_count"`
     TotalRequests int64                `json:"total_requests"`
+    FirstSeen     time.Time            `json:"first_seen"`
+    LastSeen      time.Time            `json:"last_seen"`
     SampleValues  []interface{}        `json:"sample_values,omitempty"`
     Dimensions    []DimensionCloudData `json:"dimensions"`
 }
 
 type DimensionCloudData struct {
-    DimensionKey  string  `json:"dimension_key"`
-    PresenceCount int64   `json:"presence_count"`
-    TotalRequests int64   `json:"total_requests"`
-    PresenceRate  float64 `json:"presence_rate"`
+    DimensionKey  string    `json:"dimension_key"`
+    PresenceCount int64     `json:"presence_count"`
+    TotalRequests int64     `json:"total_requests"`
+    PresenceRate  float64   `json:"presence_rate"`
+    FirstSeen     time.Time `json:"first_seen"`
+    LastSeen      time.Time `json:"last_seen"`
 }
 
 // Sample OpenRTB requests
@@ -441,9 +456,10 @@ func getSampleRequests() []string {
 func NewEdgeAgent(config *AgentConfig) *EdgeAgent {
     return &EdgeAgent{
         config:    config,
-        metrics:   make(map[string]*ParameterMetric),
-        lastFlush: time.Now(),
-        agentID:   fmt.Sprintf("agent-%d", time.Now().Unix()),
+        metrics:         make(map[string]*ParameterMetric),
+        lastFlush:       time.Now(),
+        agentID:         fmt.Sprintf("agent-%d", time.Now().Unix()),
+        dimensionTotals: make(map[string]int64),
     }
 }
 
@@ -542,8 +558,8 @@ func (ea *EdgeAgent) extractPrimaryDimensions(request map[string]interface{}) ma
         dims["request_type"] = "unknown"
     }
     
-    // Hour
-    dims["hour"] = time.Now().Hour()
+    // Hour, in UTC like the hourly report tables
+    dims["hour"] = time.Now().UTC().Hour()
     
     return dims
 }
@@ -740,11 +756,13 @@ func (ea *EdgeAgent) generateDimensionCombinations(primary, conditional map[stri
         primaryKeys := []string{"device_type", "request_type"}
         for _, pKey := range primaryKeys {
             if pValue, exists := primary[pKey]; exists {
-                // Create pairs from conditional dimensions
+                // Create pairs from conditional dimensions, sorted so the
+                // same pair is chosen for every request
                 condKeys := make([]string, 0, len(conditional))
                 for k := range conditional {
                     condKeys = append(condKeys, k)
                 }
+                sort.Strings(condKeys)
                 
                 // Take first two conditional dimensions for 3-way combo
                 if len(condKeys) >= 2 {
@@ -786,6 +804,7 @@ func (ea *EdgeAgent) ProcessRequest(requestJSON string) error {
     }
     
     ea.processedReqs++
+    ea.countDimensionTotals(request)
     
     // Extract all parameter paths
     paramPaths := ea.extractParameterPaths(request, "")
@@ -798,6 +817,25 @@ func (ea *EdgeAgent) ProcessRequest(requestJSON string) error {
     return nil
 }
 
+// Count the request once in every dimension key it falls into, whatever
+// parameters it carries
+func (ea *EdgeAgent) countDimensionTotals(request map[string]interface{}) {
+    primaryDims := ea.extractPrimaryDimensions(request)
+    seen := make(map[string]bool)
+    
+    // One representative path per conditional dimension family, plus none
+    for _, family := range []string{"", "user", "content", "video", "device"} {
+        conditionalDims := ea.extractConditionalDimensions(request, family)
+        for _, dimKey := range ea.generateDimensionCombinations(primaryDims, conditionalDims) {
+            seen[ea.dimensionKeyToString(dimKey)] = true
+        }
+    }
+    
+    for keyStr := range seen {
+        ea.dimensionTotals[keyStr]++
+    }
+}
+
 // Process individual parameter
 func (ea *EdgeAgent) processParameter(request map[string]interface{}, paramPath string) {
     // Get or create parameter metric
@@ -811,6 +849,7 @@ func (ea *EdgeAgent) processParameter(request map[string]interface{}, paramPath
         metric = &ParameterMetric{
             ParameterPath:   paramPath,
             DimensionCounts: make(map[string]*DimensionStat),
+            FirstSeen:       time.Now(),
             LastSeen:        time.Now(),
         }
         ea.metrics[paramPath] = metric
@@ -818,7 +857,6 @@ func (ea *EdgeAgent) processParameter(request map[string]interface{}, paramPath
     
     // Update basic counts
     metric.PresenceCount++
-    metric.TotalRequests++
     metric.LastSeen = time.Now()
     
     // Sample values
@@ -860,7 +898,6 @@ func (ea *EdgeAgent) updateDimensionCount(metric *ParameterMetric, dimKey Dimens
     }
     
     dimStat.PresenceCount++
-    dimStat.TotalRequests++
     dimStat.LastSeen = time.Now()
 }
 
@@ -879,23 +916,30 @@ func (ea *EdgeAgent) createCloudPayload() *CloudPayload {
         },
     }
     
-    // Convert metrics to cloud format
+    // Convert metrics to cloud format; presence is out of every processed
+    // request, or every request in the dimension key
     for _, metric := range ea.metrics {
+        metric.TotalRequests = ea.processedReqs
         paramData := ParameterCloudData{
             Path:          metric.ParameterPath,
             PresenceCount: metric.PresenceCount,
             TotalRequests: metric.TotalRequests,
+            FirstSeen:     metric.FirstSeen,
+            LastSeen:      metric.LastSeen,
             SampleValues:  metric.SampleValues,
             Dimensions:    make([]DimensionCloudData, 0, len(metric.DimensionCounts)),
         }
         
         // Convert dimension data
         for dimKey, dimStat := range metric.DimensionCounts {
+            dimStat.TotalRequests = ea.dimensionTotals[dimKey]
             dimData := DimensionCloudData{
                 DimensionKey:  dimKey,
                 PresenceCount: dimStat.PresenceCount,
                 TotalRequests: dimStat.TotalRequests,
                 PresenceRate:  float64(dimStat.PresenceCount) / float64(dimStat.TotalRequests),
+                FirstSeen:     dimStat.FirstSeen,
+                LastSeen:      dimStat.LastSeen,
             }
             paramData.Dimensions = append(paramData.Dimensions, dimData)
         }
@@ -926,8 +970,18 @@ func (ea *EdgeAgent) flush() {
     ea.lastFlush = time.Now()
     ea.totalReqs = 0
     ea.processedReqs = 0
+    ea.dimensionTotals = make(map[string]int64)
     ea.mutex.Unlock()
     
+    // Push to the backend when configured
+    if ea.config.IngestURL != "" {
+        if err := ea.push(payload); err != nil {
+            log.Printf("Failed to push flush to %s: %v", ea.config.IngestURL, err)
+        } else {
+            fmt.Printf("📤 Pushed flush to %s\n", ea.config.IngestURL)
+        }
+    }
+    
     // Print to console (simulating cloud push)
     fmt.Println("\n" + strings.Repeat("=", 80))
     fmt.Println("FLUSHING DATA TO CLOUD")
@@ -984,6 +1038,34 @@ func (ea *EdgeAgent) flush() {
     fmt.Println(strings.Repeat("=", 80) + "\n")
 }
 
+// Push payload to the backend ingest API
+func (ea *EdgeAgent) push(payload *CloudPayload) error {
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return fmt.Errorf("failed to encode payload: %v", err)
+    }
+    
+    req, err := http.NewRequest(http.MethodPost, ea.config.IngestURL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-API-Key", ea.config.APIKey)
+    
+    client := &http.Client{Timeout: 10 * time.Second}
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    if resp.StatusCode >= 300 {
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        return fmt.Errorf("ingest returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+    }
+    return nil
+}
+
 // Helper function
 func min(a, b int) int {
     if a < b {
@@ -1011,6 +1093,8 @@ func main() {
         MaxSampleValues:      5,
         FlushIntervalSeconds: 30,
         SamplingRate:         1.0,
+        IngestURL:            os.Getenv("INGEST_URL"),
+        APIKey:               os.Getenv("INGEST_API_KEY"),
     }
     
     // Create edge agent